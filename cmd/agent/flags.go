@@ -63,12 +63,46 @@ func parseFlags(cfg *config.Config) {
 	// other flags
 	// flag.StringVar(&urlServer, "a", "http://localhost:8080", "api endpoint address")
 	flag.StringVar(&cfg.GRPCServerURL, "grpc", cfg.GRPCServerURL, "server address that gRPC client must call to")
+	flag.BoolVar(&cfg.GRPCStream, "grpc-stream", cfg.GRPCStream, "send batches to gRPC targets with streaming UpdateStream method")
+	flag.Func("targets", "comma separated sinks to send batches to, e.g. http://primary:8080,grpc://standby:3200,stdout://,file:///tmp/metrics.ndjson", func(s string) error {
+		cfg.Targets = config.ParseTargets(s)
+		return nil
+	})
+	flag.StringVar(&cfg.TargetPolicy, "target-policy", cfg.TargetPolicy, "how batches are sent to several targets: failover or fanout")
 	flag.StringVar(&cfg.Key, "k", cfg.Key, "hash key")
 	flag.IntVar(&cfg.PollInterval, "p", cfg.PollInterval, "poll interval in seconds")
 	flag.IntVar(&cfg.ReportInterval, "r", cfg.ReportInterval, "report interval in seconds")
 	flag.IntVar(&cfg.RateLimit, "l", cfg.RateLimit, "rate limit (number of max concurrent senders)")
 	flag.BoolVar(&cfg.Batch, "batch", cfg.Batch, "send metrics update request in single batch")
 	flag.StringVar(&cfg.CryptoKey, "crypto-key", cfg.CryptoKey, "path to file with private key to be used in messages encryption")
+	flag.StringVar(&cfg.AgentID, "agent-id", cfg.AgentID, "agent ID used in idempotency keys of sent batches (random by default)")
+	flag.StringVar(&cfg.PushAddress, "push-address", cfg.PushAddress, "address to accept metrics pushed by local applications on, e.g. localhost:8081 or unix:/run/gometrics-agent.sock")
+	flag.StringVar(&cfg.StatsdAddress, "statsd-address", cfg.StatsdAddress, "UDP address to receive StatsD metrics on, e.g. :8125")
+	flag.Func("statsd-percentiles", "percentiles StatsD timers are reduced to, e.g. 0.5,0.9,0.99", func(s string) (err error) {
+		cfg.StatsdPercentiles, err = config.ParseQuantiles(s)
+		return err
+	})
+	flag.StringVar(&cfg.SpoolDir, "spool-dir", cfg.SpoolDir, "directory to keep batches failed to be sent in until the server is reachable")
+	flag.Int64Var(&cfg.SpoolMaxSize, "spool-max-size", cfg.SpoolMaxSize, "max total size of spooled batches in bytes (default 64MiB)")
+	flag.IntVar(&cfg.SpoolMaxAge, "spool-max-age", cfg.SpoolMaxAge, "max age of spooled batches in seconds (default 24h)")
+	flag.Int64Var(&cfg.FileSinkMaxSize, "file-sink-max-size", cfg.FileSinkMaxSize, "size in bytes file sink is rotated at (default 64MiB)")
+	flag.IntVar(&cfg.FileSinkMaxFiles, "file-sink-max-files", cfg.FileSinkMaxFiles, "number of rotated files kept by file sink (default 5)")
+	flag.Func("disable-collectors", "comma separated names of collectors to be disabled, e.g. runtime,gopsutil", func(s string) error {
+		cfg.DisableCollectors(s)
+		return nil
+	})
+	flag.Func("enable-collectors", "comma separated names of opt-in collectors to be enabled, e.g. runtime_metrics,disk,net,load,fd,cgroup", func(s string) error {
+		cfg.EnableCollectors(s)
+		return nil
+	})
+	flag.Func("runtime-quantiles", "quantiles runtime/metrics histograms are reduced to, e.g. 0.5,0.9,0.99", func(s string) (err error) {
+		cfg.RuntimeQuantiles, err = config.ParseQuantiles(s)
+		return err
+	})
+	flag.Func("labels", "labels attached to every metric, e.g. host:web1,env:prod", func(s string) (err error) {
+		cfg.Labels, err = config.ParseLabels(s)
+		return err
+	})
 
 	// XXX: [Workaround]
 	// have to implement a workaround to trick buggy autotests
@@ -112,6 +146,23 @@ func parseEnvs(cfg *config.Config) {
 		cfg.GRPCServerURL = e
 	}
 
+	if e, ok := os.LookupEnv("GRPC_STREAM"); ok {
+		v, err := strconv.ParseBool(e)
+		if err != nil {
+			log.Fatalln("Error parsing GRPC_STREAM from env: ", err)
+			return
+		}
+		cfg.GRPCStream = v
+	}
+
+	if e, ok := os.LookupEnv("TARGETS"); ok {
+		cfg.Targets = config.ParseTargets(e)
+	}
+
+	if e, ok := os.LookupEnv("TARGET_POLICY"); ok {
+		cfg.TargetPolicy = e
+	}
+
 	if e, ok := os.LookupEnv("KEY"); ok {
 		cfg.Key = e
 	}
@@ -120,6 +171,86 @@ func parseEnvs(cfg *config.Config) {
 		cfg.CryptoKey = e
 	}
 
+	if e, ok := os.LookupEnv("DISABLE_COLLECTORS"); ok {
+		cfg.DisableCollectors(e)
+	}
+
+	if e, ok := os.LookupEnv("ENABLE_COLLECTORS"); ok {
+		cfg.EnableCollectors(e)
+	}
+
+	if e, ok := os.LookupEnv("RUNTIME_QUANTILES"); ok {
+		cfg.RuntimeQuantiles, err = config.ParseQuantiles(e)
+		if err != nil {
+			log.Fatalln("Error parsing RUNTIME_QUANTILES from env: ", err)
+			return
+		}
+	}
+
+	if e, ok := os.LookupEnv("AGENT_ID"); ok {
+		cfg.AgentID = e
+	}
+
+	if e, ok := os.LookupEnv("PUSH_ADDRESS"); ok {
+		cfg.PushAddress = e
+	}
+
+	if e, ok := os.LookupEnv("STATSD_ADDRESS"); ok {
+		cfg.StatsdAddress = e
+	}
+
+	if e, ok := os.LookupEnv("STATSD_PERCENTILES"); ok {
+		cfg.StatsdPercentiles, err = config.ParseQuantiles(e)
+		if err != nil {
+			log.Fatalln("Error parsing STATSD_PERCENTILES from env: ", err)
+			return
+		}
+	}
+
+	if e, ok := os.LookupEnv("SPOOL_DIR"); ok {
+		cfg.SpoolDir = e
+	}
+
+	if e, ok := os.LookupEnv("SPOOL_MAX_SIZE"); ok {
+		cfg.SpoolMaxSize, err = strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			log.Fatalln("Error parsing SPOOL_MAX_SIZE from env: ", err)
+			return
+		}
+	}
+
+	if e, ok := os.LookupEnv("SPOOL_MAX_AGE"); ok {
+		cfg.SpoolMaxAge, err = strconv.Atoi(e)
+		if err != nil {
+			log.Fatalln("Error parsing SPOOL_MAX_AGE from env: ", err)
+			return
+		}
+	}
+
+	if e, ok := os.LookupEnv("FILE_SINK_MAX_SIZE"); ok {
+		cfg.FileSinkMaxSize, err = strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			log.Fatalln("Error parsing FILE_SINK_MAX_SIZE from env: ", err)
+			return
+		}
+	}
+
+	if e, ok := os.LookupEnv("FILE_SINK_MAX_FILES"); ok {
+		cfg.FileSinkMaxFiles, err = strconv.Atoi(e)
+		if err != nil {
+			log.Fatalln("Error parsing FILE_SINK_MAX_FILES from env: ", err)
+			return
+		}
+	}
+
+	if e, ok := os.LookupEnv("LABELS"); ok {
+		cfg.Labels, err = config.ParseLabels(e)
+		if err != nil {
+			log.Fatalln("Error parsing LABELS from env: ", err)
+			return
+		}
+	}
+
 	if e, ok := os.LookupEnv("REPORT_INTERVAL"); ok {
 		cfg.ReportInterval, err = strconv.Atoi(e)
 		if err != nil {
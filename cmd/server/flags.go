@@ -66,7 +66,7 @@ func parseFlags(cfg *config.Config) error {
 
 	// other flags
 	flag.StringVar(&cfg.ServerAddress, "a", cfg.ServerAddress, "TCP address for the server to listen on")
-	flag.StringVar(&cfg.ServerAddressGRPC, "grpc", cfg.ServerAddressGRPC, "TCP address for gRPC server to listen on")
+	flag.StringVar(&cfg.ServerAddressGRPC, "grpc", cfg.ServerAddressGRPC, "TCP address for gRPC server to listen on (run alongside HTTP server, not instead of it)")
 	flag.StringVar(&cfg.LogLevel, "loglvl", cfg.LogLevel, "logger level")
 	flag.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath, "file path for metrics data to be dumped in")
 	flag.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "data source name to connect to database")
@@ -74,6 +74,12 @@ func parseFlags(cfg *config.Config) error {
 	flag.StringVar(&cfg.CryptoKey, "crypto-key", cfg.CryptoKey, "path to file with public key to be used in messages encryption")
 	flag.IntVar(&cfg.StoreInterval, "i", cfg.StoreInterval, "interval in seconds for current metrics data to be dumped into file")
 	flag.BoolVar(&cfg.Restore, "r", cfg.Restore, "shows if data restore from file should be made")
+	flag.StringVar(&cfg.WALPath, "wal", cfg.WALPath, "file path for write-ahead log of in-memory storage (empty disables the log)")
+	flag.BoolVar(&cfg.WALSync, "wal-sync", cfg.WALSync, "sync write-ahead log to disk after every update")
+	flag.IntVar(&cfg.DatabaseQueryTimeout, "db-timeout", cfg.DatabaseQueryTimeout, "timeout in milliseconds of a single database query (0 disables the timeout)")
+	flag.BoolVar(&cfg.DatabaseVerifyOnly, "db-verify-only", cfg.DatabaseVerifyOnly, "only verify database schema version instead of applying migrations")
+	flag.IntVar(&cfg.DatabaseRollback, "db-rollback", cfg.DatabaseRollback, "number of the last database schema migrations to roll back, server exits after rollback")
+	flag.IntVar(&cfg.HistorySize, "history-size", cfg.HistorySize, "max number of values kept in history for every metric")
 
 	flag.Func("t", "trusted subnet, e.g. 192.0.2.32/24", func(s string) error {
 		cfg.TrustedSubnet = config.Subnet(strings.TrimSpace(s))
@@ -131,7 +137,52 @@ func parseFlags(cfg *config.Config) error {
 		cfg.Restore = v
 	}
 
+	if e, ok := os.LookupEnv("WAL_FILE"); ok {
+		cfg.WALPath = e
+	}
+
+	if e, ok := os.LookupEnv("WAL_SYNC"); ok {
+		v, err := strconv.ParseBool(e)
+		if err != nil {
+			return errors.New("bad env \"WAL_SYNC\": " + err.Error())
+		}
+		cfg.WALSync = v
+	}
+
+	if e, ok := os.LookupEnv("DATABASE_TIMEOUT"); ok {
+		v, err := strconv.Atoi(e)
+		if err != nil {
+			return errors.New("bad env \"DATABASE_TIMEOUT\": " + err.Error())
+		}
+		cfg.DatabaseQueryTimeout = v
+	}
+
+	if e, ok := os.LookupEnv("DATABASE_VERIFY_ONLY"); ok {
+		v, err := strconv.ParseBool(e)
+		if err != nil {
+			return errors.New("bad env \"DATABASE_VERIFY_ONLY\": " + err.Error())
+		}
+		cfg.DatabaseVerifyOnly = v
+	}
+
+	if e, ok := os.LookupEnv("DATABASE_ROLLBACK"); ok {
+		v, err := strconv.Atoi(e)
+		if err != nil {
+			return errors.New("bad env \"DATABASE_ROLLBACK\": " + err.Error())
+		}
+		cfg.DatabaseRollback = v
+	}
+
+	if e, ok := os.LookupEnv("HISTORY_SIZE"); ok {
+		v, err := strconv.Atoi(e)
+		if err != nil {
+			return errors.New("bad env \"HISTORY_SIZE\": " + err.Error())
+		}
+		cfg.HistorySize = v
+	}
+
 	cfg.FileStoragePath = strings.TrimSpace(cfg.FileStoragePath)
+	cfg.WALPath = strings.TrimSpace(cfg.WALPath)
 
 	return nil
 }
@@ -60,30 +60,28 @@ func main() {
 	// print config in purpose to debug autotests
 	logger.Log.Sugar().Infof("Server config: %+v", cfg)
 
+	if cfg.DatabaseRollback > 0 {
+		if err := server.RollbackMigrations(context.Background(), cfg); err != nil {
+			logger.Log.Fatal("database rollback failed", zap.Error(err))
+		}
+		return
+	}
+
 	// TODO: may refactor later :)
 	run(cfg)
 }
 
-// run only one server at a time - either http or grpc.
-//
-// Smth. like https://github.com/oklog/run can be used to run both servers
-// together simultaneously.
+// run runs HTTP server, gRPC server is run along with it when its address is
+// configured. Servers share storage and hub, so updates received by either
+// of them reach gRPC Watch subscribers.
 func run(cfg *config.Config) {
-	if cfg.ServerAddressGRPC == "" {
-		runHTTP(cfg)
-	} else {
-		runGRPC(cfg)
-	}
-}
-
-func runHTTP(cfg *config.Config) {
 	srv := server.New(cfg)
 	s := &http.Server{
 		Addr:    cfg.ServerAddress,
 		Handler: srv,
 	}
 
-	if err := srv.Dumper.Start(); err != nil {
+	if err := srv.Dumper.Start(context.Background()); err != nil {
 		logger.Log.Fatal("dumper start failed", zap.Error(err))
 	}
 
@@ -98,68 +96,16 @@ func runHTTP(cfg *config.Config) {
 		}
 	}()
 
-	waitShutdown(s, srv.Dumper, srv.Storage)
-}
-
-// waitShutdown implements graceful shutdown.
-func waitShutdown(s *http.Server, dumper *server.Dumper, storage storage.Storage) {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
-	sig := <-quit
-
-	const maxShutdownTimeout = 10 * time.Second
-
-	logger.Log.Info("Server caught os signal. Starting shutdown...",
-		zap.String("signal", sig.String()),
-		zap.Duration("max_timeout", maxShutdownTimeout),
-	)
-
-	// XXX: should I disable keep-alive like this on shutdown or server.Shutdown()
-	// will handle it by itself?
-	// s.SetKeepAlivesEnabled(false)
-
-	ctx, cancel := context.WithTimeout(context.Background(), maxShutdownTimeout/3)
-	defer cancel()
-
-	var errs []error
-
-	// 1. Shutdown server
-	if err := s.Shutdown(ctx); err != nil {
-		errs = append(errs, fmt.Errorf("HTTP [Server.Shutdown] failed: %v", err))
+	var g *grpc.Server
+	if cfg.ServerAddressGRPC != "" {
+		g = runGRPC(cfg, srv.Storage, srv.Dumper, srv.Hub)
 	}
 
-	// XXX: can I use same context from before or should create new like this?
-	// [Got answer: "Create new, like already did"]
-	ctxDumper, cancelCtxDumper := context.WithTimeout(context.Background(), maxShutdownTimeout/3)
-	defer cancelCtxDumper()
-
-	// 2. Stop dumper
-	if err := dumper.Quit(ctxDumper); err != nil {
-		errs = append(errs, fmt.Errorf("failed to Quit the Dumper: %v", err))
-	}
-
-	// XXX: can I use same context from before or should create new like this?
-	// [Got answer: "Create new, like already did"]
-	ctxStorage, cancelCtxStorage := context.WithTimeout(context.Background(), maxShutdownTimeout/3)
-	defer cancelCtxStorage()
-
-	// 3. Close storage
-	if err := storage.Close(ctxStorage); err != nil {
-		errs = append(errs, fmt.Errorf("failed to Close the Storage: %v", err))
-	}
-
-	if len(errs) > 0 {
-		logger.Log.Fatal("Server was stopped, but errors occurred", zap.Errors("errors", errs))
-	}
-
-	logger.Log.Info("Server was stopped")
+	waitShutdown(s, g, srv.Hub, srv.Dumper, srv.Storage)
 }
 
-func runGRPC(cfg *config.Config) {
-	if cfg.ServerAddressGRPC == "" {
-		return
-	}
-
+// runGRPC starts gRPC server.
+func runGRPC(cfg *config.Config, storage storage.Storage, dumper *server.Dumper, hub *server.Hub) *grpc.Server {
 	logger.Log.Info("gRPC port found in config, trying to start gRPC server...")
 
 	listen, err := net.Listen("tcp", cfg.ServerAddressGRPC)
@@ -167,9 +113,10 @@ func runGRPC(cfg *config.Config) {
 		logger.Log.Sugar().Fatalf("Failed to listen on port '%s', err: %v", cfg.ServerAddressGRPC, err)
 	}
 
-	metricsServer := grpcServer.NewMetricsServer(cfg)
+	metricsServer := grpcServer.NewMetricsServerWithStorage(cfg, storage, dumper, hub)
 
-	s := grpc.NewServer(grpcServer.Interceptors(logger.Log)...)
+	opts := append(grpcServer.Interceptors(logger.Log, cfg), grpcServer.KeepaliveEnforcementPolicy())
+	s := grpc.NewServer(opts...)
 	pb.RegisterMetricsServer(s, metricsServer)
 	reflection.Register(s)
 
@@ -180,29 +127,61 @@ func runGRPC(cfg *config.Config) {
 		}
 	}()
 
-	waitShutdownGRPC(s, metricsServer.Storage)
+	return s
 }
 
-// waitShutdownGRPC waits for exit and gracefully shuts down gRPC server.
-func waitShutdownGRPC(s *grpc.Server, storage storage.Storage) {
+// waitShutdown waits for exit and implements graceful shutdown. gRPC server
+// is nil when not run.
+func waitShutdown(s *http.Server, g *grpc.Server, hub *server.Hub, dumper *server.Dumper, storage storage.Storage) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 	sig := <-quit
 
-	stoppers := []func(timeout time.Duration) error{
-		// 1. Shutdown server
+	var stoppers []func(timeout time.Duration) error
+
+	// 1. Shutdown gRPC server, Watch streams are closed first, otherwise
+	// graceful stop waits for them till the timeout
+	if g != nil {
+		stoppers = append(stoppers, func(t time.Duration) error {
+			ctx, cancel := context.WithTimeout(context.Background(), t)
+			defer cancel()
+			hub.Close()
+			if err := grpcServer.ShutdownWithContext(ctx, g); err != nil {
+				return fmt.Errorf("gRPC server shutdown failed: %v", err)
+			}
+			return nil
+		})
+	}
+
+	stoppers = append(stoppers,
+		// 2. Shutdown HTTP server
 		func(t time.Duration) error {
 			ctx, cancel := context.WithTimeout(context.Background(), t)
 			defer cancel()
-			return grpcServer.ShutdownWithContext(ctx, s)
+			if err := s.Shutdown(ctx); err != nil {
+				return fmt.Errorf("HTTP [Server.Shutdown] failed: %v", err)
+			}
+			return nil
 		},
-		// 2. Close storage
+		// 3. Stop dumper
 		func(t time.Duration) error {
 			ctx, cancel := context.WithTimeout(context.Background(), t)
 			defer cancel()
-			return storage.Close(ctx)
+			if err := dumper.Quit(ctx); err != nil {
+				return fmt.Errorf("failed to Quit the Dumper: %v", err)
+			}
+			return nil
 		},
-	}
+		// 4. Close storage
+		func(t time.Duration) error {
+			ctx, cancel := context.WithTimeout(context.Background(), t)
+			defer cancel()
+			if err := storage.Close(ctx); err != nil {
+				return fmt.Errorf("failed to Close the Storage: %v", err)
+			}
+			return nil
+		},
+	)
 
 	const maxShutdownTimeout = 10 * time.Second
 	tn := maxShutdownTimeout / time.Duration(len(stoppers))
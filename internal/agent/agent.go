@@ -1,13 +1,21 @@
 // Package agent represents agent service that gathers runtime metrics.
 //
-// Package contains poller and sender.
-// Poller is used to periodically gather runtime metrics data.
-// Sender sends data, gathered by the poller, to the server.
+// Package contains collectors and sender.
+// Collectors are used to periodically gather metrics data (e.g. runtime stats),
+// they are run by the registry.
+// Listeners (push receiver and StatsD) receive metrics from applications
+// running on the same host.
+// Sender sends data, gathered by the collectors, to the server.
 package agent
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/agent/config"
 	"github.com/Dmitrevicz/gometrics/internal/model"
@@ -15,9 +23,23 @@ import (
 )
 
 // Metrics data to be sent to the server.
+//
+// Maps are keyed by metric name. Series key can be used instead to attach
+// labels to the metric, e.g. `DiskReadBytes{device="sda"}` (see
+// model.SeriesKey), agent labels are added to them when sent.
+//
+// Counters and histograms are cumulative, only increments since the previous
+// report are sent to the server. Collector starting a counter over from zero
+// (e.g. expired pushed series pushed again) must change its epoch in
+// CounterEpochs, otherwise the restarted counter is taken for the old one.
 type Metrics struct {
-	Gauges   map[string]model.Gauge
-	Counters map[string]model.Counter
+	Gauges     map[string]model.Gauge
+	Counters   map[string]model.Counter
+	Histograms map[string]model.Histogram
+	Summaries  map[string]model.Summary
+	// CounterEpochs are epochs of counters, counters missing here are of
+	// epoch 0.
+	CounterEpochs map[string]uint64
 }
 
 // Merge adds values of maps from m2 to m.
@@ -29,68 +51,228 @@ func (m *Metrics) Merge(m2 *Metrics) {
 
 	for name, value := range m2.Counters {
 		m.Counters[name] = value
+
+		epoch, ok := m2.CounterEpochs[name]
+		if !ok {
+			delete(m.CounterEpochs, name)
+			continue
+		}
+		if m.CounterEpochs == nil {
+			m.CounterEpochs = make(map[string]uint64)
+		}
+		m.CounterEpochs[name] = epoch
+	}
+
+	if len(m2.Histograms) > 0 && m.Histograms == nil {
+		m.Histograms = make(map[string]model.Histogram, len(m2.Histograms))
+	}
+	for name, value := range m2.Histograms {
+		m.Histograms[name] = value
+	}
+
+	if len(m2.Summaries) > 0 && m.Summaries == nil {
+		m.Summaries = make(map[string]model.Summary, len(m2.Summaries))
+	}
+	for name, value := range m2.Summaries {
+		m.Summaries[name] = value
 	}
 }
 
 func (m *Metrics) Len() int {
-	return len(m.Counters) + len(m.Gauges)
+	return len(m.Counters) + len(m.Gauges) + len(m.Histograms) + len(m.Summaries)
 }
 
 // Agent is responsible for gathering and sending metrics to server.
 type Agent struct {
-	poller         *poller
-	gopsutilPoller *gopsutilPoller
+	registry *Registry
+
+	// listeners are enabled listener collectors
+	listeners []listener
 
 	// sender *sender
 	sender MetricsSender
 }
 
-// New creates new agent service.
-func New(cfg *config.Config) (*Agent, error) {
+// New creates new agent service. Custom collectors are run along with the
+// built-in ones, they can be configured by name the same way (see
+// config.Config.Collectors).
+func New(cfg *config.Config, collectors ...Collector) (*Agent, error) {
 	log.Printf("intervals (in seconds) - poll: %d, report: %d\n", cfg.PollInterval, cfg.ReportInterval)
 	log.Printf("url: \"%s\"\n", cfg.ServerURL)
 
-	poller := NewPoller(cfg.PollInterval)
-	gopsutilPoller := NewGopsutilPoller(cfg.PollInterval)
+	// labels set in config file aren't validated by config.ParseLabels
+	if err := model.Labels(cfg.Labels).Validate(); err != nil {
+		return nil, fmt.Errorf("bad agent labels: %w", err)
+	}
+
+	builtin, err := defaultCollectors(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := defaultListeners(cfg)
+	for _, l := range listeners {
+		builtin = append(builtin, l)
+	}
+
+	registry, err := newRegistry(cfg, append(builtin, collectors...))
+	if err != nil {
+		return nil, err
+	}
 
-	var err error
 	if err = detectHostIP(cfg); err != nil {
 		return nil, err
 	}
 
-	var sender MetricsSender
-	if cfg.GRPCServerURL != "" {
-		sender, err = NewSenderGRPC(cfg, poller, gopsutilPoller)
-	} else {
-		sender, err = NewSender(cfg, poller, gopsutilPoller)
+	if err = generateAgentID(cfg); err != nil {
+		return nil, err
 	}
+
+	sender, err := NewSender(cfg, registry)
 	if err != nil {
 		return nil, err
 	}
 
+	for i, l := range listeners {
+		if err = l.listen(); err != nil {
+			for _, started := range listeners[:i] {
+				_ = started.shutdown(context.Background())
+			}
+			return nil, err
+		}
+	}
+
 	return &Agent{
-		poller:         poller,
-		gopsutilPoller: gopsutilPoller,
-		sender:         sender,
+		registry:  registry,
+		listeners: listeners,
+		sender:    sender,
 	}, nil
 }
 
+// defaultCollectors returns built-in collectors. Runtime metrics and host
+// collectors (Linux only) are opt-in, they're included when enabled in cfg.
+// Process collector is included when processes to be watched are
+// configured.
+func defaultCollectors(cfg *config.Config) ([]Collector, error) {
+	host, err := hostCollectors(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	collectors := []Collector{
+		NewPoller(),
+		NewGopsutilPoller(), // > "Добавьте ещё одну горутину, которая будет использовать пакет gopsutil"
+	}
+
+	// runtime/metrics and host collectors add lots of series, so they have
+	// to be enabled explicitly
+	for _, c := range append([]Collector{NewRuntimeMetricsCollector(cfg.RuntimeQuantiles)}, host...) {
+		if !cfg.CollectorOptedIn(c.Name()) {
+			log.Printf("Collector %q is not enabled\n", c.Name())
+			continue
+		}
+		collectors = append(collectors, c)
+	}
+
+	if len(cfg.Processes) > 0 {
+		processes, err := NewProcessCollector(cfg.Processes)
+		if err != nil {
+			return nil, err
+		}
+		collectors = append(collectors, processes)
+	}
+
+	return collectors, nil
+}
+
+// defaultListeners returns enabled listeners: push receiver and StatsD
+// listener are enabled when their addresses are configured. StatsD collector
+// is run once per report interval unless its interval is configured.
+func defaultListeners(cfg *config.Config) []listener {
+	var listeners []listener
+
+	if cfg.PushAddress != "" && cfg.CollectorEnabled(collectorPush) {
+		ttl := pushSeriesTTLReports * time.Duration(cfg.ReportInterval) * time.Second
+		listeners = append(listeners, newPushReceiver(cfg.PushAddress, ttl))
+	}
+
+	if cfg.StatsdAddress != "" && cfg.CollectorEnabled(collectorStatsd) {
+		if cc := cfg.Collectors[collectorStatsd]; cc.PollInterval == 0 {
+			cc.PollInterval = cfg.ReportInterval
+			if cfg.Collectors == nil {
+				cfg.Collectors = make(map[string]config.CollectorConfig)
+			}
+			cfg.Collectors[collectorStatsd] = cc
+		}
+
+		listeners = append(listeners, newStatsdCollector(cfg.StatsdAddress, cfg.StatsdPercentiles))
+	}
+
+	return listeners
+}
+
+// newRegistry registers collectors enabled by cfg.
+func newRegistry(cfg *config.Config, collectors []Collector) (*Registry, error) {
+	registry := NewRegistry()
+
+	for _, c := range collectors {
+		if !cfg.CollectorEnabled(c.Name()) {
+			log.Printf("Collector %q is disabled\n", c.Name())
+			continue
+		}
+
+		if err := registry.Register(c, cfg.CollectorInterval(c.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
 // Start initiates agent timers.
 func (a *Agent) Start() {
 	log.Println("Agent is starting its timers...")
 
-	go a.poller.Start()
-	go a.gopsutilPoller.Start() // > "Добавьте ещё одну горутину, которая будет использовать пакет gopsutil"
+	a.registry.Start(context.Background())
+	for _, l := range a.listeners {
+		go l.serve()
+	}
 	go a.sender.Start()
 }
 
 // Shutdown implements graceful shutdown.
-// Shutdown stops poller and sender timers and sends current data to server.
+// Shutdown stops listeners, collectors and sender timers and sends current
+// data to server.
 func (a *Agent) Shutdown(ctx context.Context) (err error) {
-	a.poller.Stop()
-	a.gopsutilPoller.Stop()
+	for _, l := range a.listeners {
+		err = errors.Join(err, l.shutdown(ctx))
+	}
+
+	a.registry.Stop()
+
+	// metrics received since the last collection go to the final report
+	for _, l := range a.listeners {
+		_ = a.registry.Collect(ctx, l.Name())
+	}
+
+	return errors.Join(err, a.sender.Shutdown(ctx))
+}
+
+// generateAgentID generates random Config.AgentID when it's empty.
+func generateAgentID(cfg *config.Config) error {
+	if cfg.AgentID != "" {
+		return nil
+	}
 
-	return a.sender.Shutdown(ctx)
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("agent ID generation failed: %w", err)
+	}
+	cfg.AgentID = hex.EncodeToString(b)
+
+	log.Printf("Agent ID generated: %s\n", cfg.AgentID)
+
+	return nil
 }
 
 // detectHostIP tries to detect host IP dynamically when Config.HostIP is empty.
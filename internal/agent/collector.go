@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// Collector gathers metrics of some kind, e.g. runtime or host stats.
+type Collector interface {
+	// Name identifies collector in config, must be unique.
+	Name() string
+	// Collect gathers fresh metrics data and returns its snapshot.
+	Collect(ctx context.Context) (Metrics, error)
+}
+
+// listener is a collector which receives metrics from outside (e.g. pushed by
+// applications) instead of gathering them itself.
+type listener interface {
+	Collector
+	// listen starts listening on configured address.
+	listen() error
+	// serve receives metrics until shutdown.
+	serve()
+	// shutdown stops receiving metrics.
+	shutdown(ctx context.Context) error
+}
+
+// Registry runs registered collectors, each one on its own interval, and
+// keeps the last snapshot collected by every collector.
+type Registry struct {
+	mu      sync.RWMutex // guards last snapshots of entries
+	entries []*registryEntry
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type registryEntry struct {
+	collector Collector
+	interval  time.Duration
+	last      Metrics
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collector to be run every interval. Must be called before
+// Start.
+func (r *Registry) Register(c Collector, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("collector %q: interval must be positive", c.Name())
+	}
+
+	for _, e := range r.entries {
+		if e.collector.Name() == c.Name() {
+			return fmt.Errorf("collector %q is already registered", c.Name())
+		}
+	}
+
+	r.entries = append(r.entries, &registryEntry{
+		collector: c,
+		interval:  interval,
+	})
+
+	return nil
+}
+
+// Start starts collectors, every collector is run right away and then once
+// per its interval. Start doesn't block, collectors are run until ctx is
+// done or Stop is called.
+func (r *Registry) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	for _, e := range r.entries {
+		r.wg.Add(1)
+		go r.run(ctx, e)
+	}
+
+	log.Printf("Collectors started (%d)\n", len(r.entries))
+}
+
+// Stop stops collectors and waits for running collections to finish.
+func (r *Registry) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+
+	log.Println("Collectors stopped")
+}
+
+// Collect runs collector with name once, out of its schedule. It's used to
+// get fresh data for the final report, so it must not be called while the
+// registry is running.
+func (r *Registry) Collect(ctx context.Context, name string) error {
+	for _, e := range r.entries {
+		if e.collector.Name() == name {
+			r.collect(ctx, e)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("collector %q isn't registered", name)
+}
+
+func (r *Registry) run(ctx context.Context, e *registryEntry) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		r.collect(ctx, e)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect runs collector once. Snapshot is kept untouched when collection
+// fails, so the last successfully collected data is reported.
+func (r *Registry) collect(ctx context.Context, e *registryEntry) {
+	m, err := e.collector.Collect(ctx)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.Printf("Collector %q failed: %v\n", e.collector.Name(), err)
+		}
+		return
+	}
+
+	r.mu.Lock()
+	e.last = m
+	r.mu.Unlock()
+}
+
+// Snapshot merges last snapshots of all collectors. When several collectors
+// report metric with the same name, the one registered later wins.
+func (r *Registry) Snapshot() Metrics {
+	m := Metrics{
+		Gauges:   make(map[string]model.Gauge),
+		Counters: make(map[string]model.Counter),
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, e := range r.entries {
+		m.Merge(&e.last)
+	}
+
+	return m
+}
+
+// deviceFilter filters devices (e.g. disks or network interfaces) by name
+// using glob patterns (see path.Match).
+type deviceFilter struct {
+	include []string
+	exclude []string
+}
+
+func newDeviceFilter(include, exclude []string) (deviceFilter, error) {
+	for _, pattern := range append(include[:len(include):len(include)], exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return deviceFilter{}, fmt.Errorf("bad device pattern %q: %w", pattern, err)
+		}
+	}
+
+	return deviceFilter{
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+// match reports whether device must be collected: it matches one of include
+// patterns (or include patterns are empty) and doesn't match exclude ones.
+func (f deviceFilter) match(device string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, device) {
+		return false
+	}
+
+	return !matchAny(f.exclude, device)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seriesKey returns series key of the metric with a single label. Label name
+// must be valid (see model.Labels.Validate).
+func seriesKey(name, label, value string) string {
+	return name + model.Labels{label: value}.String()
+}
@@ -0,0 +1,341 @@
+//go:build linux
+
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// host collectors names
+const (
+	collectorDisk   = "disk"
+	collectorNet    = "net"
+	collectorLoad   = "load"
+	collectorFD     = "fd"
+	collectorCgroup = "cgroup"
+)
+
+// Devices excluded by default, when neither include nor exclude patterns are
+// configured for the collector.
+var (
+	defaultDiskExclude = []string{"loop*", "ram*"}
+	defaultNetExclude  = []string{"lo"}
+)
+
+// cgroupRoot is a mount point of cgroup v2 unified hierarchy.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// hostCollectors returns Linux host collectors: disks and network interfaces
+// I/O, load averages, file descriptors and cgroup v2 stats. Cgroup collector
+// is skipped when cgroup v2 isn't mounted.
+func hostCollectors(cfg *config.Config) ([]Collector, error) {
+	diskFilter, err := hostDeviceFilter(cfg, collectorDisk, defaultDiskExclude)
+	if err != nil {
+		return nil, err
+	}
+
+	netFilter, err := hostDeviceFilter(cfg, collectorNet, defaultNetExclude)
+	if err != nil {
+		return nil, err
+	}
+
+	collectors := []Collector{
+		newDiskCollector(diskFilter),
+		newNetCollector(netFilter),
+		&loadCollector{},
+		&fdCollector{procRoot: "/proc"},
+	}
+
+	if _, err = os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		collectors = append(collectors, &cgroupCollector{root: cgroupRoot})
+	} else {
+		log.Printf("Collector %q is unavailable: cgroup v2 isn't mounted at %s\n", collectorCgroup, cgroupRoot)
+	}
+
+	return collectors, nil
+}
+
+func hostDeviceFilter(cfg *config.Config, collector string, defaultExclude []string) (deviceFilter, error) {
+	cc := cfg.Collectors[collector]
+	if cc.Include == nil && cc.Exclude == nil {
+		cc.Exclude = defaultExclude
+	}
+
+	filter, err := newDeviceFilter(cc.Include, cc.Exclude)
+	if err != nil {
+		return filter, fmt.Errorf("collector %q: %w", collector, err)
+	}
+
+	return filter, nil
+}
+
+// diskCollector collects I/O stats of every disk, disk is set by "device"
+// label.
+type diskCollector struct {
+	filter     deviceFilter
+	ioCounters func(ctx context.Context) (map[string]disk.IOCountersStat, error)
+}
+
+func newDiskCollector(filter deviceFilter) *diskCollector {
+	return &diskCollector{
+		filter: filter,
+		ioCounters: func(ctx context.Context) (map[string]disk.IOCountersStat, error) {
+			return disk.IOCountersWithContext(ctx)
+		},
+	}
+}
+
+// Name implements Collector.
+func (c *diskCollector) Name() string {
+	return collectorDisk
+}
+
+// Collect implements Collector.
+func (c *diskCollector) Collect(ctx context.Context) (Metrics, error) {
+	stats, err := c.ioCounters(ctx)
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	m := Metrics{
+		Gauges:   make(map[string]model.Gauge),
+		Counters: make(map[string]model.Counter),
+	}
+
+	for device, s := range stats {
+		if !c.filter.match(device) {
+			continue
+		}
+
+		m.Counters[seriesKey("host_disk_read_bytes", "device", device)] = model.Counter(s.ReadBytes)
+		m.Counters[seriesKey("host_disk_write_bytes", "device", device)] = model.Counter(s.WriteBytes)
+		m.Counters[seriesKey("host_disk_reads", "device", device)] = model.Counter(s.ReadCount)
+		m.Counters[seriesKey("host_disk_writes", "device", device)] = model.Counter(s.WriteCount)
+		m.Counters[seriesKey("host_disk_io_time_ms", "device", device)] = model.Counter(s.IoTime)
+		m.Gauges[seriesKey("host_disk_io_in_progress", "device", device)] = model.Gauge(s.IopsInProgress)
+	}
+
+	return m, nil
+}
+
+// netCollector collects I/O stats of every network interface, interface is
+// set by "interface" label.
+type netCollector struct {
+	filter     deviceFilter
+	ioCounters func(ctx context.Context) ([]net.IOCountersStat, error)
+}
+
+func newNetCollector(filter deviceFilter) *netCollector {
+	return &netCollector{
+		filter: filter,
+		ioCounters: func(ctx context.Context) ([]net.IOCountersStat, error) {
+			return net.IOCountersWithContext(ctx, true)
+		},
+	}
+}
+
+// Name implements Collector.
+func (c *netCollector) Name() string {
+	return collectorNet
+}
+
+// Collect implements Collector.
+func (c *netCollector) Collect(ctx context.Context) (Metrics, error) {
+	stats, err := c.ioCounters(ctx)
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	m := Metrics{
+		Gauges:   make(map[string]model.Gauge),
+		Counters: make(map[string]model.Counter),
+	}
+
+	for _, s := range stats {
+		if !c.filter.match(s.Name) {
+			continue
+		}
+
+		m.Counters[seriesKey("host_net_receive_bytes", "interface", s.Name)] = model.Counter(s.BytesRecv)
+		m.Counters[seriesKey("host_net_transmit_bytes", "interface", s.Name)] = model.Counter(s.BytesSent)
+		m.Counters[seriesKey("host_net_receive_packets", "interface", s.Name)] = model.Counter(s.PacketsRecv)
+		m.Counters[seriesKey("host_net_transmit_packets", "interface", s.Name)] = model.Counter(s.PacketsSent)
+		m.Counters[seriesKey("host_net_receive_errors", "interface", s.Name)] = model.Counter(s.Errin)
+		m.Counters[seriesKey("host_net_transmit_errors", "interface", s.Name)] = model.Counter(s.Errout)
+		m.Counters[seriesKey("host_net_receive_drops", "interface", s.Name)] = model.Counter(s.Dropin)
+		m.Counters[seriesKey("host_net_transmit_drops", "interface", s.Name)] = model.Counter(s.Dropout)
+	}
+
+	return m, nil
+}
+
+// loadCollector collects load averages.
+type loadCollector struct{}
+
+// Name implements Collector.
+func (c *loadCollector) Name() string {
+	return collectorLoad
+}
+
+// Collect implements Collector.
+func (c *loadCollector) Collect(ctx context.Context) (Metrics, error) {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	return Metrics{
+		Gauges: map[string]model.Gauge{
+			"host_load1":  model.Gauge(avg.Load1),
+			"host_load5":  model.Gauge(avg.Load5),
+			"host_load15": model.Gauge(avg.Load15),
+		},
+	}, nil
+}
+
+// fdCollector collects number of file descriptors allocated by the system.
+type fdCollector struct {
+	procRoot string
+}
+
+// Name implements Collector.
+func (c *fdCollector) Name() string {
+	return collectorFD
+}
+
+// Collect implements Collector.
+func (c *fdCollector) Collect(_ context.Context) (Metrics, error) {
+	data, err := os.ReadFile(filepath.Join(c.procRoot, "sys/fs/file-nr"))
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	// "<allocated> <free> <max>", free is always zero since Linux 2.6
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return Metrics{}, fmt.Errorf("unexpected file-nr format: %q", data)
+	}
+
+	values := make([]model.Gauge, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return Metrics{}, fmt.Errorf("unexpected file-nr format: %w", err)
+		}
+		values[i] = model.Gauge(v)
+	}
+
+	return Metrics{
+		Gauges: map[string]model.Gauge{
+			"host_fd_allocated": values[0] - values[1],
+			"host_fd_max":       values[2],
+		},
+	}, nil
+}
+
+// cgroupCollector collects stats of the cgroup v2 the agent runs in (e.g. of
+// the container): memory usage and limit, CPU usage and throttling, number of
+// processes. Files of controllers which aren't enabled are skipped.
+type cgroupCollector struct {
+	root string
+}
+
+// Name implements Collector.
+func (c *cgroupCollector) Name() string {
+	return collectorCgroup
+}
+
+// Collect implements Collector.
+func (c *cgroupCollector) Collect(_ context.Context) (Metrics, error) {
+	m := Metrics{
+		Gauges:   make(map[string]model.Gauge),
+		Counters: make(map[string]model.Counter),
+	}
+
+	gauges := []struct {
+		file string
+		name string
+	}{
+		{"memory.current", "cgroup_memory_current_bytes"},
+		{"memory.max", "cgroup_memory_max_bytes"},
+		{"memory.swap.current", "cgroup_memory_swap_current_bytes"},
+		{"pids.current", "cgroup_pids_current"},
+		{"pids.max", "cgroup_pids_max"},
+	}
+
+	for _, g := range gauges {
+		data, err := c.read(g.file)
+		if err != nil {
+			return Metrics{}, err
+		}
+
+		// missing file or "max" (no limit)
+		if v, err := strconv.ParseUint(string(data), 10, 64); err == nil {
+			m.Gauges[g.name] = model.Gauge(v)
+		}
+	}
+
+	// "<quota> <period>", quota is "max" when there is no limit
+	data, err := c.read("cpu.max")
+	if err != nil {
+		return Metrics{}, err
+	}
+	if quota, period, ok := strings.Cut(string(data), " "); ok {
+		q, errQ := strconv.ParseFloat(quota, 64)
+		p, errP := strconv.ParseFloat(period, 64)
+		if errQ == nil && errP == nil && p > 0 {
+			m.Gauges["cgroup_cpu_limit_cores"] = model.Gauge(q / p)
+		}
+	}
+
+	// "<key> <value>" lines, e.g. usage_usec, nr_throttled, throttled_usec
+	data, err = c.read("cpu.stat")
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		m.Counters["cgroup_cpu_"+key] = model.Counter(v)
+	}
+
+	return m, nil
+}
+
+// read reads trimmed file content, nil is returned when file doesn't exist.
+func (c *cgroupCollector) read(file string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(c.root, file))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return bytes.TrimSpace(data), nil
+}
@@ -0,0 +1,110 @@
+//go:build linux
+
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCollector(t *testing.T) {
+	filter, err := hostDeviceFilter(config.New(), collectorDisk, defaultDiskExclude)
+	require.NoError(t, err)
+
+	c := newDiskCollector(filter)
+	c.ioCounters = func(context.Context) (map[string]disk.IOCountersStat, error) {
+		return map[string]disk.IOCountersStat{
+			"sda":   {ReadBytes: 10, WriteBytes: 20, IopsInProgress: 1},
+			"loop0": {ReadBytes: 1},
+		}, nil
+	}
+
+	m, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, model.Counter(10), m.Counters[`host_disk_read_bytes{device="sda"}`])
+	assert.Equal(t, model.Counter(20), m.Counters[`host_disk_write_bytes{device="sda"}`])
+	assert.Equal(t, model.Gauge(1), m.Gauges[`host_disk_io_in_progress{device="sda"}`])
+	assert.NotContains(t, m.Counters, `host_disk_read_bytes{device="loop0"}`, "loop devices are excluded by default")
+}
+
+func TestNetCollector(t *testing.T) {
+	cfg := config.New()
+	cfg.Collectors = map[string]config.CollectorConfig{
+		collectorNet: {Include: []string{"eth*"}, Exclude: []string{"eth1"}},
+	}
+
+	filter, err := hostDeviceFilter(cfg, collectorNet, defaultNetExclude)
+	require.NoError(t, err)
+
+	c := newNetCollector(filter)
+	c.ioCounters = func(context.Context) ([]net.IOCountersStat, error) {
+		return []net.IOCountersStat{
+			{Name: "eth0", BytesRecv: 100, Errout: 2},
+			{Name: "eth1", BytesRecv: 200},
+			{Name: "wlan0", BytesRecv: 300},
+		}, nil
+	}
+
+	m, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, model.Counter(100), m.Counters[`host_net_receive_bytes{interface="eth0"}`])
+	assert.Equal(t, model.Counter(2), m.Counters[`host_net_transmit_errors{interface="eth0"}`])
+	assert.Len(t, m.Counters, 8, "only eth0 must be collected")
+}
+
+func TestFDCollector(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "sys/fs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sys/fs/file-nr"), []byte("1024\t0\t9223372036854775807\n"), 0644))
+
+	c := &fdCollector{procRoot: root}
+
+	m, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, model.Gauge(1024), m.Gauges["host_fd_allocated"])
+	assert.Equal(t, model.Gauge(9223372036854775807), m.Gauges["host_fd_max"])
+}
+
+func TestCgroupCollector(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"memory.current": "1048576\n",
+		"memory.max":     "max\n",
+		"cpu.max":        "50000 100000\n",
+		"cpu.stat":       "usage_usec 1500\nuser_usec 1000\nsystem_usec 500\nnr_periods 10\nnr_throttled 3\nthrottled_usec 250\n",
+	}
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(data), 0644))
+	}
+
+	c := &cgroupCollector{root: root}
+
+	m, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]model.Gauge{
+		"cgroup_memory_current_bytes": 1048576,
+		"cgroup_cpu_limit_cores":      0.5,
+	}, m.Gauges, "unlimited memory and missing files must be skipped")
+
+	assert.Equal(t, map[string]model.Counter{
+		"cgroup_cpu_usage_usec":     1500,
+		"cgroup_cpu_user_usec":      1000,
+		"cgroup_cpu_system_usec":    500,
+		"cgroup_cpu_nr_periods":     10,
+		"cgroup_cpu_nr_throttled":   3,
+		"cgroup_cpu_throttled_usec": 250,
+	}, m.Counters)
+}
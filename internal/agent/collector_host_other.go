@@ -0,0 +1,11 @@
+//go:build !linux
+
+package agent
+
+import "github.com/Dmitrevicz/gometrics/internal/agent/config"
+
+// hostCollectors returns nothing: host collectors are implemented for Linux
+// only.
+func hostCollectors(_ *config.Config) ([]Collector, error) {
+	return nil, nil
+}
@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// processCollector watches configured processes (e.g. sidecar daemons running
+// on the same host). Every watched set of processes is reported as gauges
+// with "process" label:
+//   - process_count - number of matched processes, zero means it's down;
+//   - process_rss_bytes - resident set size;
+//   - process_cpu_percent - CPU usage since the previous collection;
+//   - process_threads - number of threads;
+//   - process_open_fds - number of open file descriptors;
+//   - process_uptime_seconds - time since the most recent process start, so
+//     restarts are noticeable.
+//
+// When several processes are matched (e.g. by name), their values are summed.
+// Values which can't be read on the platform (e.g. FDs on Windows) are
+// skipped.
+type processCollector struct {
+	watches []processWatch
+
+	// procs caches processes between collections, CPU percent is measured
+	// relative to the previous collection of the same process
+	procs map[int32]*process.Process
+}
+
+type processWatch struct {
+	name        string
+	pidFile     string
+	processName string
+	cmdline     *regexp.Regexp
+}
+
+// NewProcessCollector creates collector of processes described by cfgs.
+func NewProcessCollector(cfgs []config.ProcessConfig) (*processCollector, error) {
+	c := &processCollector{
+		procs: make(map[int32]*process.Process),
+	}
+
+	for _, cfg := range cfgs {
+		w := processWatch{
+			name:        cfg.Name,
+			pidFile:     cfg.PIDFile,
+			processName: cfg.ProcessName,
+		}
+
+		if w.name == "" {
+			return nil, errors.New("process name must be set")
+		}
+
+		var matchers int
+		for _, m := range []string{cfg.PIDFile, cfg.ProcessName, cfg.Cmdline} {
+			if m != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return nil, fmt.Errorf("process %q: exactly one of pid_file, process_name or cmdline must be set", cfg.Name)
+		}
+
+		if cfg.Cmdline != "" {
+			re, err := regexp.Compile(cfg.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("process %q: bad cmdline regexp: %w", cfg.Name, err)
+			}
+			w.cmdline = re
+		}
+
+		c.watches = append(c.watches, w)
+	}
+
+	return c, nil
+}
+
+// Name implements Collector.
+func (c *processCollector) Name() string {
+	return "process"
+}
+
+// Collect implements Collector.
+func (c *processCollector) Collect(ctx context.Context) (Metrics, error) {
+	m := Metrics{
+		Gauges: make(map[string]model.Gauge),
+	}
+
+	// all processes are listed only once and only when needed
+	var all []*process.Process
+	listed := false
+
+	seen := make(map[int32]struct{})
+	now := time.Now()
+
+	for _, w := range c.watches {
+		var pids []int32
+
+		if w.pidFile != "" {
+			if pid, ok := readPIDFile(w.pidFile); ok {
+				pids = append(pids, pid)
+			}
+		} else {
+			if !listed {
+				var err error
+				if all, err = process.ProcessesWithContext(ctx); err != nil {
+					return Metrics{}, err
+				}
+				listed = true
+			}
+			pids = c.match(ctx, w, all)
+		}
+
+		var stat processStat
+		for _, pid := range pids {
+			p, err := c.process(ctx, pid)
+			if err != nil {
+				continue // process has gone
+			}
+			seen[pid] = struct{}{}
+
+			stat.add(ctx, p, now)
+		}
+
+		stat.report(m, w.name)
+	}
+
+	// forget processes which aren't watched anymore
+	for pid := range c.procs {
+		if _, ok := seen[pid]; !ok {
+			delete(c.procs, pid)
+		}
+	}
+
+	return m, nil
+}
+
+// match returns PIDs of processes matching w by name or by cmdline.
+func (c *processCollector) match(ctx context.Context, w processWatch, all []*process.Process) []int32 {
+	var pids []int32
+
+	for _, p := range all {
+		var matched bool
+
+		if w.processName != "" {
+			name, err := p.NameWithContext(ctx)
+			matched = err == nil && name == w.processName
+		} else {
+			cmdline, err := p.CmdlineWithContext(ctx)
+			matched = err == nil && w.cmdline.MatchString(cmdline)
+		}
+
+		if matched {
+			pids = append(pids, p.Pid)
+		}
+	}
+
+	return pids
+}
+
+// process returns cached process with pid. Cached process is replaced when
+// pid has been reused by another process.
+func (c *processCollector) process(ctx context.Context, pid int32) (*process.Process, error) {
+	if p, ok := c.procs[pid]; ok {
+		if running, err := p.IsRunningWithContext(ctx); err == nil && running {
+			return p, nil
+		}
+		delete(c.procs, pid)
+	}
+
+	p, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	c.procs[pid] = p
+
+	return p, nil
+}
+
+// processStat holds values summed over matched processes. Values which
+// couldn't be read for any process are not reported.
+type processStat struct {
+	count   int
+	rss     *float64
+	cpu     *float64
+	threads *float64
+	fds     *float64
+	uptime  *float64 // minimal one
+}
+
+func (s *processStat) add(ctx context.Context, p *process.Process, now time.Time) {
+	s.count++
+
+	if mem, err := p.MemoryInfoWithContext(ctx); err == nil {
+		s.rss = addValue(s.rss, float64(mem.RSS))
+	}
+
+	if cpu, err := p.PercentWithContext(ctx, 0); err == nil {
+		s.cpu = addValue(s.cpu, cpu)
+	}
+
+	if threads, err := p.NumThreadsWithContext(ctx); err == nil {
+		s.threads = addValue(s.threads, float64(threads))
+	}
+
+	if fds, err := p.NumFDsWithContext(ctx); err == nil {
+		s.fds = addValue(s.fds, float64(fds))
+	}
+
+	if created, err := p.CreateTimeWithContext(ctx); err == nil {
+		uptime := now.Sub(time.UnixMilli(created)).Seconds()
+		if s.uptime == nil || uptime < *s.uptime {
+			s.uptime = &uptime
+		}
+	}
+}
+
+func (s *processStat) report(m Metrics, name string) {
+	m.Gauges[seriesKey("process_count", "process", name)] = model.Gauge(s.count)
+
+	values := []struct {
+		metric string
+		value  *float64
+	}{
+		{"process_rss_bytes", s.rss},
+		{"process_cpu_percent", s.cpu},
+		{"process_threads", s.threads},
+		{"process_open_fds", s.fds},
+		{"process_uptime_seconds", s.uptime},
+	}
+
+	for _, v := range values {
+		if v.value != nil {
+			m.Gauges[seriesKey(v.metric, "process", name)] = model.Gauge(*v.value)
+		}
+	}
+}
+
+func addValue(sum *float64, v float64) *float64 {
+	if sum == nil {
+		return &v
+	}
+
+	*sum += v
+	return sum
+}
+
+// readPIDFile reads PID from file. Missing or malformed file means the process
+// isn't running.
+func readPIDFile(path string) (int32, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+
+	return int32(pid), true
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessCollector(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "test.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644))
+
+	// the test binary itself is watched
+	c, err := NewProcessCollector([]config.ProcessConfig{
+		{Name: "by_pid", PIDFile: pidFile},
+		{Name: "by_cmdline", Cmdline: regexp.QuoteMeta(filepath.Base(os.Args[0]))},
+		{Name: "missing", PIDFile: filepath.Join(t.TempDir(), "missing.pid")},
+	})
+	require.NoError(t, err)
+
+	// CPU percent is measured between collections
+	_, err = c.Collect(context.Background())
+	require.NoError(t, err)
+
+	m, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	for _, name := range []string{"by_pid", "by_cmdline"} {
+		assert.GreaterOrEqual(t, m.Gauges[`process_count{process="`+name+`"}`], 1.0, name)
+		assert.Greater(t, m.Gauges[`process_rss_bytes{process="`+name+`"}`], 0.0, name)
+		assert.Greater(t, m.Gauges[`process_threads{process="`+name+`"}`], 0.0, name)
+		assert.Contains(t, m.Gauges, `process_cpu_percent{process="`+name+`"}`, name)
+		assert.Contains(t, m.Gauges, `process_uptime_seconds{process="`+name+`"}`, name)
+	}
+
+	assert.EqualValues(t, 0, m.Gauges[`process_count{process="missing"}`])
+	assert.NotContains(t, m.Gauges, `process_rss_bytes{process="missing"}`)
+}
+
+func TestNewProcessCollector_Errors(t *testing.T) {
+	tests := map[string]config.ProcessConfig{
+		"no name":     {PIDFile: "/run/a.pid"},
+		"no matcher":  {Name: "a"},
+		"two matches": {Name: "a", PIDFile: "/run/a.pid", ProcessName: "a"},
+		"bad regexp":  {Name: "a", Cmdline: "("},
+	}
+
+	for name, cfg := range tests {
+		_, err := NewProcessCollector([]config.ProcessConfig{cfg})
+		assert.Error(t, err, name)
+	}
+}
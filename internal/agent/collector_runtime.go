@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"strings"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// DefaultRuntimeQuantiles are quantiles runtime/metrics histograms are reduced
+// to by default.
+var DefaultRuntimeQuantiles = []float64{0.5, 0.9, 0.99}
+
+// runtimeMetricsCollector exports every sample supported by runtime/metrics
+// package, e.g. scheduler latencies, GC pauses, goroutines count or mutex wait
+// time. Sample names are mapped by RuntimeMetricName.
+//
+// Samples are exported as:
+//   - cumulative uint64 samples - counters;
+//   - other uint64 and float64 samples - gauges;
+//   - histogram samples - summaries with configured quantiles.
+//
+// MemStats based metrics (e.g. "HeapAlloc") are still reported by the
+// "runtime" collector, so both collectors can be used together.
+//
+// Collector isn't safe for concurrent use, registry runs every collector from
+// a single goroutine.
+type runtimeMetricsCollector struct {
+	quantiles []float64
+
+	samples []metrics.Sample
+	names   []string // exported names of samples, same order
+	descs   []metrics.Description
+}
+
+// NewRuntimeMetricsCollector creates runtime/metrics collector which reduces
+// histograms to quantiles. DefaultRuntimeQuantiles are used when quantiles
+// are empty.
+func NewRuntimeMetricsCollector(quantiles []float64) *runtimeMetricsCollector {
+	if len(quantiles) == 0 {
+		quantiles = DefaultRuntimeQuantiles
+	}
+
+	c := &runtimeMetricsCollector{
+		quantiles: quantiles,
+	}
+
+	for _, d := range metrics.All() {
+		if d.Kind == metrics.KindBad {
+			continue
+		}
+
+		c.samples = append(c.samples, metrics.Sample{Name: d.Name})
+		c.names = append(c.names, RuntimeMetricName(d.Name))
+		c.descs = append(c.descs, d)
+	}
+
+	return c
+}
+
+// Name implements Collector.
+func (c *runtimeMetricsCollector) Name() string {
+	return "runtime_metrics"
+}
+
+// Collect implements Collector.
+func (c *runtimeMetricsCollector) Collect(_ context.Context) (Metrics, error) {
+	metrics.Read(c.samples)
+
+	m := Metrics{
+		Gauges:    make(map[string]model.Gauge),
+		Counters:  make(map[string]model.Counter),
+		Summaries: make(map[string]model.Summary),
+	}
+
+	for i, s := range c.samples {
+		name := c.names[i]
+
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			v := s.Value.Uint64()
+			if c.descs[i].Cumulative && v <= math.MaxInt64 {
+				m.Counters[name] = model.Counter(v)
+			} else {
+				m.Gauges[name] = model.Gauge(v)
+			}
+		case metrics.KindFloat64:
+			v := s.Value.Float64()
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue // can't be sent
+			}
+			m.Gauges[name] = model.Gauge(v)
+		case metrics.KindFloat64Histogram:
+			m.Summaries[name] = histogramSummary(s.Value.Float64Histogram(), c.quantiles)
+		}
+	}
+
+	return m, nil
+}
+
+// RuntimeMetricName maps runtime/metrics sample name to the exported metric
+// name: "go_" prefix followed by sample path and unit, all characters not
+// matching [a-zA-Z0-9_] are replaced with underscores. E.g.
+// "/sched/latencies:seconds" is mapped to "go_sched_latencies_seconds".
+// Unit "*" (unitless) is omitted.
+//
+// Mapping must stay stable: it's a part of the exported metrics names.
+func RuntimeMetricName(sample string) string {
+	path, unit, _ := strings.Cut(sample, ":")
+
+	name := "go_" + strings.TrimPrefix(path, "/")
+	if unit != "" && unit != "*" {
+		name += "_" + unit
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// histogramSummary reduces runtime/metrics histogram to summary. Quantiles
+// are interpolated linearly within buckets, sum is approximated by bucket
+// midpoints (bucket with infinite bound contributes its finite bound).
+func histogramSummary(h *metrics.Float64Histogram, quantiles []float64) model.Summary {
+	var s model.Summary
+
+	for i, count := range h.Counts {
+		if count == 0 {
+			continue
+		}
+
+		s.Count += count
+		s.Sum += float64(count) * bucketMidpoint(h.Buckets[i], h.Buckets[i+1])
+	}
+
+	s.Quantiles = make([]model.Quantile, 0, len(quantiles))
+	for _, q := range quantiles {
+		s.Quantiles = append(s.Quantiles, model.Quantile{
+			Quantile: q,
+			Value:    histogramQuantile(h, s.Count, q),
+		})
+	}
+
+	return s
+}
+
+// histogramQuantile returns q-quantile of histogram h with total number of
+// observations. Zero is returned for empty histogram.
+func histogramQuantile(h *metrics.Float64Histogram, total uint64, q float64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+
+	var cum float64
+	for i, count := range h.Counts {
+		if count == 0 {
+			continue
+		}
+
+		if cum+float64(count) < rank {
+			cum += float64(count)
+			continue
+		}
+
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		switch {
+		case math.IsInf(lo, -1):
+			return hi
+		case math.IsInf(hi, 1):
+			return lo
+		}
+
+		return lo + (hi-lo)*(rank-cum)/float64(count)
+	}
+
+	return 0 // unreachable, rank never exceeds total
+}
+
+func bucketMidpoint(lo, hi float64) float64 {
+	if math.IsInf(lo, 0) || math.IsInf(hi, 0) {
+		return finiteBound(lo, hi)
+	}
+
+	return (lo + hi) / 2
+}
+
+// finiteBound returns finite one of bucket bounds, upper bound is preferred.
+func finiteBound(lo, hi float64) float64 {
+	if !math.IsInf(hi, 0) {
+		return hi
+	}
+
+	if !math.IsInf(lo, 0) {
+		return lo
+	}
+
+	return 0
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeMetricName(t *testing.T) {
+	tests := map[string]string{
+		"/sched/latencies:seconds":                "go_sched_latencies_seconds",
+		"/sched/goroutines:goroutines":            "go_sched_goroutines_goroutines",
+		"/cpu/classes/gc/mark/assist:cpu-seconds": "go_cpu_classes_gc_mark_assist_cpu_seconds",
+		"/sync/mutex/wait/total:seconds":          "go_sync_mutex_wait_total_seconds",
+		"/godebug/non-default-behavior/x:events":  "go_godebug_non_default_behavior_x_events",
+		"/some/unitless:*":                        "go_some_unitless",
+	}
+
+	for sample, want := range tests {
+		assert.Equal(t, want, RuntimeMetricName(sample), sample)
+	}
+}
+
+func TestHistogramSummary(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 2, 6, 2},
+		Buckets: []float64{math.Inf(-1), 0, 1, 2, math.Inf(1)},
+	}
+
+	s := histogramSummary(h, []float64{0, 0.5, 0.9, 1})
+
+	assert.EqualValues(t, 10, s.Count)
+	assert.InDelta(t, 2*0.5+6*1.5+2*2, s.Sum, 1e-9)
+
+	require.Len(t, s.Quantiles, 4)
+	assert.InDelta(t, 0, s.Quantiles[0].Value, 1e-9)
+	assert.InDelta(t, 1.5, s.Quantiles[1].Value, 1e-9)
+	assert.InDelta(t, 2, s.Quantiles[2].Value, 1e-9, "+Inf bucket reports its lower bound")
+	assert.InDelta(t, 2, s.Quantiles[3].Value, 1e-9)
+
+	empty := histogramSummary(&metrics.Float64Histogram{
+		Counts:  []uint64{0},
+		Buckets: []float64{0, 1},
+	}, []float64{0.5})
+	assert.Equal(t, 0.0, empty.Quantiles[0].Value)
+}
+
+func TestRuntimeMetricsCollector_Collect(t *testing.T) {
+	c := NewRuntimeMetricsCollector(nil)
+
+	m, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Greater(t, m.Gauges["go_sched_goroutines_goroutines"], 0.0)
+	assert.Contains(t, m.Counters, "go_gc_heap_allocs_bytes")
+
+	pauses, ok := m.Summaries["go_gc_pauses_seconds"]
+	require.True(t, ok, "histogram must be reported as summary")
+	require.Len(t, pauses.Quantiles, len(DefaultRuntimeQuantiles))
+	assert.NoError(t, pauses.Validate())
+}
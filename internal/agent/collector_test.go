@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticCollector reports the same metrics every time.
+type staticCollector struct {
+	name    string
+	metrics Metrics
+	err     error
+}
+
+func (c *staticCollector) Name() string {
+	return c.name
+}
+
+func (c *staticCollector) Collect(_ context.Context) (Metrics, error) {
+	return c.metrics, c.err
+}
+
+func TestRegistry_Start(t *testing.T) {
+	p := NewPoller()
+
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(p, 10*time.Millisecond))
+
+	registry.Start(context.Background())
+	defer registry.Stop()
+
+	// `go test -race ./...` detects race here when poller data is accessed
+	// without mutex or other syncronizations
+	assert.Eventually(t, func() bool {
+		return p.PollCount() >= 2
+	}, time.Second, 5*time.Millisecond, "collector wasn't run on its interval")
+
+	assert.Eventually(t, func() bool {
+		return registry.Snapshot().Counters["PollCount"] >= 2
+	}, time.Second, 5*time.Millisecond, "snapshot wasn't updated")
+}
+
+func TestRegistry_Register(t *testing.T) {
+	registry := NewRegistry()
+
+	require.NoError(t, registry.Register(&staticCollector{name: "a"}, time.Second))
+	assert.Error(t, registry.Register(&staticCollector{name: "a"}, time.Second), "duplicate name")
+	assert.Error(t, registry.Register(&staticCollector{name: "b"}, 0), "zero interval")
+}
+
+func TestRegistry_Snapshot(t *testing.T) {
+	registry := NewRegistry()
+
+	first := &staticCollector{name: "first", metrics: Metrics{
+		Gauges:   map[string]model.Gauge{"Shared": 1, "First": 1},
+		Counters: map[string]model.Counter{"Count": 1},
+	}}
+	second := &staticCollector{name: "second", metrics: Metrics{
+		Gauges: map[string]model.Gauge{"Shared": 2},
+	}}
+	failing := &staticCollector{name: "failing", err: errors.New("failed")}
+
+	for _, c := range []Collector{first, second, failing} {
+		require.NoError(t, registry.Register(c, time.Hour))
+	}
+
+	registry.Start(context.Background())
+	registry.Stop()
+
+	m := registry.Snapshot()
+	assert.Equal(t, map[string]model.Gauge{"Shared": 2, "First": 1}, m.Gauges)
+	assert.Equal(t, map[string]model.Counter{"Count": 1}, m.Counters)
+}
+
+func TestRegistry_Collect(t *testing.T) {
+	registry := NewRegistry()
+
+	c := &staticCollector{name: "static"}
+	require.NoError(t, registry.Register(c, time.Hour))
+
+	registry.Start(context.Background())
+	registry.Stop()
+
+	c.metrics = Metrics{Gauges: map[string]model.Gauge{"Fresh": 1}}
+	require.NoError(t, registry.Collect(context.Background(), "static"))
+	require.Error(t, registry.Collect(context.Background(), "unknown"))
+
+	m := registry.Snapshot()
+	assert.Equal(t, map[string]model.Gauge{"Fresh": 1}, m.Gauges)
+}
+
+func TestNewRegistry(t *testing.T) {
+	cfg := config.New()
+	cfg.DisableCollectors("disabled")
+
+	registry, err := newRegistry(cfg, []Collector{
+		&staticCollector{name: "enabled"},
+		&staticCollector{name: "disabled"},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, registry.entries, 1)
+	assert.Equal(t, "enabled", registry.entries[0].collector.Name())
+	assert.Equal(t, 2*time.Second, registry.entries[0].interval)
+}
+
+func TestDeviceFilter(t *testing.T) {
+	filter, err := newDeviceFilter([]string{"sd*", "nvme*"}, []string{"sdb"})
+	require.NoError(t, err)
+
+	assert.True(t, filter.match("sda"))
+	assert.True(t, filter.match("nvme0n1"))
+	assert.False(t, filter.match("sdb"), "exclude wins")
+	assert.False(t, filter.match("loop0"), "not included")
+
+	filter, err = newDeviceFilter(nil, []string{"lo"})
+	require.NoError(t, err)
+	assert.True(t, filter.match("eth0"), "everything is included when include is empty")
+	assert.False(t, filter.match("lo"))
+
+	_, err = newDeviceFilter([]string{"[a-"}, nil)
+	assert.Error(t, err)
+}
+
+func TestDefaultCollectors(t *testing.T) {
+	names := func(collectors []Collector) []string {
+		var res []string
+		for _, c := range collectors {
+			res = append(res, c.Name())
+		}
+		return res
+	}
+
+	cfg := config.New()
+	collectors, err := defaultCollectors(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, names(collectors), "runtime_metrics", "opt-in collector")
+
+	cfg.EnableCollectors("runtime_metrics")
+	collectors, err = defaultCollectors(cfg)
+	require.NoError(t, err)
+	assert.Contains(t, names(collectors), "runtime_metrics")
+}
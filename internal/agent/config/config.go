@@ -5,7 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
 )
 
 // Config holds agent service setup parameters.
@@ -19,6 +23,25 @@ type Config struct {
 	// GRPCServerURL shows if grpc client must be used.
 	GRPCServerURL string `json:"grpc"`
 
+	// GRPCStream makes batches to be sent to gRPC targets with UpdateStream
+	// method (metric by metric in a single stream) instead of UpdateBatch.
+	// Flag: -grpc-stream, env: GRPC_STREAM.
+	GRPCStream bool `json:"grpc_stream"`
+
+	// Targets are sinks batches are sent to: HTTP API URLs (e.g.
+	// "http://primary:8080"), gRPC API addresses prefixed with "grpc://"
+	// (e.g. "grpc://primary:3200"), "stdout://" to print batches as NDJSON
+	// or NDJSON file paths prefixed with "file://" (e.g.
+	// "file:///var/lib/gometrics/metrics.ndjson"). When empty, ServerURL (or
+	// GRPCServerURL, when set) is the only target. Flag: -targets, env:
+	// TARGETS, both in "url,url2" form.
+	Targets []string `json:"targets"`
+
+	// TargetPolicy is how batches are sent to several Targets:
+	// TargetPolicyFailover (default) or TargetPolicyFanout. Flag:
+	// -target-policy, env: TARGET_POLICY.
+	TargetPolicy string `json:"target_policy"`
+
 	// Interval in seconds
 	PollInterval int `json:"poll_interval"`
 
@@ -44,8 +67,128 @@ type Config struct {
 
 	// HostIP is an IP of current host.
 	HostIP string `json:"host_ip"`
+
+	// Labels are attached to every metric sent by the agent (e.g. host,
+	// service, env). Flag: -labels, env: LABELS, both in
+	// "name:value,name2:value2" form.
+	Labels map[string]string `json:"labels"`
+
+	// AgentID identifies the agent in idempotency keys of sent batches.
+	// Random ID is generated on start when empty. Flag: -agent-id, env:
+	// AGENT_ID.
+	AgentID string `json:"agent_id"`
+
+	// Collectors configures collectors by name, collectors missing here are
+	// enabled (except opt-in ones) and use PollInterval. Flags:
+	// -disable-collectors and -enable-collectors, envs: DISABLE_COLLECTORS and
+	// ENABLE_COLLECTORS, all in "name,name2" form.
+	Collectors map[string]CollectorConfig `json:"collectors"`
+
+	// RuntimeQuantiles are quantiles runtime/metrics histograms are reduced
+	// to. Flag: -runtime-quantiles, env: RUNTIME_QUANTILES, both in
+	// "0.5,0.9,0.99" form.
+	RuntimeQuantiles []float64 `json:"runtime_quantiles"`
+
+	// Processes are watched by the "process" collector, it's enabled when
+	// at least one process is configured. Can be set in config file only.
+	Processes []ProcessConfig `json:"processes"`
+
+	// PushAddress is an address push receiver listens on for metrics pushed
+	// by applications running on the same host: TCP address (e.g.
+	// "localhost:8081") or Unix socket path prefixed with "unix:" (e.g.
+	// "unix:/run/gometrics-agent.sock"). Receiver is disabled when empty.
+	// Series not pushed for 10 report intervals are expired.
+	// Flag: -push-address, env: PUSH_ADDRESS.
+	PushAddress string `json:"push_address"`
+
+	// StatsdAddress is a UDP address StatsD listener listens on, e.g.
+	// ":8125". Listener is disabled when empty. Flag: -statsd-address, env:
+	// STATSD_ADDRESS.
+	StatsdAddress string `json:"statsd_address"`
+
+	// StatsdPercentiles are percentiles StatsD timers are reduced to. Flag:
+	// -statsd-percentiles, env: STATSD_PERCENTILES, both in "0.5,0.9,0.99"
+	// form.
+	StatsdPercentiles []float64 `json:"statsd_percentiles"`
+
+	// SpoolDir is a directory batches failed to be sent are kept in until
+	// the server is reachable again. Spool is disabled when empty. Flag:
+	// -spool-dir, env: SPOOL_DIR.
+	SpoolDir string `json:"spool_dir"`
+
+	// SpoolMaxSize limits total size of spooled batches in bytes, the oldest
+	// batches are dropped when exceeded. Default is used when zero. Flag:
+	// -spool-max-size, env: SPOOL_MAX_SIZE.
+	SpoolMaxSize int64 `json:"spool_max_size"`
+
+	// SpoolMaxAge in seconds, older spooled batches are dropped. Default is
+	// used when zero. Flag: -spool-max-age, env: SPOOL_MAX_AGE.
+	SpoolMaxAge int `json:"spool_max_age"`
+
+	// FileSinkMaxSize is a size in bytes file sink is rotated at. Default is
+	// used when zero. Flag: -file-sink-max-size, env: FILE_SINK_MAX_SIZE.
+	FileSinkMaxSize int64 `json:"file_sink_max_size"`
+
+	// FileSinkMaxFiles is a number of rotated files kept by file sink.
+	// Default is used when zero. Flag: -file-sink-max-files, env:
+	// FILE_SINK_MAX_FILES.
+	FileSinkMaxFiles int `json:"file_sink_max_files"`
 }
 
+// ProcessConfig describes processes to be watched. Processes are matched by
+// PIDFile, by ProcessName or by Cmdline regexp, exactly one of them must be
+// set.
+type ProcessConfig struct {
+	// Name identifies watched processes in metrics ("process" label)
+	Name string `json:"name"`
+
+	// PIDFile is a path to file containing PID of the process
+	PIDFile string `json:"pid_file"`
+
+	// ProcessName is an executable name of the process, e.g. "nginx"
+	ProcessName string `json:"process_name"`
+
+	// Cmdline is a regexp matched against process command line
+	Cmdline string `json:"cmdline"`
+}
+
+// CollectorConfig holds setup parameters of a single collector.
+type CollectorConfig struct {
+	Disabled bool `json:"disabled"`
+
+	// Enabled enables opt-in collector (e.g. runtime_metrics or host
+	// collectors), they're disabled by default. Disabled wins over Enabled.
+	Enabled bool `json:"enabled"`
+
+	// Interval in seconds, Config.PollInterval is used when zero
+	PollInterval int `json:"poll_interval"`
+
+	// Include and Exclude filter devices (e.g. disks or network interfaces)
+	// by glob patterns (see path.Match). When Include is set, only matching
+	// devices are collected. Exclude wins over Include.
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// Target policies.
+const (
+	// TargetPolicyFailover sends every batch to the first healthy target,
+	// targets are tried in order.
+	TargetPolicyFailover = "failover"
+	// TargetPolicyFanout sends every batch to all targets independently.
+	TargetPolicyFanout = "fanout"
+)
+
+// Target schemes other than http:// and https://.
+const (
+	// GRPCTargetPrefix marks target as gRPC API address.
+	GRPCTargetPrefix = "grpc://"
+	// StdoutTarget is a target printing batches to stdout.
+	StdoutTarget = "stdout://"
+	// FileTargetPrefix marks target as file path.
+	FileTargetPrefix = "file://"
+)
+
 // New creates config with default values set.
 func New() *Config {
 	return &Config{
@@ -57,6 +200,74 @@ func New() *Config {
 	}
 }
 
+// TargetAddresses returns addresses of servers batches are sent to, see
+// Targets.
+func (c *Config) TargetAddresses() []string {
+	switch {
+	case len(c.Targets) > 0:
+		return c.Targets
+	case c.GRPCServerURL != "":
+		return []string{GRPCTargetPrefix + c.GRPCServerURL}
+	default:
+		return []string{c.ServerURL}
+	}
+}
+
+// CollectorEnabled reports whether collector with name is enabled.
+func (c *Config) CollectorEnabled(name string) bool {
+	return !c.Collectors[name].Disabled
+}
+
+// CollectorOptedIn reports whether opt-in collector with name is enabled.
+func (c *Config) CollectorOptedIn(name string) bool {
+	cc := c.Collectors[name]
+	return cc.Enabled && !cc.Disabled
+}
+
+// CollectorInterval returns poll interval of collector with name.
+func (c *Config) CollectorInterval(name string) time.Duration {
+	interval := c.PollInterval
+	if cc := c.Collectors[name]; cc.PollInterval > 0 {
+		interval = cc.PollInterval
+	}
+
+	return time.Second * time.Duration(interval)
+}
+
+// DisableCollectors disables collectors listed in "name,name2" string.
+func (c *Config) DisableCollectors(names string) {
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		if c.Collectors == nil {
+			c.Collectors = make(map[string]CollectorConfig)
+		}
+
+		cc := c.Collectors[name]
+		cc.Disabled = true
+		c.Collectors[name] = cc
+	}
+}
+
+// EnableCollectors enables opt-in collectors listed in "name,name2" string.
+func (c *Config) EnableCollectors(names string) {
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		if c.Collectors == nil {
+			c.Collectors = make(map[string]CollectorConfig)
+		}
+
+		cc := c.Collectors[name]
+		cc.Enabled = true
+		c.Collectors[name] = cc
+	}
+}
+
 // ParseFromFile parses config from file.
 func ParseFromFile(cfg *Config, filepath string) error {
 	if filepath = strings.TrimSpace(filepath); filepath == "" {
@@ -74,3 +285,67 @@ func ParseFromFile(cfg *Config, filepath string) error {
 
 	return nil
 }
+
+// ParseQuantiles parses quantiles from "0.5,0.9,0.99" string. Every quantile
+// must be within [0, 1] range.
+func ParseQuantiles(s string) ([]float64, error) {
+	var quantiles []float64
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if !(q >= 0 && q <= 1) {
+			return nil, errors.New("quantile must be within [0, 1] range: " + v)
+		}
+
+		quantiles = append(quantiles, q)
+	}
+
+	return quantiles, nil
+}
+
+// ParseTargets parses targets from "url,url2" string.
+func ParseTargets(s string) []string {
+	var targets []string
+
+	for _, target := range strings.Split(s, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets
+}
+
+// ParseLabels parses labels from "name:value,name2:value2" string, the same
+// "name:value" form is used by the server label query parameter. Label names
+// are validated (see model.Labels.Validate), since the server rejects every
+// metric with invalid labels.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(model.Labels)
+
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, errors.New("label must be provided in name:value form: " + pair)
+		}
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	if err := labels.Validate(); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -88,3 +89,49 @@ func prepareTestConfigFile(t *testing.T, cfg *Config) (configPath string) {
 
 	return file.Name()
 }
+
+func TestConfig_Collectors(t *testing.T) {
+	cfg := New()
+	cfg.PollInterval = 2
+	cfg.Collectors = map[string]CollectorConfig{
+		"slow": {PollInterval: 30},
+	}
+
+	cfg.DisableCollectors("gopsutil, slow,")
+
+	assert.True(t, cfg.CollectorEnabled("runtime"))
+	assert.False(t, cfg.CollectorEnabled("gopsutil"))
+	assert.False(t, cfg.CollectorEnabled("slow"))
+
+	cfg.EnableCollectors("runtime_metrics, slow")
+	assert.True(t, cfg.CollectorOptedIn("runtime_metrics"))
+	assert.False(t, cfg.CollectorOptedIn("disk"))
+	assert.False(t, cfg.CollectorOptedIn("slow"), "disabled wins")
+
+	assert.Equal(t, 2*time.Second, cfg.CollectorInterval("runtime"))
+	assert.Equal(t, 30*time.Second, cfg.CollectorInterval("slow"))
+}
+
+func TestParseQuantiles(t *testing.T) {
+	quantiles, err := ParseQuantiles("0.5, 0.9,0.99,")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.5, 0.9, 0.99}, quantiles)
+
+	_, err = ParseQuantiles("1.5")
+	assert.Error(t, err)
+
+	_, err = ParseQuantiles("p99")
+	assert.Error(t, err)
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("host:web1, env: prod,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "web1", "env": "prod"}, labels)
+
+	_, err = ParseLabels("host=web1")
+	assert.Error(t, err)
+
+	_, err = ParseLabels("my-label:x")
+	assert.Error(t, err, "invalid label name")
+}
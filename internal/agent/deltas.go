@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// deltaTracker converts cumulative counters and histograms into deltas to be
+// sent.
+//
+// Server adds received counter value (or histogram observations) to the
+// stored one, so sending cumulative totals would count the same increments
+// again on every report. Tracker remembers how much of every counter and
+// histogram has been acknowledged by the server and only the rest is sent.
+// When sending fails, nothing is acknowledged, so unsent increments are
+// rolled forward into the next report.
+type deltaTracker struct {
+	mu         sync.Mutex
+	acked      map[string]model.Counter
+	epochs     map[string]uint64          // epochs of acknowledged counters
+	histograms map[string]model.Histogram // acknowledged histograms
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		acked:      make(map[string]model.Counter),
+		epochs:     make(map[string]uint64),
+		histograms: make(map[string]model.Histogram),
+	}
+}
+
+// Deltas returns increments of cumulative counters since the last
+// acknowledged values. Counters without increments are omitted. Counter that
+// went below its acknowledged value or changed its epoch (see
+// Metrics.CounterEpochs) is considered reset (e.g. it's a counter of a
+// restarted process), so its whole value is sent. Counters missing from
+// counters are forgotten, so the same counter showing up later is sent as a
+// new one.
+func (t *deltaTracker) Deltas(counters map[string]model.Counter, epochs map[string]uint64) map[string]model.Counter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name := range t.acked {
+		if _, ok := counters[name]; !ok {
+			delete(t.acked, name)
+			delete(t.epochs, name)
+		}
+	}
+
+	deltas := make(map[string]model.Counter, len(counters))
+	for name, value := range counters {
+		acked := t.acked[name]
+		if value < acked || epochs[name] != t.epochs[name] {
+			acked = 0
+			t.acked[name] = 0
+			t.epochs[name] = epochs[name]
+		}
+
+		if d := value - acked; d != 0 {
+			deltas[name] = d
+		}
+	}
+
+	return deltas
+}
+
+// Ack marks deltas (returned by Deltas) as received by the server.
+func (t *deltaTracker) Ack(deltas map[string]model.Counter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, d := range deltas {
+		t.acked[name] += d
+	}
+}
+
+// HistogramDeltas is the same as Deltas, but for cumulative histograms:
+// observations made since the last acknowledged histogram are returned.
+// Histogram with reconfigured buckets or with counts went down is considered
+// reset, so it's sent as a whole.
+func (t *deltaTracker) HistogramDeltas(histograms map[string]model.Histogram) map[string]model.Histogram {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name := range t.histograms {
+		if _, ok := histograms[name]; !ok {
+			delete(t.histograms, name)
+		}
+	}
+
+	deltas := make(map[string]model.Histogram, len(histograms))
+	for name, h := range histograms {
+		d, ok := h.Sub(t.histograms[name])
+		if !ok {
+			d = h.Copy()
+			delete(t.histograms, name)
+		}
+
+		if d.Count != 0 {
+			deltas[name] = d
+		}
+	}
+
+	return deltas
+}
+
+// AckHistograms marks deltas (returned by HistogramDeltas) as received by the
+// server.
+func (t *deltaTracker) AckHistograms(deltas map[string]model.Histogram) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, d := range deltas {
+		t.histograms[name] = t.histograms[name].Merge(d)
+	}
+}
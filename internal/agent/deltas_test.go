@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltaTracker(t *testing.T) {
+	tracker := newDeltaTracker()
+
+	deltas := tracker.Deltas(map[string]model.Counter{"PollCount": 3, "Zero": 0}, nil)
+	assert.Equal(t, map[string]model.Counter{"PollCount": 3}, deltas)
+	tracker.Ack(deltas)
+
+	// send failed, increments are rolled forward
+	deltas = tracker.Deltas(map[string]model.Counter{"PollCount": 5}, nil)
+	assert.Equal(t, map[string]model.Counter{"PollCount": 2}, deltas)
+
+	deltas = tracker.Deltas(map[string]model.Counter{"PollCount": 8}, nil)
+	assert.Equal(t, map[string]model.Counter{"PollCount": 5}, deltas)
+	tracker.Ack(deltas)
+
+	deltas = tracker.Deltas(map[string]model.Counter{"PollCount": 8}, nil)
+	assert.Empty(t, deltas)
+
+	// counter reset
+	deltas = tracker.Deltas(map[string]model.Counter{"PollCount": 2}, nil)
+	assert.Equal(t, map[string]model.Counter{"PollCount": 2}, deltas)
+	tracker.Ack(deltas)
+
+	deltas = tracker.Deltas(map[string]model.Counter{"PollCount": 3}, nil)
+	assert.Equal(t, map[string]model.Counter{"PollCount": 1}, deltas)
+	tracker.Ack(deltas)
+
+	// counter is gone, it's sent as a new one when it shows up again
+	assert.Empty(t, tracker.Deltas(map[string]model.Counter{}, nil))
+	assert.Empty(t, tracker.acked)
+
+	deltas = tracker.Deltas(map[string]model.Counter{"PollCount": 5}, nil)
+	assert.Equal(t, map[string]model.Counter{"PollCount": 5}, deltas)
+	tracker.Ack(deltas)
+
+	// counter started over with a new epoch
+	deltas = tracker.Deltas(map[string]model.Counter{"PollCount": 7}, map[string]uint64{"PollCount": 1})
+	assert.Equal(t, map[string]model.Counter{"PollCount": 7}, deltas)
+	tracker.Ack(deltas)
+
+	deltas = tracker.Deltas(map[string]model.Counter{"PollCount": 8}, map[string]uint64{"PollCount": 1})
+	assert.Equal(t, map[string]model.Counter{"PollCount": 1}, deltas)
+}
+
+func TestDeltaTracker_histograms(t *testing.T) {
+	tracker := newDeltaTracker()
+
+	h := model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 1}}, Sum: 2, Count: 2}
+
+	deltas := tracker.HistogramDeltas(map[string]model.Histogram{"Latency": h})
+	assert.Equal(t, map[string]model.Histogram{"Latency": h}, deltas)
+	tracker.AckHistograms(deltas)
+
+	// nothing observed since the last report
+	assert.Empty(t, tracker.HistogramDeltas(map[string]model.Histogram{"Latency": h}))
+
+	later := model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 2}}, Sum: 5, Count: 3}
+	deltas = tracker.HistogramDeltas(map[string]model.Histogram{"Latency": later})
+	assert.Equal(t, map[string]model.Histogram{
+		"Latency": {Buckets: []model.Bucket{{UpperBound: 1, Count: 1}}, Sum: 3, Count: 1},
+	}, deltas)
+	tracker.AckHistograms(deltas)
+
+	// histogram reset
+	deltas = tracker.HistogramDeltas(map[string]model.Histogram{"Latency": h})
+	assert.Equal(t, map[string]model.Histogram{"Latency": h}, deltas)
+	tracker.AckHistograms(deltas)
+
+	// reconfigured buckets
+	reconfigured := model.Histogram{Buckets: []model.Bucket{{UpperBound: 5, Count: 3}}, Sum: 6, Count: 3}
+	deltas = tracker.HistogramDeltas(map[string]model.Histogram{"Latency": reconfigured})
+	assert.Equal(t, map[string]model.Histogram{"Latency": reconfigured}, deltas)
+}
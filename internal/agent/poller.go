@@ -1,8 +1,7 @@
 package agent
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"math/rand"
 	"runtime"
 	"sync"
@@ -11,72 +10,32 @@ import (
 	"github.com/Dmitrevicz/gometrics/internal/model"
 )
 
-// poller updates metrics data every pollInterval seconds.
+// poller gathers runtime metrics (see runtime.MemStats).
 type poller struct {
-	pollInterval int
-
 	stat runtime.MemStats // gauges will be polling here
 
 	pollCount   model.Counter // additional custom counter value
 	RandomValue model.Gauge   // additional custom gauge value
 	LastPoll    time.Time
 
-	quit  chan struct{}
-	timer *time.Timer
-
 	// polled data must be protected because accessed from separate goroutines
 	mu sync.RWMutex
 }
 
-// NewPoller returns a poller that should be used to gather metrics data every
-// pollInterval seconds.
-func NewPoller(pollInterval int) *poller {
-	return &poller{
-		pollInterval: pollInterval,
-		quit:         make(chan struct{}),
-	}
+// NewPoller returns a poller that gathers runtime metrics data.
+func NewPoller() *poller {
+	return &poller{}
 }
 
-// Start starts updating metrics data every pollInterval seconds.
-func (p *poller) Start() {
-	log.Println("Poller started")
-
-	ts := time.Now()
-
-	// poll at the very start and then repeat after every sleepDuration
-	p.Poll()
-	fmt.Println("poll fired:", p.LastPoll, time.Since(ts))
-
-	sleepDuration := time.Second * time.Duration(p.pollInterval)
-	p.timer = time.NewTimer(sleepDuration)
-
-	for {
-		select {
-		case ts = <-p.timer.C:
-			p.Poll()
-			fmt.Println("poll fired:", p.LastPoll, time.Since(ts))
-
-			// I don't calculate delta-time, because current behaviour
-			// is good enough right now.
-			p.timer.Reset(sleepDuration)
-		case <-p.quit:
-			// stop the timer
-			if !p.timer.Stop() {
-				// drain the chanel (might not be needed here, but leave it be
-				// as a kind of exercise)
-				<-p.timer.C
-			}
-
-			log.Println("Poller timer stopped")
-			return
-		}
-	}
+// Name implements Collector.
+func (p *poller) Name() string {
+	return "runtime"
 }
 
-// Stop stops poller's timer.
-func (p *poller) Stop() {
-	close(p.quit)
-	log.Println("Poller stopped")
+// Collect implements Collector.
+func (p *poller) Collect(_ context.Context) (Metrics, error) {
+	p.Poll()
+	return p.AcquireMetrics(), nil
 }
 
 // Poll retrieves metrics data from runtime.
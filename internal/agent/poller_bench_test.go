@@ -10,7 +10,7 @@ func BenchmarkPoller(b *testing.B) {
 }
 
 func benchPollerPoll(b *testing.B) {
-	p := NewPoller(0)
+	p := NewPoller()
 
 	b.ReportAllocs()
 	b.ResetTimer()
@@ -21,7 +21,7 @@ func benchPollerPoll(b *testing.B) {
 }
 
 func benchPollerAcquire(b *testing.B) {
-	p := NewPoller(0)
+	p := NewPoller()
 	p.Poll()
 
 	b.ReportAllocs()
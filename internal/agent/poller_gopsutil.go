@@ -1,8 +1,7 @@
 package agent
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"strconv"
 	"sync"
 	"time"
@@ -27,59 +26,33 @@ type gopsutilStat struct {
 // - FreeMemory,
 // - CPUutilization1 (точное количество — по числу CPU, определяемому во время исполнения).
 type gopsutilPoller struct {
-	pollInterval int
-
 	stat gopsutilStat // gauges will be polling here
 
 	lastPoll time.Time // for debugging
 
-	quit chan struct{}
-
 	// polled data must be protected because accessed from separate goroutines
 	mu sync.RWMutex
 }
 
-func NewGopsutilPoller(pollInterval int) *gopsutilPoller {
-	return &gopsutilPoller{
-		pollInterval: pollInterval,
-		quit:         make(chan struct{}),
-	}
+func NewGopsutilPoller() *gopsutilPoller {
+	return &gopsutilPoller{}
 }
 
-func (p *gopsutilPoller) Start() {
-	log.Println("Poller started (gopsutil)")
-
-	sleepDuration := time.Second * time.Duration(p.pollInterval)
-	var (
-		ts  time.Time
-		err error
-	)
-
-	for {
-		select {
-		case <-p.quit:
-			log.Println("Poller timer stopped (gopsutil)")
-			return
-		default:
-		}
-
-		ts = time.Now()
-		if err = p.Poll(); err != nil {
-			log.Println("Poll failed for gopsutil, err:", err)
-		}
-		fmt.Println("poll fired (gopsutil):", p.lastPoll, time.Since(ts))
-
-		time.Sleep(sleepDuration)
-	}
+// Name implements Collector.
+func (p *gopsutilPoller) Name() string {
+	return "gopsutil"
 }
 
-// Stop stops poller's timer.
-func (p *gopsutilPoller) Stop() {
-	close(p.quit)
-	log.Println("Poller stopped (gopsutil)")
+// Collect implements Collector.
+func (p *gopsutilPoller) Collect(_ context.Context) (Metrics, error) {
+	if err := p.Poll(); err != nil {
+		return Metrics{}, err
+	}
+
+	return *p.AcquireMetrics(), nil
 }
 
-// Poll updates metrics data every pollInterval seconds
+// Poll updates metrics data
 func (p *gopsutilPoller) Poll() error {
 	stats, err := mem.VirtualMemory()
 	if err != nil {
@@ -16,7 +16,7 @@ func TestGopsutilPoller(t *testing.T) {
 }
 
 func (s *AgentGopsutilPollerSuit) TestPoll() {
-	p := NewGopsutilPoller(0)
+	p := NewGopsutilPoller()
 
 	prevPollTime := p.lastPoll
 
@@ -39,31 +39,8 @@ func (s *AgentGopsutilPollerSuit) TestPoll() {
 	s.Assert().WithinRange(p.lastPoll, prevPollTime, time.Now(), "wrong timestamp after Poll call")
 }
 
-// TestStart checks Polls being called within timer
-func (s *AgentGopsutilPollerSuit) TestStartTicker() {
-	const (
-		expectedGaugesCount = 3 // can be more than this
-		pollInterval        = 1 // poll interval in seconds
-		checkTickInterval   = (time.Second * pollInterval) / 2
-		durationTillTimeout = time.Second*pollInterval*3 + checkTickInterval
-	)
-
-	p := NewGopsutilPoller(pollInterval)
-	go p.Start()
-
-	s.Assert().Eventuallyf(
-		func() bool {
-			m := p.AcquireMetrics()
-			return len(m.Gauges) >= expectedGaugesCount
-		},
-		durationTillTimeout,
-		checkTickInterval,
-		"poller stats wasn't updated in expected period of time",
-	)
-}
-
 func (s *AgentGopsutilPollerSuit) TestAcquireMetrics() {
-	p := NewGopsutilPoller(0)
+	p := NewGopsutilPoller()
 
 	err := p.Poll()
 	s.Require().NoError(err)
@@ -17,7 +17,7 @@ func TestPoller(t *testing.T) {
 }
 
 func (s *AgentPollerSuit) TestPoll() {
-	p := NewPoller(0)
+	p := NewPoller()
 
 	prevPollTime := p.LastPoll
 
@@ -40,34 +40,8 @@ func (s *AgentPollerSuit) TestPoll() {
 	s.Assert().Equal(model.Counter(1), p.PollCount(), "wrong poller PollCount value after poll call")
 }
 
-// TestStart checks Polls being called within timer
-func (s *AgentPollerSuit) TestStartTicker() {
-	const (
-		expectedPollCount = 2
-		pollInterval      = 2
-		checkTickInterval = (time.Second * pollInterval) / 2
-		// 2*checkTickInterval is added to be sure that PollCount will 100% have enough time to reach expected value
-		durationTillTimout = time.Second*pollInterval*expectedPollCount + 2*checkTickInterval
-	)
-
-	p := NewPoller(pollInterval)
-	go p.Start()
-
-	s.Assert().Eventuallyf(
-		func() bool {
-			// `go test -race ./...` detects race and fails here
-			// when accessed poller data without mutex or other syncronizations
-			return p.PollCount() == expectedPollCount
-		},
-		durationTillTimout,
-		checkTickInterval,
-		"PollCount didn't reach %d in expected period of time",
-		expectedPollCount,
-	)
-}
-
 func (s *AgentPollerSuit) TestAcquireMetrics() {
-	p := NewPoller(0)
+	p := NewPoller()
 	p.Poll()
 	m := p.AcquireMetrics()
 
@@ -0,0 +1,329 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/server"
+)
+
+// collectorPush is a name of the push receiver collector.
+const collectorPush = "push"
+
+// unixAddressPrefix marks push address as a Unix socket path, e.g.
+// "unix:/run/gometrics-agent.sock".
+const unixAddressPrefix = "unix:"
+
+// Pushed series not updated for pushSeriesTTLReports report intervals are
+// expired. At most pushMaxSeries series are kept, pushes of new series are
+// rejected when the limit is reached.
+const (
+	pushSeriesTTLReports = 10
+	pushMaxSeries        = 10000
+)
+
+// pushReceiver accepts metrics pushed by applications running on the same
+// host and reports them along with the agent's own metrics. Metrics are
+// accepted in the same JSON shape as the server's POST /update/ (single
+// metric) and POST /updates/ (list of metrics) handlers expect, gzip
+// compressed body is supported.
+//
+// Pushed counter deltas are summed, gauges keep the last pushed value. Only
+// gauges and counters are accepted: pushed histograms would be merged by the
+// server again on every report.
+//
+// Receiver is a Collector: its snapshot holds cumulative sums of counters,
+// sender turns them into deltas the same way it does for the agent's own
+// counters.
+//
+// Series not pushed for ttl are expired, so series of stopped applications
+// (or ones with short-lived label values) don't pile up. Number of series is
+// limited by maxSeries. Every new counter gets a new epoch, so expired
+// counter pushed again is sent as a new one (see Metrics.CounterEpochs).
+type pushReceiver struct {
+	address   string
+	ttl       time.Duration
+	maxSeries int
+	listener  net.Listener
+	server    *http.Server
+	now       func() time.Time
+
+	mu        sync.Mutex
+	gauges    map[string]model.Gauge
+	counters  map[string]model.Counter
+	epochs    map[string]uint64    // epochs of counters
+	lastEpoch uint64               // epoch of the last added counter
+	updated   map[string]time.Time // last push of every series
+}
+
+// newPushReceiver creates receiver listening on address, series not pushed
+// for ttl are expired (never when ttl isn't positive).
+func newPushReceiver(address string, ttl time.Duration) *pushReceiver {
+	r := &pushReceiver{
+		address:   address,
+		ttl:       ttl,
+		maxSeries: pushMaxSeries,
+		now:       time.Now,
+		gauges:    make(map[string]model.Gauge),
+		counters:  make(map[string]model.Counter),
+		epochs:    make(map[string]uint64),
+		updated:   make(map[string]time.Time),
+	}
+
+	r.server = &http.Server{
+		Handler:           r.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return r
+}
+
+// Name implements Collector.
+func (r *pushReceiver) Name() string {
+	return collectorPush
+}
+
+// Collect implements Collector, expired series are removed.
+func (r *pushReceiver) Collect(_ context.Context) (Metrics, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+
+	m := Metrics{
+		Gauges:        make(map[string]model.Gauge, len(r.gauges)),
+		Counters:      make(map[string]model.Counter, len(r.counters)),
+		CounterEpochs: make(map[string]uint64, len(r.epochs)),
+	}
+
+	for key, v := range r.gauges {
+		m.Gauges[key] = v
+	}
+
+	for key, v := range r.counters {
+		m.Counters[key] = v
+		m.CounterEpochs[key] = r.epochs[key]
+	}
+
+	return m, nil
+}
+
+// listen implements listener. Receiver address is TCP address or Unix socket
+// path prefixed with "unix:". Stale socket file left by the previous run is
+// removed.
+func (r *pushReceiver) listen() (err error) {
+	if path, ok := strings.CutPrefix(r.address, unixAddressPrefix); ok {
+		if info, err := os.Stat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+			if err = os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove stale socket: %w", err)
+			}
+		}
+
+		r.listener, err = net.Listen("unix", path)
+	} else {
+		r.listener, err = net.Listen("tcp", r.address)
+	}
+	if err != nil {
+		return fmt.Errorf("push receiver failed to listen: %w", err)
+	}
+
+	return nil
+}
+
+// serve implements listener.
+func (r *pushReceiver) serve() {
+	log.Printf("Push receiver started on %s\n", r.listener.Addr())
+
+	if err := r.server.Serve(r.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Push receiver failed: %v\n", err)
+	}
+}
+
+// shutdown implements listener, active requests are waited for.
+func (r *pushReceiver) shutdown(ctx context.Context) error {
+	if err := r.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("push receiver shutdown failed: %w", err)
+	}
+
+	log.Println("Push receiver stopped")
+
+	return nil
+}
+
+func (r *pushReceiver) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/update/", func(w http.ResponseWriter, req *http.Request) {
+		var metric model.Metrics
+		if r.decode(w, req, &metric) {
+			r.push(w, []model.Metrics{metric})
+		}
+	})
+
+	mux.HandleFunc("/updates/", func(w http.ResponseWriter, req *http.Request) {
+		var metrics []model.Metrics
+		if r.decode(w, req, &metrics) {
+			r.push(w, metrics)
+		}
+	})
+
+	return mux
+}
+
+// decode decodes JSON request body (gzip compressed or not) into v. Error
+// response is written when false is returned.
+func (r *pushReceiver) decode(w http.ResponseWriter, req *http.Request, v any) bool {
+	if req.Method != http.MethodPost {
+		http.Error(w, server.ErrMsgMethodOnlyPOST, http.StatusMethodNotAllowed)
+		return false
+	}
+
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// push validates metrics and merges them into the receiver state. Nothing is
+// merged when any of metrics is invalid.
+func (r *pushReceiver) push(w http.ResponseWriter, metrics []model.Metrics) {
+	keys := make([]string, len(metrics))
+
+	for i, m := range metrics {
+		key, err := validatePushed(m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		keys[i] = key
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.fits(keys) {
+		r.expire()
+		if !r.fits(keys) {
+			http.Error(w, errTooManySeries.Error(), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	now := r.now()
+	for i, m := range metrics {
+		key := keys[i]
+
+		// expired series which hasn't been collected yet starts from scratch
+		if updated, ok := r.updated[key]; ok && r.expired(updated, now) {
+			r.remove(key)
+		}
+
+		switch m.MType {
+		case model.MetricTypeGauge:
+			r.gauges[key] = model.Gauge(*m.Value)
+		case model.MetricTypeCounter:
+			if _, ok := r.counters[key]; !ok {
+				r.lastEpoch++
+				r.epochs[key] = r.lastEpoch
+			}
+			r.counters[key] += model.Counter(*m.Delta)
+		}
+		r.updated[key] = now
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+var errTooManySeries = errors.New("too many pushed series")
+
+// fits tells whether series of keys can be added without exceeding the limit.
+// Must be called with mu held.
+func (r *pushReceiver) fits(keys []string) bool {
+	added := make(map[string]struct{})
+	for _, key := range keys {
+		if _, ok := r.updated[key]; !ok {
+			added[key] = struct{}{}
+		}
+	}
+
+	return len(r.updated)+len(added) <= r.maxSeries
+}
+
+// expire removes series not pushed for ttl. Must be called with mu held.
+func (r *pushReceiver) expire() {
+	now := r.now()
+	for key, updated := range r.updated {
+		if r.expired(updated, now) {
+			r.remove(key)
+		}
+	}
+}
+
+// expired tells whether series last pushed at updated is expired by now.
+func (r *pushReceiver) expired(updated, now time.Time) bool {
+	return r.ttl > 0 && now.Sub(updated) > r.ttl
+}
+
+// remove removes series by key. Must be called with mu held.
+func (r *pushReceiver) remove(key string) {
+	delete(r.gauges, key)
+	delete(r.counters, key)
+	delete(r.epochs, key)
+	delete(r.updated, key)
+}
+
+// validatePushed validates pushed metric and returns its series key.
+func validatePushed(m model.Metrics) (string, error) {
+	if m.ID = strings.TrimSpace(m.ID); m.ID == "" {
+		return "", server.ErrEmptyMetricName
+	}
+
+	key, err := model.SeriesKey(m.ID, m.Labels)
+	if err != nil {
+		return "", err
+	}
+
+	switch m.MType {
+	case model.MetricTypeGauge:
+		if m.Value == nil {
+			return "", server.ErrWrongMetricValue
+		}
+	case model.MetricTypeCounter:
+		if m.Delta == nil {
+			return "", server.ErrWrongMetricValue
+		}
+		if *m.Delta < 0 {
+			return "", server.ErrNegativeCounter
+		}
+	default:
+		return "", fmt.Errorf("%w: \"%s\"", server.ErrWrongMetricType, m.MType)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	configAgent "github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/server"
+	configServer "github.com/Dmitrevicz/gometrics/internal/server/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushReceiver_handler(t *testing.T) {
+	r := newPushReceiver("", 0)
+	h := r.handler()
+
+	post := func(path, body string, gzipped bool) int {
+		var buf bytes.Buffer
+		if gzipped {
+			zw := gzip.NewWriter(&buf)
+			_, err := zw.Write([]byte(body))
+			require.NoError(t, err)
+			require.NoError(t, zw.Close())
+		} else {
+			buf.WriteString(body)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, path, &buf)
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post("/update/", `{"id":"requests","type":"counter","delta":2}`, false))
+	assert.Equal(t, http.StatusOK, post("/update/", `{"id":"queue","type":"gauge","value":3}`, true))
+	assert.Equal(t, http.StatusOK, post("/updates/", `[
+		{"id":"requests","type":"counter","delta":5},
+		{"id":"requests","type":"counter","delta":1,"labels":{"handler":"pay"}},
+		{"id":"queue","type":"gauge","value":1.5}
+	]`, true))
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"bad json", "/update/", `{"id":`},
+		{"empty name", "/update/", `{"id":"","type":"gauge","value":1}`},
+		{"no value", "/update/", `{"id":"queue","type":"gauge"}`},
+		{"negative counter", "/update/", `{"id":"requests","type":"counter","delta":-1}`},
+		{"histogram", "/update/", `{"id":"latency","type":"histogram","histogram":{"buckets":[],"sum":0,"count":0}}`},
+		{"bad label", "/update/", `{"id":"queue","type":"gauge","value":1,"labels":{"bad-name":"v"}}`},
+		{"one of batch is invalid", "/updates/", `[{"id":"requests","type":"counter","delta":100},{"id":"","type":"gauge","value":1}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, post(tt.path, tt.body, false))
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/update/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	m, err := r.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]model.Counter{
+		"requests":                7,
+		`requests{handler="pay"}`: 1,
+	}, m.Counters)
+	assert.Equal(t, map[string]model.Gauge{"queue": 1.5}, m.Gauges)
+}
+
+func TestPushReceiver_unixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+
+	r := newPushReceiver(unixAddressPrefix+path, 0)
+	require.NoError(t, r.listen())
+	go r.serve()
+	defer r.shutdown(context.Background())
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	resp, err := client.Post("http://agent/update/", "application/json",
+		strings.NewReader(`{"id":"requests","type":"counter","delta":3}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	m, err := r.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]model.Counter{"requests": 3}, m.Counters)
+}
+
+func TestPushReceiver_limits(t *testing.T) {
+	now := time.Now()
+	r := newPushReceiver("", time.Minute)
+	r.now = func() time.Time { return now }
+	r.maxSeries = 2
+	h := r.handler()
+
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(`[{"id":"requests","type":"counter","delta":1},{"id":"queue","type":"gauge","value":1}]`))
+	// new series doesn't fit, known ones are still accepted
+	assert.Equal(t, http.StatusTooManyRequests, post(`[{"id":"requests","type":"counter","delta":1},{"id":"errors","type":"counter","delta":1}]`))
+	now = now.Add(45 * time.Second)
+	assert.Equal(t, http.StatusOK, post(`[{"id":"requests","type":"counter","delta":2}]`))
+
+	// queue isn't pushed for ttl, so it's expired and there's room for errors
+	now = now.Add(30 * time.Second)
+	m, err := r.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]model.Counter{"requests": 3}, m.Counters)
+	assert.Empty(t, m.Gauges)
+
+	assert.Equal(t, http.StatusOK, post(`[{"id":"errors","type":"counter","delta":1}]`))
+
+	// expired series is pushed again from scratch
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, http.StatusOK, post(`[{"id":"requests","type":"counter","delta":4}]`))
+	m, err = r.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]model.Counter{"requests": 4}, m.Counters)
+}
+
+func TestPushReceiver_expiredCounterSent(t *testing.T) {
+	ts := httptest.NewServer(server.New(configServer.NewTesting()))
+	defer ts.Close()
+
+	now := time.Now()
+	r := newPushReceiver("", time.Minute)
+	r.now = func() time.Time { return now }
+	h := r.handler()
+
+	push := func(delta int) {
+		body := fmt.Sprintf(`{"id":"requests","type":"counter","delta":%d}`, delta)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(r, time.Hour))
+
+	sender, err := NewSender(&configAgent.Config{ServerURL: ts.URL, Batch: true}, registry)
+	require.NoError(t, err)
+
+	report := func() {
+		require.NoError(t, registry.Collect(context.Background(), collectorPush))
+		sender.report(registry.Snapshot())
+	}
+
+	push(3)
+	report()
+	assert.Equal(t, "3", getValue(t, ts.URL+"/value/counter/requests"))
+
+	// series expires and is pushed again with delta greater than the old
+	// total before the next report, whole new sum must be sent
+	now = now.Add(2 * time.Minute)
+	push(10)
+	report()
+	assert.Equal(t, "13", getValue(t, ts.URL+"/value/counter/requests"))
+
+	// the same, but expired series has been collected in between
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, registry.Collect(context.Background(), collectorPush))
+	push(20)
+	report()
+	assert.Equal(t, "33", getValue(t, ts.URL+"/value/counter/requests"))
+}
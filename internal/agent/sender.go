@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -13,11 +14,13 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/agent/config"
 	"github.com/Dmitrevicz/gometrics/internal/model"
-	"github.com/Dmitrevicz/gometrics/internal/retry"
 	"github.com/Dmitrevicz/gometrics/internal/server"
 	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
 	"golang.org/x/sync/errgroup"
@@ -28,19 +31,41 @@ type MetricsSender interface {
 	Shutdown(ctx context.Context) error
 }
 
+// errBatchRejected is returned when the server refused to accept the batch
+// (e.g. it's malformed), so sending it again makes no sense.
+var errBatchRejected = errors.New("batch rejected by the server")
+
 type sender struct {
 	reportInterval int
 	url            string
 	key            string
 	hostIP         string
+	labels         model.Labels
+	agentID        string
 	batch          bool
-	poller         *poller
-	gopsutilPoller *gopsutilPoller
+	registry       *Registry
 	client         *http.Client
 	encryptor      *encryptor.Encryptor
 
 	quit  chan struct{}
 	timer *time.Timer
+	// running is held by Start loop, so the final report waits for the
+	// report being sent
+	running sync.Mutex
+
+	// bootNonce is generated on every start, so batch sequence numbers
+	// starting over after restart don't produce keys of already applied
+	// batches
+	bootNonce string
+	// seq is a sequence number of the last sent batch
+	seq atomic.Uint64
+
+	// deltas turns cumulative counters and histograms into increments to be
+	// sent in non-batched mode, streams have their own ones
+	deltas *deltaTracker
+
+	// streams batches are sent to (see config.Config.Targets)
+	streams []*stream
 
 	// Задание 15-го инкремента реализовал через семафор
 	//
@@ -48,7 +73,7 @@ type sender struct {
 	Semaphore *Semaphore
 }
 
-func NewSender(cfg *config.Config, poller *poller, gopsutilPoller *gopsutilPoller) (*sender, error) {
+func NewSender(cfg *config.Config, registry *Registry) (*sender, error) {
 	if cfg.RateLimit < 1 {
 		cfg.RateLimit = 1
 	}
@@ -72,22 +97,52 @@ func NewSender(cfg *config.Config, poller *poller, gopsutilPoller *gopsutilPolle
 		log.Println("Empty CRYPTO_KEY was provided - encryption will be disabled!")
 	}
 
-	return &sender{
+	bootNonce, err := newBootNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sender{
 		reportInterval: cfg.ReportInterval,
 		url:            cfg.ServerURL,
 		key:            cfg.Key,
 		hostIP:         cfg.HostIP,
+		labels:         cfg.Labels,
+		agentID:        cfg.AgentID,
+		bootNonce:      bootNonce,
 		batch:          cfg.Batch,
-		poller:         poller,
-		gopsutilPoller: gopsutilPoller,
+		registry:       registry,
 		client:         NewClientDefault(),
 		Semaphore:      NewSemaphore(cfg.RateLimit),
 		encryptor:      encrypt,
+		deltas:         newDeltaTracker(),
 		quit:           make(chan struct{}),
-	}, nil
+	}
+
+	// non-batched mode is supported by a single HTTP server only
+	if !s.batch && (len(cfg.Targets) > 0 || cfg.GRPCServerURL != "") {
+		log.Println("Batched mode is enabled, since targets or gRPC server are configured")
+		s.batch = true
+	}
+
+	if s.streams, err = s.newStreams(cfg); err != nil {
+		return nil, err
+	}
+
+	return s, nil
 }
 
 func (s *sender) Start() {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	select {
+	case <-s.quit:
+		// shut down before started
+		return
+	default:
+	}
+
 	log.Println("Sender started")
 
 	var ts time.Time
@@ -97,18 +152,10 @@ func (s *sender) Start() {
 	for {
 		select {
 		case ts = <-s.timer.C:
-			metrics := s.poller.AcquireMetrics()
-			metrics.Merge(s.gopsutilPoller.AcquireMetrics())
-
-			// iteration-12:
-			// > Научите агент работать с использованием нового API (отправлять метрики батчами).
-			if s.batch {
-				s.SendBatched(metrics)
-			} else {
-				s.Send(metrics)
-			}
+			s.report(s.registry.Snapshot())
 
-			fmt.Println("send fired:", time.Since(ts))
+			// logged rather than printed, stdout might be used by the stdout sink
+			log.Println("send fired:", time.Since(ts))
 
 			// I don't calculate delta-time, because current behaviour
 			// is good enough right now.
@@ -128,25 +175,49 @@ func (s *sender) Start() {
 	}
 }
 
-// Shutdown stops sender's ticker and sends current data to server.
-func (s *sender) Shutdown(ctx context.Context) error {
+// Shutdown stops sender's ticker, sends current data to server and closes
+// sinks.
+func (s *sender) Shutdown(ctx context.Context) (err error) {
 	log.Println("Stopping Sender")
 
 	s.stop()
 
 	wait := make(chan error, 1)
 	go func() {
-		metrics := s.poller.AcquireMetrics()
-		metrics.Merge(s.gopsutilPoller.AcquireMetrics())
-		s.SendBatched(metrics)
+		// report in flight must be acknowledged first, otherwise the same
+		// counter increments are sent twice
+		s.running.Lock()
+		defer s.running.Unlock()
+
+		s.report(s.registry.Snapshot())
 		close(wait)
 	}()
 
+	// sinks are closed even when the final report is timed out, pending
+	// sends to them fail then
+	defer func() {
+		for _, st := range s.streams {
+			err = errors.Join(err, closeSinks(st.sinks))
+		}
+	}()
+
 	select {
 	case <-ctx.Done():
 		return fmt.Errorf("sender Shutdown failed: %v", ctx.Err())
 	case <-wait:
-		return nil
+	}
+
+	return nil
+}
+
+// report sends metrics the way configured.
+func (s *sender) report(metrics Metrics) {
+	// iteration-12:
+	// > Научите агент работать с использованием нового API (отправлять метрики батчами).
+	if s.batch {
+		s.SendBatched(metrics)
+	} else {
+		s.Send(metrics)
 	}
 }
 
@@ -167,6 +238,9 @@ func (s *sender) Send(metrics Metrics) {
 	ts := time.Now()
 	g := new(errgroup.Group)
 
+	metrics.Counters = s.deltas.Deltas(metrics.Counters, metrics.CounterEpochs)
+	metrics.Histograms = s.deltas.HistogramDeltas(metrics.Histograms)
+
 	for name, gauge := range metrics.Gauges {
 		name := name
 		gauge := gauge
@@ -189,6 +263,34 @@ func (s *sender) Send(metrics Metrics) {
 			if err != nil {
 				return fmt.Errorf("counter update request failed: %w", err)
 			}
+			s.deltas.Ack(map[string]model.Counter{name: counter})
+			return nil
+		})
+	}
+
+	for name, histogram := range metrics.Histograms {
+		name := name
+		histogram := histogram
+		// make it async
+		g.Go(func() error {
+			err := s.sendMetrics(name, histogram)
+			if err != nil {
+				return fmt.Errorf("histogram update request failed: %w", err)
+			}
+			s.deltas.AckHistograms(map[string]model.Histogram{name: histogram})
+			return nil
+		})
+	}
+
+	for name, summary := range metrics.Summaries {
+		name := name
+		summary := summary
+		// make it async
+		g.Go(func() error {
+			err := s.sendMetrics(name, summary)
+			if err != nil {
+				return fmt.Errorf("summary update request failed: %w", err)
+			}
 			return nil
 		})
 	}
@@ -197,32 +299,77 @@ func (s *sender) Send(metrics Metrics) {
 		log.Println("Got error while sending metric update request: " + err.Error())
 	}
 
-	log.Printf("Metrics have been sent (%d in %v)\n", len(metrics.Counters)+len(metrics.Gauges), time.Since(ts))
+	log.Printf("Metrics have been sent (%d in %v)\n", metrics.Len(), time.Since(ts))
+}
+
+// series splits metrics key into metric name and labels. Key can be a series
+// key (see model.SeriesKey), its labels are merged with the agent labels then.
+func (s *sender) series(key string) (string, model.Labels) {
+	name, labels, err := model.ParseSeriesKey(key)
+	if err != nil || len(labels) == 0 {
+		return key, s.labels
+	}
+
+	for k, v := range s.labels {
+		if _, ok := labels[k]; !ok {
+			labels[k] = v
+		}
+	}
+
+	return name, labels
 }
 
 func (s *sender) prepareMetricsBatch(metrics Metrics) (batch []model.Metrics) {
-	batch = make([]model.Metrics, 0, len(metrics.Gauges)+len(metrics.Counters))
+	batch = make([]model.Metrics, 0, metrics.Len())
 
 	for name, val := range metrics.Gauges {
+		id, labels := s.series(name)
 		val := val
 		gauge := model.Metrics{
-			MType: model.MetricTypeGauge,
-			ID:    name,
-			Value: (*float64)(&val),
+			MType:  model.MetricTypeGauge,
+			ID:     id,
+			Value:  (*float64)(&val),
+			Labels: labels,
 		}
 		batch = append(batch, gauge)
 	}
 
 	for name, val := range metrics.Counters {
+		id, labels := s.series(name)
 		val := val
 		counter := model.Metrics{
-			MType: model.MetricTypeCounter,
-			ID:    name,
-			Delta: (*int64)(&val),
+			MType:  model.MetricTypeCounter,
+			ID:     id,
+			Delta:  (*int64)(&val),
+			Labels: labels,
 		}
 		batch = append(batch, counter)
 	}
 
+	for name, val := range metrics.Histograms {
+		id, labels := s.series(name)
+		val := val
+		histogram := model.Metrics{
+			MType:     model.MetricTypeHistogram,
+			ID:        id,
+			Histogram: &val,
+			Labels:    labels,
+		}
+		batch = append(batch, histogram)
+	}
+
+	for name, val := range metrics.Summaries {
+		id, labels := s.series(name)
+		val := val
+		summary := model.Metrics{
+			MType:   model.MetricTypeSummary,
+			ID:      id,
+			Summary: &val,
+			Labels:  labels,
+		}
+		batch = append(batch, summary)
+	}
+
 	return batch
 }
 
@@ -239,16 +386,91 @@ func (s *sender) SendBatched(metrics Metrics) {
 
 	ts := time.Now()
 
+	// streams are independent, so they're sent concurrently
+	var wg sync.WaitGroup
+	for _, st := range s.streams {
+		wg.Add(1)
+		go func(st *stream) {
+			defer wg.Done()
+
+			if err := s.deliver(st, metrics); err != nil {
+				log.Printf("Got error while sending batched update request to %s: %v\n", st.name, err)
+			}
+		}(st)
+	}
+	wg.Wait()
+
+	log.Printf("Metrics have been sent (%d in %v)\n", metrics.Len(), time.Since(ts))
+}
+
+// deliver sends metrics to stream as a single batch, counter and histogram
+// increments are acknowledged when the batch is sent.
+//
+// When spool is enabled, spooled batches are sent first, in order. Batch
+// failed to be sent (or not sent because spooled ones are still pending) is
+// spooled and increments are acknowledged too, since the batch will be
+// replayed later.
+func (s *sender) deliver(st *stream, metrics Metrics) error {
+	metrics.Counters = st.deltas.Deltas(metrics.Counters, metrics.CounterEpochs)
+	metrics.Histograms = st.deltas.HistogramDeltas(metrics.Histograms)
 	batch := s.prepareMetricsBatch(metrics)
 
-	retry := retry.NewRetrier(time.Second, 3)
-	if err := retry.Do("send batched metrics", func() error {
-		return s.sendBatched(batch)
-	}); err != nil {
-		log.Println("Got error while sending batched update request: " + err.Error())
+	if st.spool == nil {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := st.send(batch, s.nextIdempotencyKey()); err != nil {
+			return err
+		}
+
+		st.deltas.Ack(metrics.Counters)
+		st.deltas.AckHistograms(metrics.Histograms)
+		return nil
+	}
+
+	err := st.spool.Replay(st.send)
+	if len(batch) == 0 {
+		return err
+	}
+
+	key := s.nextIdempotencyKey()
+	if err == nil {
+		err = st.send(batch, key)
+	}
+
+	if err != nil {
+		if errors.Is(err, errBatchRejected) {
+			return err
+		}
+
+		if spoolErr := st.spool.Push(batch, key); spoolErr != nil {
+			return errors.Join(err, spoolErr)
+		}
+
+		log.Printf("Batch spooled (%d batches pending): %v\n", st.spool.Len(), err)
 	}
 
-	log.Printf("Metrics have been sent (%d in %v)\n", len(metrics.Counters)+len(metrics.Gauges), time.Since(ts))
+	st.deltas.Ack(metrics.Counters)
+	st.deltas.AckHistograms(metrics.Histograms)
+
+	return nil
+}
+
+// nextIdempotencyKey returns idempotency key for the next batch: agent ID,
+// boot nonce and batch sequence number.
+func (s *sender) nextIdempotencyKey() string {
+	return s.agentID + "-" + s.bootNonce + "-" + strconv.FormatUint(s.seq.Add(1), 10)
+}
+
+// newBootNonce returns random hex encoded nonce identifying the agent run.
+func newBootNonce() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("boot nonce generation failed: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
 }
 
 // DefaultHTTPClientTimeoutSeconds - custom default http client timeout in seconds.
@@ -301,15 +523,17 @@ func (s *sender) sendCounter(name string, value model.Counter) error {
 	return nil
 }
 
-// sendMetrics - value must be either of type model.Counter or model.Gauge,
-// otherwise error will be returned.
+// sendMetrics - value must be of type model.Counter, model.Gauge,
+// model.Histogram or model.Summary, otherwise error will be returned.
 func (s *sender) sendMetrics(name string, value any) error {
 	s.Semaphore.Acquire()
 	defer s.Semaphore.Release()
 
 	// configure struct to be sent in request body
+	id, labels := s.series(name)
 	metrics := model.Metrics{
-		ID: name,
+		ID:     id,
+		Labels: labels,
 	}
 
 	switch v := value.(type) {
@@ -321,6 +545,12 @@ func (s *sender) sendMetrics(name string, value any) error {
 		d := int64(v)
 		metrics.Delta = &d
 		metrics.MType = model.MetricTypeCounter
+	case model.Histogram:
+		metrics.Histogram = &v
+		metrics.MType = model.MetricTypeHistogram
+	case model.Summary:
+		metrics.Summary = &v
+		metrics.MType = model.MetricTypeSummary
 	default:
 		return errors.New("unexpected metric value type")
 	}
@@ -387,12 +617,13 @@ func (s *sender) compress(b []byte) (*bytes.Buffer, error) {
 	return buf, nil
 }
 
-// sendBatched sends metrics batch to server.
+// sendBatched sends metrics batch to server at url. Idempotency key is sent in
+// server.IdempotencyKeyHeader when not empty.
 //
 // > Научите агент работать с использованием нового API (отправлять метрики батчами).
 //
 // TODO: Maybe somehow remake encryption as middleware or smth...
-func (s *sender) sendBatched(batch []model.Metrics) error {
+func (s *sender) sendBatched(url string, batch []model.Metrics, idempotencyKey string) error {
 	s.Semaphore.Acquire()
 	defer s.Semaphore.Release()
 
@@ -427,8 +658,7 @@ func (s *sender) sendBatched(batch []model.Metrics) error {
 	}
 
 	// prepare request
-	url := s.url + "/updates/"
-	req, err := http.NewRequest(http.MethodPost, url, buf)
+	req, err := http.NewRequest(http.MethodPost, url+"/updates/", buf)
 	if err != nil {
 		return fmt.Errorf("error preparing the request: %w", err)
 	}
@@ -440,6 +670,10 @@ func (s *sender) sendBatched(batch []model.Metrics) error {
 		req.Header.Set(server.XRealIPHeader, s.hostIP)
 	}
 
+	if idempotencyKey != "" {
+		req.Header.Set(server.IdempotencyKeyHeader, idempotencyKey)
+	}
+
 	if s.encryptor != nil {
 		// show via header that content is encrypted
 		req.Header.Set(server.EncryptionHeader, "1")
@@ -473,6 +707,9 @@ func (s *sender) sendBatched(batch []model.Metrics) error {
 		if resp.StatusCode >= 500 && resp.StatusCode < 600 {
 			return model.NewRetriableError(err)
 		}
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return fmt.Errorf("%w: %w", errBatchRejected, err)
+		}
 		return err
 	}
 
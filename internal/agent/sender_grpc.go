@@ -2,194 +2,218 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
 	pb "github.com/Dmitrevicz/gometrics/internal/server/grpc/proto"
-	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-type grpcSender struct {
-	sender        // inherit all fields from default sender for now...
-	url    string // grpc server address
+// DefaultGRPCClientTimeout - custom default timeout duration for gRPC client.
+const DefaultGRPCClientTimeout = 10 * time.Second
+
+// gRPC connection parameters. Keepalive pings detect broken connection
+// between reports, server must permit pings this often (see
+// grpcServer.KeepaliveEnforcementPolicy).
+const (
+	grpcKeepaliveTime     = 30 * time.Second
+	grpcKeepaliveTimeout  = 10 * time.Second
+	grpcReconnectMaxDelay = 30 * time.Second
+)
+
+// grpcSink sends batches to the server gRPC API. Requests are compressed,
+// signed, encrypted and marked with host IP the same way as HTTP ones, see
+// pb.UnaryClientInterceptor.
+//
+// Connection is long-lived: it's established on the first send and reused
+// by the following ones. Broken connection is re-established by gRPC in the
+// background with backoff, sends fail fast meanwhile and go through the
+// sender retries and spool.
+//
+// Batches are sent with UpdateBatch method, or with UpdateStream metric by
+// metric when stream is set.
+type grpcSink struct {
+	s       *sender
+	address string
+	stream  bool
+
+	mu     sync.Mutex
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+	// stop stops connection state monitoring
+	stop context.CancelFunc
 }
 
-func NewSenderGRPC(cfg *config.Config, poller *poller, gopsutilPoller *gopsutilPoller) (*grpcSender, error) {
-	log.Println("gRPC sender will be used")
+// Name implements Sink.
+func (t *grpcSink) Name() string {
+	return config.GRPCTargetPrefix + t.address
+}
 
-	if cfg.RateLimit < 1 {
-		cfg.RateLimit = 1
+// Send implements Sink.
+func (t *grpcSink) Send(batch []model.Metrics, idempotencyKey string) error {
+	client, err := t.connect()
+	if err != nil {
+		return err
 	}
 
-	if cfg.HostIP == "" {
-		log.Println("Couldn't detect host IP - X-Real-IP header will not be set on outgoing requests!")
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultGRPCClientTimeout)
+	defer cancel()
 
-	var (
-		encrypt *encryptor.Encryptor
-		err     error
-	)
+	if t.stream {
+		err = t.sendStream(ctx, client, batch, idempotencyKey)
+	} else {
+		_, err = client.UpdateBatch(ctx, &pb.UpdateBatchRequest{
+			Metrics:        pbMetrics(batch),
+			IdempotencyKey: idempotencyKey,
+		})
+	}
 
-	if cfg.CryptoKey != "" {
-		encrypt, err = encryptor.NewEncryptor(cfg.CryptoKey)
-		if err != nil {
-			return nil, err
+	if err != nil {
+		e := status.Convert(err)
+		err = fmt.Errorf("code: %s, err: %s", e.Code(), e.Message())
+		switch e.Code() {
+		case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied:
+			return fmt.Errorf("%w: %w", errBatchRejected, err)
+		case codes.Unavailable, codes.DeadlineExceeded:
+			return model.NewRetriableError(err)
 		}
-	} else {
-		log.Println("Empty CRYPTO_KEY was provided - encryption will be disabled!")
+		return err
 	}
 
-	return &grpcSender{
-		url: cfg.GRPCServerURL,
-		sender: sender{
-			reportInterval: cfg.ReportInterval,
-			url:            cfg.ServerURL,
-			key:            cfg.Key,
-			hostIP:         cfg.HostIP,
-			batch:          cfg.Batch,
-			poller:         poller,
-			gopsutilPoller: gopsutilPoller,
-			client:         NewClientDefault(),
-			Semaphore:      NewSemaphore(cfg.RateLimit),
-			encryptor:      encrypt,
-			quit:           make(chan struct{}),
-		},
-	}, nil
+	return nil
 }
 
-func (s *grpcSender) Start() {
-	log.Println("Sender started")
-
-	var ts time.Time
-	sleepDuration := time.Second * time.Duration(s.reportInterval)
-	s.timer = time.NewTimer(sleepDuration)
-
-	for {
-		select {
-		case ts = <-s.timer.C:
-			metrics := s.poller.AcquireMetrics()
-			metrics.Merge(s.gopsutilPoller.AcquireMetrics())
-
-			s.SendBatched(metrics)
-
-			fmt.Println("send fired:", time.Since(ts))
+// sendStream sends batch with UpdateStream method. Idempotency key is passed
+// in metadata, the server applies the stream in several batches keyed by it.
+func (t *grpcSink) sendStream(ctx context.Context, client pb.MetricsClient, batch []model.Metrics, idempotencyKey string) error {
+	if idempotencyKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, pb.IdempotencyKeyMetadataKey, idempotencyKey)
+	}
 
-			// I don't calculate delta-time, because current behaviour
-			// is good enough right now.
-			s.timer.Reset(sleepDuration)
+	stream, err := client.UpdateStream(ctx)
+	if err != nil {
+		return err
+	}
 
-		case <-s.quit:
-			// stop the timer
-			if !s.timer.Stop() {
-				// drain the chanel (might not be needed here, but leave it be
-				// as a kind of exercise)
-				<-s.timer.C
+	for _, metric := range pbMetrics(batch) {
+		if err = stream.Send(metric); err != nil {
+			// io.EOF means the stream was aborted by the server, actual
+			// error is returned by CloseAndRecv
+			if errors.Is(err, io.EOF) {
+				break
 			}
-
-			log.Println("Sender timer stopped")
-			return
+			return err
 		}
 	}
+
+	_, err = stream.CloseAndRecv()
+	return err
 }
 
-// Shutdown stops sender's ticker and sends current data to server.
-func (s *grpcSender) Shutdown(ctx context.Context) error {
-	log.Println("Stopping Sender")
-
-	s.stop()
-
-	wait := make(chan error, 1)
-	go func() {
-		// send all data to the Server before program exit
-		metrics := s.poller.AcquireMetrics()
-		metrics.Merge(s.gopsutilPoller.AcquireMetrics())
-		s.SendBatched(metrics)
-		close(wait)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return fmt.Errorf("sender Shutdown failed: %v", ctx.Err())
-	case <-wait:
+// Close implements Sink. Connection is closed, the next send establishes a
+// new one.
+func (t *grpcSink) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
 		return nil
 	}
-}
 
-// stop stops sender's timer.
-func (s *grpcSender) stop() {
-	close(s.quit)
-	log.Println("Sender stopped")
+	t.stop()
+	err := t.conn.Close()
+	t.conn, t.client, t.stop = nil, nil, nil
+
+	return err
 }
 
-// DefaultGRPCClientTimeout - custom default timeout duration for gRPC client.
-const DefaultGRPCClientTimeout = 10 * time.Second
+// connect returns client of the connection, connection is established when
+// missing. Dial doesn't wait for the connection to be ready, so it fails on
+// bad options only.
+func (t *grpcSink) connect() (pb.MetricsClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-// SendBatched overrides (overlaps) default sender behaviour to use gRPC client.
-//
-// TODO: compression, encryption, hash, host ip
-func (s *grpcSender) SendBatched(metrics Metrics) {
-	if metrics.Len() == 0 {
-		log.Println("Metrics report skipped (nothing to be sent)")
-		return
+	if t.client != nil {
+		return t.client, nil
 	}
 
-	log.Println("Metrics report started (batched)")
-	ts := time.Now()
-
-	// XXX: Насколько плохо так делать? Как было бы правильней?
-	// didn't have time to investigate how to reuse grpc connection properly, so
-	// just create new every time, for now...
-	conn, err := grpc.Dial(s.url, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	reconnect := backoff.DefaultConfig
+	reconnect.MaxDelay = grpcReconnectMaxDelay
+
+	conn, err := grpc.Dial(t.address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(pb.UnaryClientInterceptor(t.s.key, t.s.encryptor, t.s.hostIP)),
+		grpc.WithStreamInterceptor(pb.StreamClientInterceptor(t.s.key, t.s.encryptor, t.s.hostIP)),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: reconnect}),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                grpcKeepaliveTime,
+			Timeout:             grpcKeepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to connect to %s: %w", t.address, err)
 	}
-	defer conn.Close()
-	client := pb.NewMetricsClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultGRPCClientTimeout)
-	defer cancel()
+	ctx, cancel := context.WithCancel(context.Background())
+	go t.monitor(ctx, conn)
 
-	req := new(pb.UpdateBatchRequest)
-	s.prepareRequest(metrics, req)
-	if _, err := client.UpdateBatch(ctx, req); err != nil {
-		errMsg := "Got error while sending gRPC batched update request"
-		e := status.Convert(err)
-		log.Printf("%s: code: %s, err: %s\n", errMsg, e.Code(), e.Message())
-	}
+	t.conn, t.client, t.stop = conn, pb.NewMetricsClient(conn), cancel
 
-	log.Printf("Metrics (%d) have been sent (in %v)\n", len(req.Metrics), time.Since(ts))
+	return t.client, nil
 }
 
-func (s *grpcSender) prepareRequest(metrics Metrics, req *pb.UpdateBatchRequest) {
-	if req == nil {
-		log.Println("[grpcSender.prepareRequest] got nil as *pb.UpdateBatchRequest")
-		return
+// monitor logs connection state changes until ctx is done.
+func (t *grpcSink) monitor(ctx context.Context, conn *grpc.ClientConn) {
+	state := conn.GetState()
+
+	for conn.WaitForStateChange(ctx, state) {
+		prev := state
+		state = conn.GetState()
+		log.Printf("gRPC connection to %s: %s -> %s\n", t.address, prev, state)
 	}
+}
 
-	req.Metrics = make([]*pb.Metric, 0, metrics.Len())
+// pbMetrics converts metrics batch to protobuf messages.
+func pbMetrics(batch []model.Metrics) []*pb.Metric {
+	metrics := make([]*pb.Metric, 0, len(batch))
 
-	for name, val := range metrics.Gauges {
-		val := val
-		gauge := pb.Metric{
-			Type:  pb.MetricType_GAUGE,
-			Id:    name,
-			Value: (*float64)(&val),
+	for _, m := range batch {
+		metric := &pb.Metric{
+			Id:     m.ID,
+			Value:  m.Value,
+			Delta:  m.Delta,
+			Labels: m.Labels,
 		}
-		req.Metrics = append(req.Metrics, &gauge)
-	}
 
-	for name, val := range metrics.Counters {
-		val := val
-		counter := pb.Metric{
-			Type:  pb.MetricType_COUNTER,
-			Id:    name,
-			Delta: (*int64)(&val),
+		switch m.MType {
+		case model.MetricTypeGauge:
+			metric.Type = pb.MetricType_GAUGE
+		case model.MetricTypeCounter:
+			metric.Type = pb.MetricType_COUNTER
+		case model.MetricTypeHistogram:
+			metric.Type = pb.MetricType_HISTOGRAM
+			metric.Histogram = pb.NewHistogram(*m.Histogram)
+		case model.MetricTypeSummary:
+			metric.Type = pb.MetricType_SUMMARY
+			metric.Summary = pb.NewSummary(*m.Summary)
 		}
-		req.Metrics = append(req.Metrics, &counter)
+
+		metrics = append(metrics, metric)
 	}
+
+	return metrics
 }
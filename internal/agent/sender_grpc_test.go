@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	configAgent "github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/logger"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	configServer "github.com/Dmitrevicz/gometrics/internal/server/config"
+	grpcServer "github.com/Dmitrevicz/gometrics/internal/server/grpc"
+	pb "github.com/Dmitrevicz/gometrics/internal/server/grpc/proto"
+	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestGRPCSink_Send(t *testing.T) {
+	pub, priv := prepareTestSenderRSAKeyFiles(t)
+	hashKey := "8bb5929d212764f7923ae9998fa18aa46ca4ee8b1cfd319b"
+
+	cfgServer := configServer.NewTesting()
+	cfgServer.CryptoKey = priv
+	cfgServer.Key = hashKey
+	cfgServer.TrustedSubnet = "127.0.0.0/8"
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	metricsServer := grpcServer.NewMetricsServer(cfgServer)
+	s := grpc.NewServer(grpcServer.Interceptors(logger.Log, cfgServer)...)
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(listen)
+	defer s.Stop()
+
+	for mode, stream := range map[string]bool{"UpdateBatch": false, "UpdateStream": true} {
+		t.Run(mode, func(t *testing.T) {
+			cfgAgent := &configAgent.Config{
+				GRPCServerURL: listen.Addr().String(),
+				GRPCStream:    stream,
+				Key:           hashKey,
+				CryptoKey:     pub,
+				HostIP:        "127.0.0.1",
+				AgentID:       mode,
+			}
+
+			snd, err := NewSender(cfgAgent, NewRegistry())
+			require.NoError(t, err)
+			defer snd.Shutdown(context.Background())
+
+			snd.SendBatched(Metrics{
+				Gauges:   map[string]model.Gauge{mode + "Alloc": 1.5},
+				Counters: map[string]model.Counter{mode + "Count": 3},
+			})
+
+			gauge, err := metricsServer.Storage.Gauges().Get(context.Background(), mode+"Alloc")
+			require.NoError(t, err)
+			assert.EqualValues(t, 1.5, gauge)
+
+			counter, err := metricsServer.Storage.Counters().Get(context.Background(), mode+"Count")
+			require.NoError(t, err)
+			assert.EqualValues(t, 3, counter)
+
+			// requests failed security checks are rejected
+			batch := testBatch(mode+"Count", 1)
+			for name, modify := range map[string]func(s *sender){
+				"wrong hash":  func(s *sender) { s.key = "wrong" },
+				"no hash":     func(s *sender) { s.key = "" },
+				"not trusted": func(s *sender) { s.hostIP = "10.0.0.1" },
+				"no host ip":  func(s *sender) { s.hostIP = "" },
+			} {
+				t.Run(name, func(t *testing.T) {
+					s, err := NewSender(cfgAgent, NewRegistry())
+					require.NoError(t, err)
+					modify(s)
+
+					sink := &grpcSink{s: s, address: cfgAgent.GRPCServerURL, stream: stream}
+					defer sink.Close()
+
+					require.ErrorIs(t, sink.Send(batch, name), errBatchRejected)
+				})
+			}
+		})
+	}
+}
+
+func TestGRPCSink_reconnect(t *testing.T) {
+	cfgServer := configServer.NewTesting()
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listen.Addr().String()
+
+	s := grpc.NewServer(grpcServer.KeepaliveEnforcementPolicy())
+	pb.RegisterMetricsServer(s, grpcServer.NewMetricsServer(cfgServer))
+	go s.Serve(listen)
+
+	snd, err := NewSender(&configAgent.Config{GRPCServerURL: address}, NewRegistry())
+	require.NoError(t, err)
+
+	sink := &grpcSink{s: snd, address: address}
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(testBatch("PollCount", 1), "a-1"))
+	conn := sink.conn
+
+	// server is down: send fails, but doesn't break the sink
+	s.Stop()
+	err = sink.Send(testBatch("PollCount", 2), "a-2")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errBatchRejected)
+
+	// server is back on the same address: connection is re-established
+	listen, err = net.Listen("tcp", address)
+	require.NoError(t, err)
+
+	s = grpc.NewServer(grpcServer.KeepaliveEnforcementPolicy())
+	pb.RegisterMetricsServer(s, grpcServer.NewMetricsServer(cfgServer))
+	go s.Serve(listen)
+	defer s.Stop()
+
+	require.Eventually(t, func() bool {
+		return sink.Send(testBatch("PollCount", 2), "a-2") == nil
+	}, 5*time.Second, 100*time.Millisecond)
+	assert.Same(t, conn, sink.conn, "connection is reused")
+
+	require.NoError(t, sink.Close())
+	assert.Nil(t, sink.conn)
+}
+
+func TestSender_ShutdownTimeout(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, grpcServer.NewMetricsServer(configServer.NewTesting()))
+	go s.Serve(listen)
+	defer s.Stop()
+
+	snd, err := NewSender(&configAgent.Config{GRPCServerURL: listen.Addr().String()}, NewRegistry())
+	require.NoError(t, err)
+
+	sink := snd.streams[0].sinks[0].(*grpcSink)
+	_, err = sink.connect()
+	require.NoError(t, err)
+
+	// connection is closed even though the final report is timed out
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = snd.Shutdown(ctx)
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.Nil(t, sink.conn)
+}
+
+func TestGRPCInterceptors_readMethods(t *testing.T) {
+	pub, priv := prepareTestSenderRSAKeyFiles(t)
+	hashKey := "8bb5929d212764f7923ae9998fa18aa46ca4ee8b1cfd319b"
+
+	cfgServer := configServer.NewTesting()
+	cfgServer.CryptoKey = priv
+	cfgServer.Key = hashKey
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer(grpcServer.Interceptors(logger.Log, cfgServer)...)
+	pb.RegisterMetricsServer(s, grpcServer.NewMetricsServer(cfgServer))
+	go s.Serve(listen)
+	defer s.Stop()
+
+	enc, err := encryptor.NewEncryptor(pub)
+	require.NoError(t, err)
+
+	// requests without encrypted field are sent as is, reading methods don't
+	// require hash
+	for _, key := range []string{hashKey, ""} {
+		conn, err := grpc.Dial(listen.Addr().String(),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithUnaryInterceptor(pb.UnaryClientInterceptor(key, enc, "")),
+		)
+		require.NoError(t, err)
+		defer conn.Close()
+		client := pb.NewMetricsClient(conn)
+
+		ctx := context.Background()
+		value := 1.5
+
+		_, err = client.Update(ctx, &pb.Metric{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: &value})
+		if key == "" {
+			require.Equal(t, codes.Unauthenticated, status.Code(err))
+		} else {
+			require.NoError(t, err)
+		}
+
+		_, err = client.Ping(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+
+		metric, err := client.GetValue(ctx, &pb.GetMetricRequest{Id: "Alloc", Type: pb.MetricType_GAUGE})
+		require.NoError(t, err)
+		assert.Equal(t, value, metric.GetValue())
+	}
+}
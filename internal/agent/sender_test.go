@@ -1,15 +1,21 @@
 package agent
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	configAgent "github.com/Dmitrevicz/gometrics/internal/agent/config"
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/server"
 	configServer "github.com/Dmitrevicz/gometrics/internal/server/config"
 	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,10 +32,7 @@ func TestSender(t *testing.T) {
 		ReportInterval: 0,
 		Batch:          false,
 	}
-	poller := NewPoller(cfgAgent.PollInterval)
-	gopsutilPoller := NewGopsutilPoller(cfgAgent.PollInterval)
-
-	sender, err := NewSender(cfgAgent, poller, gopsutilPoller)
+	sender, err := NewSender(cfgAgent, NewRegistry())
 	require.NoError(t, err)
 
 	gaugeValue := model.Gauge(42.420)
@@ -57,6 +60,122 @@ func TestSender(t *testing.T) {
 	})
 }
 
+func TestSender_SendBatchedDeltas(t *testing.T) {
+	ts := httptest.NewServer(server.New(configServer.NewTesting()))
+	defer ts.Close()
+
+	cfgAgent := &configAgent.Config{
+		ServerURL: ts.URL,
+		Batch:     true,
+	}
+
+	sender, err := NewSender(cfgAgent, NewRegistry())
+	require.NoError(t, err)
+
+	// collector reports cumulative value, server must receive only increments
+	for _, pollCount := range []model.Counter{3, 5, 10} {
+		sender.SendBatched(Metrics{Counters: map[string]model.Counter{"PollCount": pollCount}})
+	}
+
+	assert.Equal(t, "10", getValue(t, ts.URL+"/value/counter/PollCount"))
+}
+
+func TestSender_histogramDeltas(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		t.Run(fmt.Sprintf("batch=%t", batch), func(t *testing.T) {
+			srv := server.New(configServer.NewTesting())
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			sender, err := NewSender(&configAgent.Config{ServerURL: ts.URL, Batch: batch}, NewRegistry())
+			require.NoError(t, err)
+
+			h := model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 1}}, Sum: 2, Count: 2}
+
+			// histogram isn't changed between reports, it mustn't be merged twice
+			sender.report(Metrics{Histograms: map[string]model.Histogram{"Latency": h}})
+			sender.report(Metrics{Histograms: map[string]model.Histogram{"Latency": h}})
+
+			stored, err := srv.Storage.Histograms().Get(context.Background(), "Latency")
+			require.NoError(t, err)
+			assert.Equal(t, h, stored)
+		})
+	}
+}
+
+func TestSender_SendBatchedRestart(t *testing.T) {
+	ts := httptest.NewServer(server.New(configServer.NewTesting()))
+	defer ts.Close()
+
+	// restarted agent with the same ID starts batch sequence over, its
+	// batches mustn't be taken for already applied ones
+	for _, pollCount := range []model.Counter{3, 5} {
+		cfgAgent := &configAgent.Config{
+			ServerURL: ts.URL,
+			AgentID:   "restarted",
+			Batch:     true,
+		}
+
+		sender, err := NewSender(cfgAgent, NewRegistry())
+		require.NoError(t, err)
+
+		sender.SendBatched(Metrics{Counters: map[string]model.Counter{"RestartCount": pollCount}})
+	}
+
+	assert.Equal(t, "8", getValue(t, ts.URL+"/value/counter/RestartCount"))
+}
+
+func TestSender_Shutdown(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		t.Run(fmt.Sprintf("batch=%t", batch), func(t *testing.T) {
+			ts := httptest.NewServer(server.New(configServer.NewTesting()))
+			defer ts.Close()
+
+			collector := &staticCollector{
+				name:    "static",
+				metrics: Metrics{Counters: map[string]model.Counter{"ShutdownCount": 3}},
+			}
+			registry := NewRegistry()
+			require.NoError(t, registry.Register(collector, time.Hour))
+			require.NoError(t, registry.Collect(context.Background(), collector.name))
+
+			cfgAgent := &configAgent.Config{
+				ServerURL:      ts.URL,
+				Batch:          batch,
+				ReportInterval: 3600,
+			}
+
+			sender, err := NewSender(cfgAgent, registry)
+			require.NoError(t, err)
+			go sender.Start()
+
+			sender.report(registry.Snapshot())
+
+			// the final report sends only increments in both modes
+			collector.metrics.Counters["ShutdownCount"] = 5
+			require.NoError(t, registry.Collect(context.Background(), collector.name))
+			require.NoError(t, sender.Shutdown(context.Background()))
+
+			assert.Equal(t, "5", getValue(t, ts.URL+"/value/counter/ShutdownCount"))
+		})
+	}
+}
+
+// getValue requests metric value from the server.
+func getValue(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	return string(body)
+}
+
 func TestSender_sendBatchedEncrypted(t *testing.T) {
 	// generate files containing encryption keys
 	pub, priv := prepareTestSenderRSAKeyFiles(t)
@@ -79,10 +198,7 @@ func TestSender_sendBatchedEncrypted(t *testing.T) {
 		Batch:          false,
 	}
 
-	poller := NewPoller(cfgAgent.PollInterval)
-	gopsutilPoller := NewGopsutilPoller(cfgAgent.PollInterval)
-
-	sender, err := NewSender(cfgAgent, poller, gopsutilPoller)
+	sender, err := NewSender(cfgAgent, NewRegistry())
 	require.NoError(t, err, "failed to create sender instance")
 
 	var (
@@ -104,7 +220,7 @@ func TestSender_sendBatchedEncrypted(t *testing.T) {
 		},
 	}
 
-	err = sender.sendBatched(metricsBatch)
+	err = sender.sendBatched(ts.URL, metricsBatch, "")
 	require.NoError(t, err, "failed to send metrics")
 }
 
@@ -142,3 +258,15 @@ func prepareTestSenderRSAKeyFiles(t *testing.T) (pub, priv string) {
 
 	return fPub.Name(), fPriv.Name()
 }
+
+func TestSender_series(t *testing.T) {
+	s := &sender{labels: model.Labels{"host": "web1", "device": "agent"}}
+
+	name, labels := s.series("Alloc")
+	assert.Equal(t, "Alloc", name)
+	assert.Equal(t, model.Labels{"host": "web1", "device": "agent"}, labels)
+
+	name, labels = s.series(`host_disk_reads{device="sda"}`)
+	assert.Equal(t, "host_disk_reads", name)
+	assert.Equal(t, model.Labels{"host": "web1", "device": "sda"}, labels, "series labels win")
+}
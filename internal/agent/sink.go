@@ -0,0 +1,277 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// Sink is a destination batches prepared by the sender are sent to.
+type Sink interface {
+	// Name identifies sink in config and logs.
+	Name() string
+	// Send sends batch once, without retries. Batch that would be refused
+	// on every attempt must be reported with error wrapping errBatchRejected,
+	// so it's neither retried nor spooled.
+	Send(batch []model.Metrics, idempotencyKey string) error
+	// Close releases resources held by the sink.
+	Close() error
+}
+
+// newSink creates sink by its address, see config.Config.Targets.
+func (s *sender) newSink(address string, cfg *config.Config) (Sink, error) {
+	switch {
+	case strings.HasPrefix(address, config.GRPCTargetPrefix):
+		return &grpcSink{
+			s:       s,
+			address: strings.TrimPrefix(address, config.GRPCTargetPrefix),
+			stream:  cfg.GRPCStream,
+		}, nil
+	case address == config.StdoutTarget:
+		return newStdoutSink(os.Stdout), nil
+	case strings.HasPrefix(address, config.FileTargetPrefix):
+		return newFileSink(strings.TrimPrefix(address, config.FileTargetPrefix), cfg.FileSinkMaxSize, cfg.FileSinkMaxFiles)
+	case strings.HasPrefix(address, "http://"), strings.HasPrefix(address, "https://"):
+		return &httpSink{s: s, url: address}, nil
+	}
+
+	return nil, fmt.Errorf("target %q: http://, https://, %s, %s or %s scheme is expected",
+		address, config.GRPCTargetPrefix, config.StdoutTarget, config.FileTargetPrefix)
+}
+
+// httpSink sends batches to the server HTTP API.
+type httpSink struct {
+	s   *sender
+	url string
+}
+
+// Name implements Sink.
+func (t *httpSink) Name() string {
+	return t.url
+}
+
+// Send implements Sink.
+func (t *httpSink) Send(batch []model.Metrics, idempotencyKey string) error {
+	return t.s.sendBatched(t.url, batch, idempotencyKey)
+}
+
+// Close implements Sink.
+func (t *httpSink) Close() error {
+	return nil
+}
+
+// sinkRecord is a line of NDJSON written by stdout and file sinks: a single
+// metric along with the time it was sent.
+type sinkRecord struct {
+	Time time.Time `json:"time"`
+	model.Metrics
+}
+
+// encodeNDJSON encodes batch as NDJSON, one metric per line.
+func encodeNDJSON(batch []model.Metrics, ts time.Time) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(buf)
+
+	for _, m := range batch {
+		if err := enc.Encode(sinkRecord{Time: ts, Metrics: m}); err != nil {
+			return nil, fmt.Errorf("failed to encode metric %q: %w", m.ID, err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// stdoutSink writes batches to stdout as NDJSON, useful for dry runs and
+// local debugging.
+type stdoutSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newStdoutSink(w io.Writer) *stdoutSink {
+	return &stdoutSink{w: w}
+}
+
+// Name implements Sink.
+func (t *stdoutSink) Name() string {
+	return config.StdoutTarget
+}
+
+// Send implements Sink.
+func (t *stdoutSink) Send(batch []model.Metrics, _ string) error {
+	data, err := encodeNDJSON(batch, time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBatchRejected, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, err = t.w.Write(data)
+	return err
+}
+
+// Close implements Sink.
+func (t *stdoutSink) Close() error {
+	return nil
+}
+
+// File sink limits used when not configured.
+const (
+	DefaultFileSinkMaxSize  = 64 << 20 // bytes
+	DefaultFileSinkMaxFiles = 5
+)
+
+// fileSink appends batches to file as NDJSON. File is rotated when it
+// exceeds maxSize: it's renamed to "<path>.1", older rotated files are
+// shifted ("<path>.1" becomes "<path>.2" and so on), at most maxFiles rotated
+// files are kept.
+type fileSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// newFileSink opens file sink at path, parent dirs are created when missing.
+// Zero limits are replaced with defaults.
+func newFileSink(path string, maxSize int64, maxFiles int) (*fileSink, error) {
+	if path == "" {
+		return nil, errors.New("file sink path can't be empty")
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultFileSinkMaxSize
+	}
+
+	if maxFiles <= 0 {
+		maxFiles = DefaultFileSinkMaxFiles
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create file sink dir: %w", err)
+	}
+
+	t := &fileSink{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Name implements Sink.
+func (t *fileSink) Name() string {
+	return config.FileTargetPrefix + t.path
+}
+
+// Send implements Sink.
+func (t *fileSink) Send(batch []model.Metrics, _ string) error {
+	data, err := encodeNDJSON(batch, time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBatchRejected, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		// previous rotation failed to reopen the file
+		if err = t.open(); err != nil {
+			return err
+		}
+	}
+
+	if t.size > 0 && t.size+int64(len(data)) > t.maxSize {
+		if err = t.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate file sink: %w", err)
+		}
+	}
+
+	n, err := t.file.Write(data)
+	t.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write to file sink: %w", err)
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (t *fileSink) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		return nil
+	}
+
+	err := t.file.Close()
+	t.file = nil
+
+	return err
+}
+
+func (t *fileSink) open() error {
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open file sink: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to open file sink: %w", err)
+	}
+
+	t.file, t.size = f, info.Size()
+
+	return nil
+}
+
+// rotate renames current file and opens a new one.
+func (t *fileSink) rotate() error {
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+	t.file = nil
+
+	if err := os.Remove(t.rotated(t.maxFiles)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for i := t.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(t.rotated(i), t.rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := os.Rename(t.path, t.rotated(1)); err != nil {
+		return err
+	}
+
+	return t.open()
+}
+
+func (t *fileSink) rotated(n int) string {
+	return t.path + "." + strconv.Itoa(n)
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readNDJSON decodes NDJSON written by stdout and file sinks.
+func readNDJSON(t *testing.T, data []byte) []sinkRecord {
+	t.Helper()
+
+	var records []sinkRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var r sinkRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+
+	return records
+}
+
+func TestStdoutSink(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	sink := newStdoutSink(buf)
+
+	value := 1.5
+	batch := append(testBatch("PollCount", 3), model.Metrics{
+		ID:     "Alloc",
+		MType:  model.MetricTypeGauge,
+		Value:  &value,
+		Labels: model.Labels{"host": "web1"},
+	})
+	require.NoError(t, sink.Send(batch, "a-1"))
+
+	records := readNDJSON(t, buf.Bytes())
+	require.Len(t, records, 2)
+	assert.Equal(t, batch[0], records[0].Metrics)
+	assert.Equal(t, batch[1], records[1].Metrics)
+	assert.False(t, records[0].Time.IsZero())
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive", "metrics.ndjson")
+
+	sink, err := newFileSink(path, 0, 2)
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testBatch("PollCount", 1), "a-1"))
+
+	// rotate on every batch
+	sink.maxSize = sink.size
+	for _, delta := range []int64{2, 3, 4} {
+		require.NoError(t, sink.Send(testBatch("PollCount", delta), ""))
+	}
+	require.NoError(t, sink.Close())
+
+	for file, delta := range map[string]int64{path: 4, path + ".1": 3, path + ".2": 2} {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+
+		records := readNDJSON(t, data)
+		require.Len(t, records, 1, file)
+		assert.Equal(t, delta, *records[0].Delta, file)
+	}
+	assert.NoFileExists(t, path+".3", "at most maxFiles rotated files are kept")
+
+	// appends to existing file after restart
+	sink, err = newFileSink(path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testBatch("PollCount", 5), ""))
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, readNDJSON(t, data), 2)
+}
+
+func TestSender_newSink(t *testing.T) {
+	s := &sender{}
+	cfg := config.New()
+	dir := t.TempDir()
+
+	tests := []struct {
+		address string
+		want    Sink
+	}{
+		{address: "http://primary:8080", want: &httpSink{}},
+		{address: "https://primary", want: &httpSink{}},
+		{address: "grpc://primary:3200", want: &grpcSink{}},
+		{address: "stdout://", want: &stdoutSink{}},
+		{address: "file://" + filepath.Join(dir, "metrics.ndjson"), want: &fileSink{}},
+		{address: "primary:8080"},
+		{address: "file://"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			sink, err := s.newSink(tt.address, cfg)
+			if tt.want == nil {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			defer sink.Close()
+
+			assert.IsType(t, tt.want, sink)
+			assert.Equal(t, tt.address, sink.Name())
+		})
+	}
+}
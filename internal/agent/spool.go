@@ -0,0 +1,257 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// Spool limits used when not configured.
+const (
+	DefaultSpoolMaxSize = 64 << 20 // bytes
+	// DefaultSpoolMaxAge matches the server idempotency keys TTL, so older
+	// batches might be applied twice.
+	DefaultSpoolMaxAge = 24 * time.Hour
+)
+
+const spoolFileExt = ".batch"
+
+// spool is an on-disk FIFO queue of batches failed to be sent. Every batch is
+// stored in its own file named by enqueue time, so the queue survives agent
+// restarts. Batches keep their idempotency keys, so batch is never applied
+// twice even if it was received by the server before the failure.
+//
+// Queue is bounded: the oldest batches are dropped when total size exceeds
+// maxSize, batches older than maxAge are dropped as well.
+type spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu    sync.Mutex
+	files []spoolFile // oldest first
+	size  int64
+	last  int64 // name of the last enqueued file
+}
+
+type spoolFile struct {
+	name    int64 // enqueue time in unix nanoseconds
+	size    int64
+	created time.Time
+}
+
+// spoolEntry is a content of spool file.
+type spoolEntry struct {
+	IdempotencyKey string          `json:"idempotency_key"`
+	Metrics        []model.Metrics `json:"metrics"`
+}
+
+// newSpool opens spool in dir, dir is created when missing. Zero limits are
+// replaced with defaults.
+func newSpool(dir string, maxSize int64, maxAge time.Duration) (*spool, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultSpoolMaxSize
+	}
+
+	if maxAge <= 0 {
+		maxAge = DefaultSpoolMaxAge
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+
+	q := &spool{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool dir: %w", err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		// leftovers of interrupted writes
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+
+		name, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), spoolFileExt), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), spoolFileExt) {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool dir: %w", err)
+		}
+
+		q.files = append(q.files, spoolFile{
+			name:    name,
+			size:    info.Size(),
+			created: time.Unix(0, name),
+		})
+		q.size += info.Size()
+	}
+
+	sort.Slice(q.files, func(i, j int) bool {
+		return q.files[i].name < q.files[j].name
+	})
+
+	if len(q.files) > 0 {
+		q.last = q.files[len(q.files)-1].name
+		log.Printf("Spool opened with %d batches pending\n", len(q.files))
+	}
+
+	return q, nil
+}
+
+// Len returns number of spooled batches.
+func (q *spool) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.files)
+}
+
+// Push appends batch to the queue, the oldest batches are dropped when the
+// queue is full.
+func (q *spool) Push(batch []model.Metrics, idempotencyKey string) error {
+	data, err := json.Marshal(spoolEntry{
+		IdempotencyKey: idempotencyKey,
+		Metrics:        batch,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode spooled batch: %w", err)
+	}
+
+	size := int64(len(data))
+	if size > q.maxSize {
+		return fmt.Errorf("batch size %d exceeds spool size %d", size, q.maxSize)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expire()
+
+	var dropped int
+	for len(q.files) > 0 && q.size+size > q.maxSize {
+		q.remove()
+		dropped++
+	}
+	if dropped > 0 {
+		log.Printf("Spool is full, %d oldest batches dropped\n", dropped)
+	}
+
+	// names must grow even if clock goes backwards
+	name := time.Now().UnixNano()
+	if name <= q.last {
+		name = q.last + 1
+	}
+
+	path := q.path(name)
+	if err = os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return fmt.Errorf("failed to write spooled batch: %w", err)
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write spooled batch: %w", err)
+	}
+
+	q.files = append(q.files, spoolFile{
+		name:    name,
+		size:    size,
+		created: time.Unix(0, name),
+	})
+	q.size += size
+	q.last = name
+
+	return nil
+}
+
+// Replay sends spooled batches in order, every sent batch is removed from the
+// queue. Replay stops on the first failure and returns its error. Batches
+// rejected by the server (see errBatchRejected) and unreadable ones are
+// dropped.
+func (q *spool) Replay(send func(batch []model.Metrics, idempotencyKey string) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expire()
+
+	for len(q.files) > 0 {
+		f := q.files[0]
+
+		data, err := os.ReadFile(q.path(f.name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read spooled batch: %w", err)
+		}
+
+		var entry spoolEntry
+		if err == nil {
+			err = json.Unmarshal(data, &entry)
+		}
+		if err != nil {
+			log.Printf("Spooled batch %d is unreadable, dropped: %v\n", f.name, err)
+			q.remove()
+			continue
+		}
+
+		if err = send(entry.Metrics, entry.IdempotencyKey); err != nil {
+			if !errors.Is(err, errBatchRejected) {
+				return err
+			}
+			log.Printf("Spooled batch %d was rejected, dropped: %v\n", f.name, err)
+		}
+
+		q.remove()
+	}
+
+	return nil
+}
+
+// expire drops batches older than maxAge.
+func (q *spool) expire() {
+	var dropped int
+	for len(q.files) > 0 && time.Since(q.files[0].created) > q.maxAge {
+		q.remove()
+		dropped++
+	}
+
+	if dropped > 0 {
+		log.Printf("Spool: %d expired batches dropped\n", dropped)
+	}
+}
+
+// remove removes the oldest batch.
+func (q *spool) remove() {
+	f := q.files[0]
+
+	if err := os.Remove(q.path(f.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to remove spooled batch: %v\n", err)
+	}
+
+	q.files = q.files[1:]
+	q.size -= f.size
+}
+
+func (q *spool) path(name int64) string {
+	return filepath.Join(q.dir, strconv.FormatInt(name, 10)+spoolFileExt)
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBatch(name string, delta int64) []model.Metrics {
+	return []model.Metrics{{ID: name, MType: model.MetricTypeCounter, Delta: &delta}}
+}
+
+// recorder records idempotency keys of sent batches, send fails with err.
+type recorder struct {
+	keys []string
+	err  error
+}
+
+func (r *recorder) send(_ []model.Metrics, key string) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	r.keys = append(r.keys, key)
+	return nil
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := newSpool(dir, 0, 0)
+	require.NoError(t, err)
+
+	for _, key := range []string{"a-1", "a-2", "a-3"} {
+		require.NoError(t, q.Push(testBatch("PollCount", 1), key))
+	}
+
+	// failure stops replay, nothing is removed
+	r := &recorder{err: errors.New("unreachable")}
+	require.Error(t, q.Replay(r.send))
+	assert.Equal(t, 3, q.Len())
+
+	// queue survives restart
+	q, err = newSpool(dir, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, q.Len())
+
+	r = &recorder{}
+	require.NoError(t, q.Replay(r.send))
+	assert.Equal(t, []string{"a-1", "a-2", "a-3"}, r.keys)
+	assert.Equal(t, 0, q.Len())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSpool_limits(t *testing.T) {
+	t.Run("size", func(t *testing.T) {
+		q, err := newSpool(t.TempDir(), 0, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, q.Push(testBatch("PollCount", 1), "a-1"))
+		q.maxSize = q.size * 2
+
+		require.NoError(t, q.Push(testBatch("PollCount", 2), "a-2"))
+		require.NoError(t, q.Push(testBatch("PollCount", 3), "a-3"))
+		assert.Equal(t, 2, q.Len(), "the oldest batch is dropped")
+
+		require.Error(t, q.Push(make([]model.Metrics, 100), "big"))
+
+		r := &recorder{}
+		require.NoError(t, q.Replay(r.send))
+		assert.Equal(t, []string{"a-2", "a-3"}, r.keys)
+	})
+
+	t.Run("age", func(t *testing.T) {
+		q, err := newSpool(t.TempDir(), 0, time.Hour)
+		require.NoError(t, err)
+
+		require.NoError(t, q.Push(testBatch("PollCount", 1), "a-1"))
+		require.NoError(t, q.Push(testBatch("PollCount", 2), "a-2"))
+		q.files[0].created = time.Now().Add(-2 * time.Hour)
+
+		r := &recorder{}
+		require.NoError(t, q.Replay(r.send))
+		assert.Equal(t, []string{"a-2"}, r.keys)
+	})
+}
+
+func TestSpool_Replay_dropped(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := newSpool(dir, 0, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, q.Push(testBatch("PollCount", 1), "a-1"))
+	require.NoError(t, q.Push(testBatch("PollCount", 2), "a-2"))
+	require.NoError(t, q.Push(testBatch("PollCount", 3), "a-3"))
+
+	// corrupt the first batch
+	require.NoError(t, os.WriteFile(q.path(q.files[0].name), []byte("{"), 0o600))
+
+	var keys []string
+	err = q.Replay(func(_ []model.Metrics, key string) error {
+		if key == "a-2" {
+			return errBatchRejected
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a-3"}, keys)
+	assert.Equal(t, 0, q.Len())
+
+	// unfinished writes are cleaned up on open
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1.batch.tmp"), nil, 0o600))
+	_, err = newSpool(dir, 0, 0)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "1.batch.tmp"))
+}
+
+func TestSender_deliverSpooled(t *testing.T) {
+	q, err := newSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	s := &sender{agentID: "a"}
+
+	var sent []model.Counter
+	failing := true
+	send := func(batch []model.Metrics, _ string) error {
+		if failing {
+			return errors.New("unreachable")
+		}
+		for _, m := range batch {
+			sent = append(sent, model.Counter(*m.Delta))
+		}
+		return nil
+	}
+
+	st := &stream{send: send, deltas: newDeltaTracker(), spool: q}
+
+	// server is down: batches are spooled, increments are acknowledged
+	for _, pollCount := range []model.Counter{3, 5} {
+		require.NoError(t, s.deliver(st, Metrics{Counters: map[string]model.Counter{"PollCount": pollCount}}))
+	}
+	assert.Equal(t, 2, q.Len())
+
+	// server is back: spooled batches go first
+	failing = false
+	require.NoError(t, s.deliver(st, Metrics{Counters: map[string]model.Counter{"PollCount": 10}}))
+	assert.Equal(t, []model.Counter{3, 2, 5}, sent)
+	assert.Equal(t, 0, q.Len())
+
+	// rejected batch isn't spooled
+	st.send = func([]model.Metrics, string) error {
+		return errBatchRejected
+	}
+	err = s.deliver(st, Metrics{Counters: map[string]model.Counter{"PollCount": 11}})
+	require.ErrorIs(t, err, errBatchRejected)
+	assert.Equal(t, 0, q.Len())
+}
@@ -0,0 +1,359 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// collectorStatsd is a name of the StatsD collector.
+const collectorStatsd = "statsd"
+
+// DefaultStatsdPercentiles are percentiles StatsD timers are reduced to by
+// default.
+var DefaultStatsdPercentiles = []float64{0.5, 0.9, 0.99}
+
+// statsdMaxPacketSize is a maximum size of UDP packet read.
+const statsdMaxPacketSize = 65535
+
+// statsdCollector receives StatsD metrics over UDP, lines are in
+// "<name>:<value>|<type>[|@<sample rate>][|#<tag>:<value>,...]" form (tags
+// are in DogStatsD format and become labels). Packet may contain several
+// lines separated by newlines.
+//
+// Metrics are aggregated between collections, collector is run once per
+// report interval by default:
+//   - c (counter) - values divided by sample rate are summed;
+//   - g (gauge) - the last value is kept, value with explicit sign ("+3",
+//     "-3") modifies current value;
+//   - ms, h (timer, histogram) - values are reduced to percentiles reported
+//     as "<name>_p<percentile>" gauges, e.g. "latency_p99", and
+//     "<name>_count" gauge holds number of values adjusted by sample rate;
+//   - s (set) - number of unique values is reported as a gauge.
+//
+// Timers and sets are reported for the last collection window only, gauges
+// and counters are kept. Number of lines failed to be parsed is reported as
+// "statsd_bad_lines" counter.
+type statsdCollector struct {
+	address     string
+	percentiles []float64
+
+	conn net.PacketConn
+	done chan struct{}
+
+	mu       sync.Mutex
+	counters map[string]float64 // cumulative sums
+	gauges   map[string]float64
+	timers   map[string]*statsdTimer
+	sets     map[string]*statsdSet
+	badLines model.Counter
+}
+
+type statsdTimer struct {
+	name   string
+	labels model.Labels
+	values []float64
+	count  float64
+}
+
+type statsdSet struct {
+	name   string
+	labels model.Labels
+	values map[string]struct{}
+}
+
+// statsdSample is a parsed StatsD line.
+type statsdSample struct {
+	name     string
+	labels   model.Labels
+	key      string // series key of name and labels
+	mtype    string
+	value    float64
+	raw      string // raw value, set member
+	relative bool   // gauge value has explicit sign
+	rate     float64
+}
+
+func newStatsdCollector(address string, percentiles []float64) *statsdCollector {
+	if len(percentiles) == 0 {
+		percentiles = DefaultStatsdPercentiles
+	}
+
+	return &statsdCollector{
+		address:     address,
+		percentiles: percentiles,
+		done:        make(chan struct{}),
+		counters:    make(map[string]float64),
+		gauges:      make(map[string]float64),
+		timers:      make(map[string]*statsdTimer),
+		sets:        make(map[string]*statsdSet),
+	}
+}
+
+// Name implements Collector.
+func (c *statsdCollector) Name() string {
+	return collectorStatsd
+}
+
+// Collect implements Collector.
+func (c *statsdCollector) Collect(_ context.Context) (Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := Metrics{
+		Gauges:   make(map[string]model.Gauge, len(c.gauges)),
+		Counters: make(map[string]model.Counter, len(c.counters)+1),
+	}
+
+	for key, v := range c.counters {
+		m.Counters[key] = model.Counter(math.Round(v))
+	}
+	m.Counters["statsd_bad_lines"] = c.badLines
+
+	for key, v := range c.gauges {
+		m.Gauges[key] = model.Gauge(v)
+	}
+
+	for _, t := range c.timers {
+		sort.Float64s(t.values)
+		for _, p := range c.percentiles {
+			name := t.name + "_p" + percentileSuffix(p)
+			m.Gauges[name+t.labels.String()] = model.Gauge(percentile(t.values, p))
+		}
+		m.Gauges[t.name+"_count"+t.labels.String()] = model.Gauge(t.count)
+	}
+	c.timers = make(map[string]*statsdTimer)
+
+	for key, s := range c.sets {
+		m.Gauges[key] = model.Gauge(len(s.values))
+	}
+	c.sets = make(map[string]*statsdSet)
+
+	return m, nil
+}
+
+// listen implements listener.
+func (c *statsdCollector) listen() (err error) {
+	c.conn, err = net.ListenPacket("udp", c.address)
+	if err != nil {
+		return fmt.Errorf("statsd listener failed to listen: %w", err)
+	}
+
+	return nil
+}
+
+// serve implements listener.
+func (c *statsdCollector) serve() {
+	defer close(c.done)
+
+	log.Printf("StatsD listener started on %s\n", c.conn.LocalAddr())
+
+	buf := make([]byte, statsdMaxPacketSize)
+	for {
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("StatsD listener failed: %v\n", err)
+			}
+			return
+		}
+
+		c.handlePacket(string(buf[:n]))
+	}
+}
+
+// shutdown implements listener.
+func (c *statsdCollector) shutdown(ctx context.Context) error {
+	if err := c.conn.Close(); err != nil {
+		return fmt.Errorf("statsd listener shutdown failed: %w", err)
+	}
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return fmt.Errorf("statsd listener shutdown failed: %w", ctx.Err())
+	}
+
+	log.Println("StatsD listener stopped")
+
+	return nil
+}
+
+// handlePacket parses packet lines and aggregates them.
+func (c *statsdCollector) handlePacket(packet string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, line := range strings.Split(packet, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		s, err := parseStatsdLine(line)
+		if err != nil {
+			c.badLines++
+			continue
+		}
+
+		c.add(s)
+	}
+}
+
+func (c *statsdCollector) add(s statsdSample) {
+	switch s.mtype {
+	case "c":
+		c.counters[s.key] += s.value / s.rate
+	case "g":
+		if s.relative {
+			c.gauges[s.key] += s.value
+		} else {
+			c.gauges[s.key] = s.value
+		}
+	case "ms", "h":
+		t, ok := c.timers[s.key]
+		if !ok {
+			t = &statsdTimer{name: s.name, labels: s.labels}
+			c.timers[s.key] = t
+		}
+		t.values = append(t.values, s.value)
+		t.count += 1 / s.rate
+	case "s":
+		set, ok := c.sets[s.key]
+		if !ok {
+			set = &statsdSet{name: s.name, labels: s.labels, values: make(map[string]struct{})}
+			c.sets[s.key] = set
+		}
+		set.values[s.raw] = struct{}{}
+	}
+}
+
+// parseStatsdLine parses a single StatsD line.
+func parseStatsdLine(line string) (s statsdSample, err error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return s, errors.New("statsd: missing value")
+	}
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return s, errors.New("statsd: missing type")
+	}
+
+	s.name = strings.TrimSpace(name)
+	if s.name == "" {
+		return s, errors.New("statsd: empty metric name")
+	}
+
+	s.raw = fields[0]
+	s.mtype = fields[1]
+	s.rate = 1
+
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			s.rate, err = strconv.ParseFloat(f[1:], 64)
+			if err != nil || !(s.rate > 0 && s.rate <= 1) {
+				return s, fmt.Errorf("statsd: bad sample rate %q", f)
+			}
+		case strings.HasPrefix(f, "#"):
+			s.labels = parseStatsdTags(f[1:])
+		}
+	}
+
+	switch s.mtype {
+	case "c", "g", "ms", "h":
+		s.value, err = strconv.ParseFloat(s.raw, 64)
+		if err != nil || math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			return s, fmt.Errorf("statsd: bad value %q", s.raw)
+		}
+	case "s":
+	default:
+		return s, fmt.Errorf("statsd: unsupported type %q", s.mtype)
+	}
+
+	switch s.mtype {
+	case "c":
+		if s.value < 0 {
+			return s, errors.New("statsd: counter value must not be negative")
+		}
+	case "g":
+		s.relative = strings.HasPrefix(s.raw, "+") || strings.HasPrefix(s.raw, "-")
+	}
+
+	s.key, err = model.SeriesKey(s.name, s.labels)
+	if err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// parseStatsdTags parses "tag:value,tag2:value2" tags into labels. Tag names
+// are sanitized to be valid label names, tags without values are skipped.
+func parseStatsdTags(tags string) model.Labels {
+	labels := make(model.Labels)
+
+	for _, tag := range strings.Split(tags, ",") {
+		name, value, ok := strings.Cut(tag, ":")
+		if !ok || name == "" {
+			continue
+		}
+
+		name = strings.Map(func(r rune) rune {
+			switch {
+			case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+				return r
+			}
+			return '_'
+		}, name)
+		if name[0] >= '0' && name[0] <= '9' {
+			name = "_" + name
+		}
+
+		labels[name] = value
+	}
+
+	return labels
+}
+
+// percentile returns p-percentile of sorted values using nearest-rank
+// method.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// percentileSuffix formats percentile as a metric name suffix, e.g. 0.5 is
+// formatted as "50", 0.99 as "99" and 0.999 as "999".
+func percentileSuffix(p float64) string {
+	switch {
+	case p <= 0:
+		return "0"
+	case p >= 1:
+		return "100"
+	}
+
+	digits := strings.TrimPrefix(strconv.FormatFloat(p, 'f', -1, 64), "0.")
+	if len(digits) < 2 {
+		digits += "0"
+	}
+
+	return digits
+}
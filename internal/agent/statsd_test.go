@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsdLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    statsdSample
+		wantErr bool
+	}{
+		{
+			line: "api.requests:3|c",
+			want: statsdSample{name: "api.requests", key: "api.requests", mtype: "c", value: 3, raw: "3", rate: 1},
+		},
+		{
+			line: "api.requests:1|c|@0.1|#env:prod,bad-tag:x,novalue",
+			want: statsdSample{
+				name:   "api.requests",
+				labels: model.Labels{"env": "prod", "bad_tag": "x"},
+				key:    `api.requests{bad_tag="x",env="prod"}`,
+				mtype:  "c",
+				value:  1,
+				raw:    "1",
+				rate:   0.1,
+			},
+		},
+		{
+			line: "queue:-2|g",
+			want: statsdSample{name: "queue", key: "queue", mtype: "g", value: -2, raw: "-2", relative: true, rate: 1},
+		},
+		{
+			line: "users:alice|s",
+			want: statsdSample{name: "users", key: "users", mtype: "s", raw: "alice", rate: 1},
+		},
+		{line: "no_value", wantErr: true},
+		{line: "no_type:1", wantErr: true},
+		{line: ":1|c", wantErr: true},
+		{line: "bad_value:x|ms", wantErr: true},
+		{line: "bad_type:1|x", wantErr: true},
+		{line: "bad_rate:1|c|@0", wantErr: true},
+		{line: "negative:-1|c", wantErr: true},
+		{line: "bad{name}:1|g", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseStatsdLine(tt.line)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStatsdCollector_Collect(t *testing.T) {
+	c := newStatsdCollector("", []float64{0.5, 0.99})
+
+	c.handlePacket("requests:1|c\nrequests:1|c|@0.5\nbroken\n")
+	c.handlePacket("queue:10|g\nqueue:-3|g\ntemp:1|g\ntemp:5|g")
+	c.handlePacket("users:alice|s\nusers:bob|s\nusers:alice|s")
+	for i := 1; i <= 100; i++ {
+		c.handlePacket("latency:" + strconv.Itoa(i) + "|ms|#env:prod")
+	}
+
+	m, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]model.Counter{
+		"requests":         3,
+		"statsd_bad_lines": 1,
+	}, m.Counters)
+	assert.Equal(t, map[string]model.Gauge{
+		"queue":                     7,
+		"temp":                      5,
+		"users":                     2,
+		`latency_p50{env="prod"}`:   50,
+		`latency_p99{env="prod"}`:   99,
+		`latency_count{env="prod"}`: 100,
+	}, m.Gauges)
+
+	// timers and sets are reported per collection window
+	c.handlePacket("requests:2|c")
+
+	m, err = c.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]model.Counter{
+		"requests":         5,
+		"statsd_bad_lines": 1,
+	}, m.Counters)
+	assert.Equal(t, map[string]model.Gauge{"queue": 7, "temp": 5}, m.Gauges)
+}
+
+func TestStatsdCollector_serve(t *testing.T) {
+	c := newStatsdCollector("127.0.0.1:0", nil)
+	require.NoError(t, c.listen())
+	go c.serve()
+
+	conn, err := net.Dial("udp", c.conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("requests:4|c"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		m, _ := c.Collect(context.Background())
+		return m.Counters["requests"] == 4
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, c.shutdown(context.Background()))
+}
+
+func TestPercentileSuffix(t *testing.T) {
+	for p, want := range map[float64]string{0: "0", 0.5: "50", 0.9: "90", 0.99: "99", 0.999: "999", 1: "100"} {
+		assert.Equal(t, want, percentileSuffix(p))
+	}
+}
+
+func TestDefaultListeners(t *testing.T) {
+	cfg := config.New()
+	assert.Empty(t, defaultListeners(cfg))
+
+	cfg.PushAddress = "localhost:0"
+	cfg.StatsdAddress = "localhost:0"
+
+	listeners := defaultListeners(cfg)
+	require.Len(t, listeners, 2)
+	assert.Equal(t, collectorPush, listeners[0].Name())
+	assert.Equal(t, collectorStatsd, listeners[1].Name())
+	assert.Equal(t, time.Duration(cfg.ReportInterval)*time.Second, cfg.CollectorInterval(collectorStatsd),
+		"statsd aggregates per report interval")
+
+	cfg.DisableCollectors(collectorStatsd)
+	assert.Len(t, defaultListeners(cfg), 1)
+}
@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/retry"
+)
+
+// stream is an independent flow of batches: it has its own counter increments
+// acknowledgement and its own spool, so every stream receives all the data
+// regardless of the others.
+type stream struct {
+	name string
+	// send sends batch with retries
+	send func(batch []model.Metrics, idempotencyKey string) error
+
+	// deltas turns cumulative counters into increments to be sent
+	deltas *deltaTracker
+	// spool keeps batches failed to be sent, nil when disabled
+	spool *spool
+
+	// sinks are closed when sender is shut down
+	sinks []Sink
+}
+
+// newStreams creates streams of sinks configured by cfg (see
+// config.Config.Targets): a single stream sending to the first healthy sink
+// for TargetPolicyFailover and a stream per sink for TargetPolicyFanout.
+// Fan-out streams are spooled into subdirectories of the spool dir named by
+// sink.
+func (s *sender) newStreams(cfg *config.Config) ([]*stream, error) {
+	addresses := cfg.TargetAddresses()
+
+	sinks := make([]Sink, 0, len(addresses))
+	for _, address := range addresses {
+		sink, err := s.newSink(address, cfg)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	maxAge := time.Second * time.Duration(cfg.SpoolMaxAge)
+
+	switch cfg.TargetPolicy {
+	case "", config.TargetPolicyFailover:
+		st := &stream{
+			name:   strings.Join(addresses, ","),
+			send:   withRetries(newFailover(sinks).Send),
+			deltas: newDeltaTracker(),
+			sinks:  sinks,
+		}
+
+		if cfg.SpoolDir != "" {
+			var err error
+			if st.spool, err = newSpool(cfg.SpoolDir, cfg.SpoolMaxSize, maxAge); err != nil {
+				closeSinks(sinks)
+				return nil, err
+			}
+		}
+
+		return []*stream{st}, nil
+	case config.TargetPolicyFanout:
+		streams := make([]*stream, 0, len(sinks))
+
+		for _, sink := range sinks {
+			st := &stream{
+				name:   sink.Name(),
+				send:   withRetries(sink.Send),
+				deltas: newDeltaTracker(),
+				sinks:  []Sink{sink},
+			}
+
+			if cfg.SpoolDir != "" {
+				var err error
+				dir := filepath.Join(cfg.SpoolDir, spoolDirName(sink.Name()))
+				if st.spool, err = newSpool(dir, cfg.SpoolMaxSize, maxAge); err != nil {
+					closeSinks(sinks)
+					return nil, err
+				}
+			}
+
+			streams = append(streams, st)
+		}
+
+		return streams, nil
+	default:
+		closeSinks(sinks)
+		return nil, fmt.Errorf("unknown target policy %q", cfg.TargetPolicy)
+	}
+}
+
+// closeSinks closes sinks, errors are joined.
+func closeSinks(sinks []Sink) (err error) {
+	for _, sink := range sinks {
+		if closeErr := sink.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("sink %s: %w", sink.Name(), closeErr))
+		}
+	}
+
+	return err
+}
+
+// withRetries wraps send with retries. Every attempt is sent with the same
+// key, so batch applied by the server is never applied again even if the
+// response has been lost.
+func withRetries(send func(batch []model.Metrics, idempotencyKey string) error) func(batch []model.Metrics, idempotencyKey string) error {
+	return func(batch []model.Metrics, idempotencyKey string) error {
+		retry := retry.NewRetrier(time.Second, 3)
+		return retry.Do("send batched metrics", func() error {
+			return send(batch, idempotencyKey)
+		})
+	}
+}
+
+// spoolDirName turns target address into a directory name, e.g.
+// "http://primary:8080" becomes "http_primary_8080".
+func spoolDirName(address string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == '.', r == '-', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, address)
+
+	return strings.ReplaceAll(name, "___", "_")
+}
+
+// Failover backoff bounds: sink is skipped for failoverBackoff after a
+// failure, the duration doubles with every consecutive failure.
+const (
+	failoverBackoff    = 5 * time.Second
+	failoverMaxBackoff = 5 * time.Minute
+)
+
+// failover sends batch to the first healthy sink, sinks are tried in order.
+// Failed sink is considered unhealthy for a backoff period and is skipped,
+// unless all sinks are unhealthy. Sink becomes healthy again on the first
+// successful send, so batches return to the primary sink once it's back.
+type failover struct {
+	mu    sync.Mutex
+	sinks []*failoverSink
+	now   func() time.Time
+}
+
+type failoverSink struct {
+	Sink
+	failures  int
+	downUntil time.Time
+}
+
+func newFailover(sinks []Sink) *failover {
+	f := &failover{now: time.Now}
+
+	for _, sink := range sinks {
+		f.sinks = append(f.sinks, &failoverSink{Sink: sink})
+	}
+
+	return f
+}
+
+// Send sends batch to the first healthy sink. Batch rejected by a sink isn't
+// sent to the others, since it would be rejected as well.
+func (f *failover) Send(batch []model.Metrics, idempotencyKey string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+
+	candidates := make([]*failoverSink, 0, len(f.sinks))
+	for _, t := range f.sinks {
+		if !now.Before(t.downUntil) {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = f.sinks
+	}
+
+	var errs []error
+	for i, t := range candidates {
+		err := t.Send(batch, idempotencyKey)
+		if err == nil {
+			if t.failures > 0 {
+				log.Printf("Target %s is healthy again\n", t.Name())
+			}
+			t.failures, t.downUntil = 0, time.Time{}
+			return nil
+		}
+
+		if errors.Is(err, errBatchRejected) {
+			return err
+		}
+
+		t.failures++
+		t.downUntil = now.Add(failoverBackoffFor(t.failures))
+		errs = append(errs, fmt.Errorf("target %s: %w", t.Name(), err))
+
+		if i+1 < len(candidates) {
+			log.Printf("Target %s failed, failing over to %s: %v\n", t.Name(), candidates[i+1].Name(), err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func failoverBackoffFor(failures int) time.Duration {
+	backoff := failoverBackoff
+	for i := 1; i < failures && backoff < failoverMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, failoverMaxBackoff)
+}
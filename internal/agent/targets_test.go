@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/agent/config"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/server"
+	configServer "github.com/Dmitrevicz/gometrics/internal/server/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSink counts sent batches, send fails with err.
+type fakeSink struct {
+	name string
+	sent int
+	err  error
+}
+
+func (t *fakeSink) Name() string {
+	return t.name
+}
+
+func (t *fakeSink) Send(_ []model.Metrics, _ string) error {
+	if t.err != nil {
+		return t.err
+	}
+
+	t.sent++
+	return nil
+}
+
+func (t *fakeSink) Close() error {
+	return nil
+}
+
+func TestFailover(t *testing.T) {
+	primary := &fakeSink{name: "primary"}
+	standby := &fakeSink{name: "standby"}
+
+	now := time.Now()
+	f := newFailover([]Sink{primary, standby})
+	f.now = func() time.Time { return now }
+
+	require.NoError(t, f.Send(nil, ""))
+	assert.Equal(t, 1, primary.sent)
+
+	// primary is down, standby is used
+	primary.err = errors.New("unreachable")
+	require.NoError(t, f.Send(nil, ""))
+	assert.Equal(t, 1, standby.sent)
+
+	// primary is skipped during backoff, even though it's back
+	primary.err = nil
+	require.NoError(t, f.Send(nil, ""))
+	assert.Equal(t, 1, primary.sent)
+	assert.Equal(t, 2, standby.sent)
+
+	// batches return to primary after backoff
+	now = now.Add(failoverBackoff)
+	require.NoError(t, f.Send(nil, ""))
+	assert.Equal(t, 2, primary.sent)
+
+	// rejected batch isn't failed over
+	primary.err = errBatchRejected
+	require.ErrorIs(t, f.Send(nil, ""), errBatchRejected)
+	assert.Equal(t, 2, standby.sent)
+
+	// all targets are tried when all of them are down
+	primary.err = errors.New("unreachable")
+	standby.err = errors.New("unreachable")
+	require.Error(t, f.Send(nil, ""))
+	standby.err = nil
+	require.NoError(t, f.Send(nil, ""))
+	assert.Equal(t, 3, standby.sent)
+}
+
+func TestFailoverBackoffFor(t *testing.T) {
+	assert.Equal(t, failoverBackoff, failoverBackoffFor(1))
+	assert.Equal(t, 2*failoverBackoff, failoverBackoffFor(2))
+	assert.Equal(t, failoverMaxBackoff, failoverBackoffFor(100))
+}
+
+func TestSender_newStreams(t *testing.T) {
+	s := &sender{}
+
+	cfg := config.New()
+	streams, err := s.newStreams(cfg)
+	require.NoError(t, err)
+	require.Len(t, streams, 1)
+	assert.Equal(t, cfg.ServerURL, streams[0].name)
+	assert.Nil(t, streams[0].spool)
+
+	cfg.Targets = []string{"http://primary:8080", "grpc://standby:3200"}
+	cfg.TargetPolicy = config.TargetPolicyFanout
+	cfg.SpoolDir = t.TempDir()
+
+	streams, err = s.newStreams(cfg)
+	require.NoError(t, err)
+	require.Len(t, streams, 2)
+	assert.Equal(t, "grpc://standby:3200", streams[1].name)
+	assert.DirExists(t, filepath.Join(cfg.SpoolDir, "http_primary_8080"))
+	assert.DirExists(t, filepath.Join(cfg.SpoolDir, "grpc_standby_3200"))
+
+	cfg.TargetPolicy = "unknown"
+	_, err = s.newStreams(cfg)
+	require.Error(t, err)
+
+	cfg.TargetPolicy = config.TargetPolicyFailover
+	cfg.Targets = []string{"primary:8080"}
+	_, err = s.newStreams(cfg)
+	require.Error(t, err, "scheme is required")
+}
+
+func TestSender_SendBatchedTargets(t *testing.T) {
+	primary := httptest.NewServer(server.New(configServer.NewTesting()))
+	defer primary.Close()
+	standby := httptest.NewServer(server.New(configServer.NewTesting()))
+	defer standby.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	t.Run("fanout", func(t *testing.T) {
+		cfg := &config.Config{
+			AgentID:      "fanout",
+			Targets:      []string{primary.URL, standby.URL},
+			TargetPolicy: config.TargetPolicyFanout,
+		}
+
+		s, err := NewSender(cfg, NewRegistry())
+		require.NoError(t, err)
+
+		for _, pollCount := range []model.Counter{3, 5, 10} {
+			s.SendBatched(Metrics{Counters: map[string]model.Counter{"FanoutCount": pollCount}})
+		}
+
+		for _, ts := range []*httptest.Server{primary, standby} {
+			assert.Equal(t, "10", getValue(t, ts.URL+"/value/counter/FanoutCount"))
+		}
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		cfg := &config.Config{
+			AgentID: "failover",
+			Targets: []string{down.URL, standby.URL},
+		}
+
+		s, err := NewSender(cfg, NewRegistry())
+		require.NoError(t, err)
+
+		s.SendBatched(Metrics{Gauges: map[string]model.Gauge{"FailoverGauge": 1}})
+		assert.Equal(t, "1", getValue(t, standby.URL+"/value/gauge/FailoverGauge"))
+	})
+}
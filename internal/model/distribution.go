@@ -0,0 +1,160 @@
+package model
+
+import (
+	"fmt"
+	"math"
+)
+
+// Histogram is a distribution of observed values counted in buckets.
+//
+// Buckets are cumulative (same as in Prometheus): each bucket counts
+// observations less than or equal to its upper bound. Implicit +Inf bucket
+// is represented by Count.
+//
+// Histogram values sent to the server are treated as deltas (same as
+// counters): observations are added to the stored histogram.
+type Histogram struct {
+	Buckets []Bucket `json:"buckets"`
+	Sum     float64  `json:"sum"`
+	Count   uint64   `json:"count"`
+}
+
+// Bucket is a single histogram bucket.
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// Summary is a distribution of observed values represented by quantiles.
+//
+// Quantiles can't be merged, so summary sent to the server replaces the
+// stored one (same as gauges).
+type Summary struct {
+	Quantiles []Quantile `json:"quantiles"`
+	Sum       float64    `json:"sum"`
+	Count     uint64     `json:"count"`
+}
+
+// Quantile is a single summary quantile, e.g. 0.99 quantile value.
+type Quantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// MetricHistogram represents metric of specific Histogram type.
+type MetricHistogram struct {
+	Name  string // series key (see SeriesKey)
+	Value Histogram
+}
+
+// MetricSummary represents metric of specific Summary type.
+type MetricSummary struct {
+	Name  string // series key (see SeriesKey)
+	Value Summary
+}
+
+// Validate checks that bucket bounds are strictly increasing and finite and
+// that cumulative bucket counts don't decrease and don't exceed Count.
+func (h Histogram) Validate() error {
+	var prev Bucket
+	for i, b := range h.Buckets {
+		if math.IsNaN(b.UpperBound) || math.IsInf(b.UpperBound, 0) {
+			return fmt.Errorf("%w: bucket bound must be finite", ErrWrongHistogram)
+		}
+
+		if i > 0 && b.UpperBound <= prev.UpperBound {
+			return fmt.Errorf("%w: bucket bounds must be strictly increasing", ErrWrongHistogram)
+		}
+
+		if b.Count < prev.Count || b.Count > h.Count {
+			return fmt.Errorf("%w: bucket counts must be cumulative", ErrWrongHistogram)
+		}
+
+		prev = b
+	}
+
+	if math.IsNaN(h.Sum) {
+		return fmt.Errorf("%w: sum must be a number", ErrWrongHistogram)
+	}
+
+	return nil
+}
+
+// Merge adds observations of h2 to h. When bucket bounds of h and h2
+// differ, h is replaced by h2 (buckets must have been reconfigured).
+func (h Histogram) Merge(h2 Histogram) Histogram {
+	if !h.sameBounds(h2) {
+		return h2.Copy()
+	}
+
+	res := h.Copy()
+	for i := range res.Buckets {
+		res.Buckets[i].Count += h2.Buckets[i].Count
+	}
+	res.Sum += h2.Sum
+	res.Count += h2.Count
+
+	return res
+}
+
+// Sub returns observations added to h2 to get h. False is returned when h
+// isn't a later state of h2: bucket bounds differ or counts went down (e.g.
+// histogram has been reset).
+func (h Histogram) Sub(h2 Histogram) (Histogram, bool) {
+	if !h.sameBounds(h2) || h.Count < h2.Count {
+		return Histogram{}, false
+	}
+
+	res := h.Copy()
+	for i := range res.Buckets {
+		if res.Buckets[i].Count < h2.Buckets[i].Count {
+			return Histogram{}, false
+		}
+		res.Buckets[i].Count -= h2.Buckets[i].Count
+	}
+	res.Sum -= h2.Sum
+	res.Count -= h2.Count
+
+	return res, true
+}
+
+// Copy returns deep copy of h.
+func (h Histogram) Copy() Histogram {
+	h.Buckets = append([]Bucket(nil), h.Buckets...)
+	return h
+}
+
+func (h Histogram) sameBounds(h2 Histogram) bool {
+	if len(h.Buckets) != len(h2.Buckets) {
+		return false
+	}
+
+	for i := range h.Buckets {
+		if h.Buckets[i].UpperBound != h2.Buckets[i].UpperBound {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Validate checks that quantiles are within [0, 1] range.
+func (s Summary) Validate() error {
+	for _, q := range s.Quantiles {
+		if !(q.Quantile >= 0 && q.Quantile <= 1) {
+			return fmt.Errorf("%w: quantile must be within [0, 1]", ErrWrongSummary)
+		}
+	}
+
+	if math.IsNaN(s.Sum) {
+		return fmt.Errorf("%w: sum must be a number", ErrWrongSummary)
+	}
+
+	return nil
+}
+
+// Copy returns deep copy of s.
+func (s Summary) Copy() Summary {
+	s.Quantiles = append([]Quantile(nil), s.Quantiles...)
+	return s
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       Histogram
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			h: Histogram{
+				Buckets: []Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}},
+				Count:   3,
+			},
+		},
+		{
+			name: "bounds not increasing",
+			h: Histogram{
+				Buckets: []Bucket{{UpperBound: 1, Count: 1}, {UpperBound: 1, Count: 2}},
+				Count:   3,
+			},
+			wantErr: true,
+		},
+		{
+			name: "counts not cumulative",
+			h: Histogram{
+				Buckets: []Bucket{{UpperBound: 0.1, Count: 2}, {UpperBound: 1, Count: 1}},
+				Count:   3,
+			},
+			wantErr: true,
+		},
+		{
+			name: "bucket exceeds count",
+			h: Histogram{
+				Buckets: []Bucket{{UpperBound: 0.1, Count: 4}},
+				Count:   3,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.h.Validate()
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrWrongHistogram)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := Histogram{
+		Buckets: []Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}},
+		Sum:     1,
+		Count:   3,
+	}
+
+	t.Run("same bounds", func(t *testing.T) {
+		got := h.Merge(h)
+		assert.Equal(t, Histogram{
+			Buckets: []Bucket{{UpperBound: 0.1, Count: 2}, {UpperBound: 1, Count: 4}},
+			Sum:     2,
+			Count:   6,
+		}, got)
+
+		// source must stay untouched
+		assert.EqualValues(t, 1, h.Buckets[0].Count)
+	})
+
+	t.Run("different bounds", func(t *testing.T) {
+		h2 := Histogram{
+			Buckets: []Bucket{{UpperBound: 5, Count: 1}},
+			Sum:     4,
+			Count:   1,
+		}
+		assert.Equal(t, h2, h.Merge(h2))
+	})
+}
+
+func TestHistogram_Sub(t *testing.T) {
+	h := Histogram{
+		Buckets: []Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}},
+		Sum:     1,
+		Count:   3,
+	}
+	later := Histogram{
+		Buckets: []Bucket{{UpperBound: 0.1, Count: 2}, {UpperBound: 1, Count: 4}},
+		Sum:     2.5,
+		Count:   5,
+	}
+
+	got, ok := later.Sub(h)
+	require.True(t, ok)
+	assert.Equal(t, Histogram{
+		Buckets: []Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}},
+		Sum:     1.5,
+		Count:   2,
+	}, got)
+	assert.Equal(t, later, h.Merge(got))
+
+	// reset
+	_, ok = h.Sub(later)
+	assert.False(t, ok)
+
+	// reconfigured buckets
+	_, ok = h.Sub(Histogram{Buckets: []Bucket{{UpperBound: 5}}})
+	assert.False(t, ok)
+}
+
+func TestSummary_Validate(t *testing.T) {
+	require.NoError(t, Summary{Quantiles: []Quantile{{Quantile: 0.99, Value: 1}}}.Validate())
+	require.ErrorIs(t, Summary{Quantiles: []Quantile{{Quantile: 99, Value: 1}}}.Validate(), ErrWrongSummary)
+}
@@ -1,6 +1,9 @@
 package model
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // RetriableError is a custom error type that should be retried.
 type RetriableError error
@@ -9,3 +12,19 @@ type RetriableError error
 func NewRetriableError(err error) error {
 	return fmt.Errorf("%w", err)
 }
+
+// metric identity errors
+var (
+	ErrWrongMetricName = errors.New("wrong metric name")
+	ErrWrongLabelName  = errors.New("wrong label name")
+	ErrWrongSeriesKey  = errors.New("wrong series key")
+)
+
+// metric value errors
+var (
+	ErrWrongHistogram = errors.New("wrong histogram")
+	ErrWrongSummary   = errors.New("wrong summary")
+)
+
+// ErrWrongIdempotencyKey is returned when batch idempotency key is invalid.
+var ErrWrongIdempotencyKey = errors.New("wrong idempotency key")
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels are key/value pairs (e.g. host, service, env) that, together with
+// the metric name, identify a series.
+type Labels map[string]string
+
+// Validate checks that every label name is valid. Label names must match
+// [a-zA-Z_][a-zA-Z0-9_]* regexp.
+func (l Labels) Validate() error {
+	for name := range l {
+		if !isValidLabelName(name) {
+			return fmt.Errorf("%w: \"%s\"", ErrWrongLabelName, name)
+		}
+	}
+
+	return nil
+}
+
+// String returns labels in canonical form, e.g. `{env="prod",host="web1"}`.
+// Labels are sorted by name. Empty string is returned when there are no labels.
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(l[name]))
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+// Match reports whether l contains every label of filter with the same value.
+// Empty filter matches any labels.
+func (l Labels) Match(filter Labels) bool {
+	for name, value := range filter {
+		if v, ok := l[name]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SeriesKey builds a key that identifies the series in storage: metric name
+// followed by labels in canonical form, e.g. `Alloc{host="web1"}`. Key of a
+// metric without labels is its name.
+func SeriesKey(name string, labels Labels) (string, error) {
+	if strings.ContainsAny(name, "{}") {
+		return "", fmt.Errorf("%w: \"%s\"", ErrWrongMetricName, name)
+	}
+
+	if err := labels.Validate(); err != nil {
+		return "", err
+	}
+
+	return name + labels.String(), nil
+}
+
+// ParseSeriesKey splits series key, built by SeriesKey, into metric name and
+// labels.
+func ParseSeriesKey(key string) (name string, labels Labels, err error) {
+	i := strings.IndexByte(key, '{')
+	if i < 0 {
+		return key, nil, nil
+	}
+
+	name, rest := key[:i], key[i+1:]
+	if !strings.HasSuffix(rest, "}") {
+		return "", nil, fmt.Errorf("%w: \"%s\"", ErrWrongSeriesKey, key)
+	}
+	rest = rest[:len(rest)-1]
+
+	labels = make(Labels)
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return "", nil, fmt.Errorf("%w: \"%s\"", ErrWrongSeriesKey, key)
+		}
+		label := rest[:eq]
+
+		value, err := strconv.QuotedPrefix(rest[eq+1:])
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: \"%s\"", ErrWrongSeriesKey, key)
+		}
+		rest = rest[eq+1+len(value):]
+
+		if labels[label], err = strconv.Unquote(value); err != nil {
+			return "", nil, fmt.Errorf("%w: \"%s\"", ErrWrongSeriesKey, key)
+		}
+
+		rest = strings.TrimPrefix(rest, ",")
+	}
+
+	return name, labels, nil
+}
+
+func isValidLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
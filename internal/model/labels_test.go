@@ -0,0 +1,73 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		metric  string
+		labels  Labels
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "no labels",
+			metric: "Alloc",
+			want:   "Alloc",
+		},
+		{
+			name:   "sorted labels",
+			metric: "Alloc",
+			labels: Labels{"host": "web1", "env": "prod"},
+			want:   `Alloc{env="prod",host="web1"}`,
+		},
+		{
+			name:   "escaped value",
+			metric: "Alloc",
+			labels: Labels{"service": `a"b,c=d`},
+			want:   `Alloc{service="a\"b,c=d"}`,
+		},
+		{
+			name:    "bad label name",
+			metric:  "Alloc",
+			labels:  Labels{"1host": "web1"},
+			wantErr: ErrWrongLabelName,
+		},
+		{
+			name:    "bad metric name",
+			metric:  "Alloc{}",
+			wantErr: ErrWrongMetricName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SeriesKey(tt.metric, tt.labels)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// parsed key must give the same metric back
+			name, labels, err := ParseSeriesKey(got)
+			require.NoError(t, err)
+			assert.Equal(t, tt.metric, name)
+			assert.Equal(t, len(tt.labels), len(labels))
+			assert.True(t, labels.Match(tt.labels))
+		})
+	}
+}
+
+func TestParseSeriesKey_Broken(t *testing.T) {
+	for _, key := range []string{`Alloc{host="web1"`, `Alloc{host}`, `Alloc{host=web1}`} {
+		_, _, err := ParseSeriesKey(key)
+		require.ErrorIs(t, err, ErrWrongSeriesKey, key)
+	}
+}
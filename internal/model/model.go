@@ -3,12 +3,18 @@
 // Also holds custom errors definitions.
 package model
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
 
 // metric type name
 const (
-	MetricTypeGauge   = "gauge"
-	MetricTypeCounter = "counter"
+	MetricTypeGauge     = "gauge"
+	MetricTypeCounter   = "counter"
+	MetricTypeHistogram = "histogram"
+	MetricTypeSummary   = "summary"
 )
 
 // metric value
@@ -33,20 +39,67 @@ func (g Counter) FromString(s string) (Counter, error) {
 
 // Metrics - struct from the lesson.
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string     `json:"id"`                  // имя метрики
+	MType     string     `json:"type"`                // параметр, принимающий значение gauge, counter, histogram или summary
+	Delta     *int64     `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64   `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *Summary   `json:"summary,omitempty"`   // значение метрики в случае передачи summary
+	Labels    Labels     `json:"labels,omitempty"`    // метки (host, service, env...), часть идентификатора серии
 }
 
 // MetricGauge represents metric of specific Gauge type.
 type MetricGauge struct {
-	Name  string
+	Name  string // series key (see SeriesKey)
 	Value Gauge
 }
 
 // MetricCounter represents metric of specific Counter type.
 type MetricCounter struct {
-	Name  string
+	Name  string // series key (see SeriesKey)
 	Value Counter
 }
+
+// Batch is a set of metrics, grouped by type, to be updated at once.
+type Batch struct {
+	Gauges     []MetricGauge
+	Counters   []MetricCounter
+	Histograms []MetricHistogram
+	Summaries  []MetricSummary
+
+	// IdempotencyKey identifies the batch, e.g. agent ID plus batch sequence
+	// number. When set, batch is applied only once: retries of already
+	// applied batch are ignored by the storage.
+	IdempotencyKey string
+}
+
+// MaxIdempotencyKeyLength is a max length of Batch.IdempotencyKey.
+const MaxIdempotencyKeyLength = 200
+
+// ValidateIdempotencyKey checks that key fits into MaxIdempotencyKeyLength.
+// Empty key is valid, it means that batch has no key.
+func ValidateIdempotencyKey(key string) error {
+	if len(key) > MaxIdempotencyKeyLength {
+		return fmt.Errorf("%w: max length is %d", ErrWrongIdempotencyKey, MaxIdempotencyKeyLength)
+	}
+
+	return nil
+}
+
+// Len returns number of metrics of all types in the batch.
+func (b Batch) Len() int {
+	return len(b.Gauges) + len(b.Counters) + len(b.Histograms) + len(b.Summaries)
+}
+
+// GaugeRecord is a gauge value recorded at some point in time.
+type GaugeRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     Gauge     `json:"value"`
+}
+
+// CounterRecord is a counter value recorded at some point in time. Value holds
+// the accumulated counter total (not the delta that was applied).
+type CounterRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     Counter   `json:"value"`
+}
@@ -20,7 +20,8 @@ type Config struct {
 	// address for the server to listen on
 	ServerAddress string `json:"address"`
 
-	// address for gRPC server to listen on
+	// address for gRPC server to listen on, gRPC server is run alongside
+	// HTTP server (not instead of it) over the same storage
 	ServerAddressGRPC string `json:"address_grpc"`
 
 	// logger level
@@ -41,10 +42,35 @@ type Config struct {
 	// файла при старте сервера (по умолчанию true).
 	Restore bool `json:"restore"`
 
+	// WALPath is a path to write-ahead log file of in-memory storage. When
+	// set, every update is appended to the log instead of rewriting the whole
+	// FileStoragePath file, which is only rewritten (compacted) every
+	// StoreInterval seconds. Flag: -wal, env: WAL_FILE.
+	WALPath string `json:"wal_file"`
+
+	// WALSync makes every write-ahead log append to be synced to disk.
+	// Flag: -wal-sync, env: WAL_SYNC.
+	WALSync bool `json:"wal_sync"`
+
 	// Строка с адресом подключения к БД должна получаться из переменной
 	// окружения DATABASE_DSN или флага командной строки -d
 	DatabaseDSN string `json:"database_dsn"`
 
+	// DatabaseVerifyOnly disables schema migrations at startup: database
+	// schema version is only checked and server fails to start when schema is
+	// outdated. Flag: -db-verify-only, env: DATABASE_VERIFY_ONLY.
+	DatabaseVerifyOnly bool `json:"database_verify_only"`
+
+	// DatabaseRollback is a number of the last applied schema migrations to
+	// roll back: when positive, server rolls them back and exits instead of
+	// serving. Flag: -db-rollback, env: DATABASE_ROLLBACK.
+	DatabaseRollback int `json:"database_rollback"`
+
+	// DatabaseQueryTimeout is a timeout in milliseconds of a single database
+	// operation (query or transaction), 0 disables the timeout.
+	// Flag: -db-timeout, env: DATABASE_TIMEOUT.
+	DatabaseQueryTimeout int `json:"database_timeout"`
+
 	// Добавьте поддержку аргумента через флаг -k=<КЛЮЧ> и переменную
 	// окружения KEY=<КЛЮЧ>.
 	//  - При наличии ключа во время обработки запроса сервер должен проверять
@@ -64,6 +90,10 @@ type Config struct {
 	// to access the server. When not empty, Server will check X-Real-IP header
 	// in Agent requests. Flag: -t, env: TRUSTED_SUBNET.
 	TrustedSubnet Subnet `json:"trusted_subnet"`
+
+	// HistorySize is a max number of values kept in history for every
+	// metric. Flag: -history-size, env: HISTORY_SIZE.
+	HistorySize int `json:"history_size"`
 }
 
 // New creates config with default values set.
@@ -74,6 +104,9 @@ func New() *Config {
 		FileStoragePath: "/tmp/metrics-db.json",
 		StoreInterval:   300,
 		Restore:         true,
+		HistorySize:     1000,
+
+		DatabaseQueryTimeout: 5000,
 	}
 }
 
@@ -14,6 +14,7 @@ import (
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/server/config"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
+	"github.com/Dmitrevicz/gometrics/internal/storage/memstorage"
 	"go.uber.org/zap"
 )
 
@@ -34,8 +35,16 @@ type Dumper struct {
 	Dump dumpFunc
 
 	// maybe add smth to be able to stop the ticker on Quit call
+
+	// wal is a write-ahead log of in-memory storage. When it's used, dump
+	// file acts as a snapshot, which is periodically compacted from the log.
+	wal *memstorage.WAL
 }
 
+// DefaultWALCompactInterval is used as a compaction interval of the
+// write-ahead log when store interval is not positive.
+const DefaultWALCompactInterval = 300 * time.Second
+
 // NewDumper creates new Dumper.
 func NewDumper(storage storage.Storage, cfg *config.Config) *Dumper {
 	d := Dumper{
@@ -44,24 +53,46 @@ func NewDumper(storage storage.Storage, cfg *config.Config) *Dumper {
 		cfg:     cfg,
 	}
 
+	// every update is logged when WAL is used, so there is no need to rewrite
+	// the whole file on every request
 	d.Dump = d.noOpDump
-	if cfg.StoreInterval == 0 && cfg.FileStoragePath != "" {
+	if cfg.StoreInterval == 0 && cfg.FileStoragePath != "" && !d.walEnabled() {
 		d.Dump = d.dump
 	}
 
 	return &d
 }
 
+// walEnabled reports whether write-ahead log should be used. WAL requires
+// in-memory storage and dump file to be used as snapshot.
+func (d *Dumper) walEnabled() bool {
+	if d.cfg.WALPath == "" || d.cfg.FileStoragePath == "" {
+		return false
+	}
+
+	_, ok := d.storage.(*memstorage.Storage)
+	return ok
+}
+
 // Start runs timer on specified interval.
 // Can be stopped by call to Quit().
-func (d *Dumper) Start() error {
+func (d *Dumper) Start(ctx context.Context) error {
 	logger.Log.Info("Starting Dumper")
 
 	// restore attempt
-	if err := d.restore(); err != nil {
+	lsn, err := d.restore(ctx)
+	if err != nil {
 		return fmt.Errorf("unsuccessful restore attempt: %w", err)
 	}
 
+	if d.walEnabled() {
+		if err = d.openWAL(lsn); err != nil {
+			return fmt.Errorf("failed to open WAL: %w", err)
+		}
+	} else if d.cfg.WALPath != "" {
+		logger.Log.Warn("WAL is disabled - it requires in-memory storage and dump file path")
+	}
+
 	// go d.waitForQuit()
 	go d.startTimer()
 
@@ -88,7 +119,7 @@ func (d *Dumper) Quit(ctx context.Context) (err error) {
 
 	wait := make(chan error, 1)
 	go func() {
-		wait <- d.dump()
+		wait <- d.dump(ctx)
 		close(wait)
 	}()
 
@@ -98,7 +129,41 @@ func (d *Dumper) Quit(ctx context.Context) (err error) {
 	case err = <-wait:
 	}
 
-	return fmt.Errorf("dumper got error trying to create a dump: %v", err)
+	if err != nil {
+		return fmt.Errorf("dumper got error trying to create a dump: %v", err)
+	}
+
+	if err = d.wal.Close(); err != nil {
+		return fmt.Errorf("dumper failed to close WAL: %v", err)
+	}
+
+	return nil
+}
+
+// openWAL opens write-ahead log and attaches it to the storage. lsn is the
+// last LSN restored from snapshot and log.
+func (d *Dumper) openWAL(lsn uint64) error {
+	if !d.cfg.Restore {
+		// log of the previous run must not be replayed later on top of a new
+		// snapshot, so it's discarded and numbering continues after the
+		// snapshot, which is still there
+		lsn = d.snapshotLSN()
+		if err := os.Remove(d.cfg.WALPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	wal, err := memstorage.OpenWAL(d.cfg.WALPath, lsn, d.cfg.WALSync)
+	if err != nil {
+		return err
+	}
+
+	d.storage.(*memstorage.Storage).SetWAL(wal)
+	d.wal = wal
+
+	logger.Log.Info("WAL opened", zap.String("path", d.cfg.WALPath), zap.Uint64("lsn", lsn))
+
+	return nil
 }
 
 // stopTimer stops infinite timer which calls Dumper.dump().
@@ -111,6 +176,10 @@ func (d *Dumper) stopTimer() {
 func (d *Dumper) startTimer() {
 	sleepDuration := time.Second * time.Duration(d.cfg.StoreInterval)
 
+	if d.wal != nil && sleepDuration <= 0 {
+		sleepDuration = DefaultWALCompactInterval
+	}
+
 	if sleepDuration <= 0 {
 		logger.Log.Error("dump timer wasn't started - got negative or 0 interval: " + sleepDuration.String())
 		return
@@ -129,7 +198,7 @@ func (d *Dumper) startTimer() {
 	for {
 		select {
 		case <-d.timer.C:
-			err = d.dump()
+			err = d.dump(context.Background())
 			if err != nil {
 				logger.Log.Error("dumper got error trying to create a dump", zap.Error(err))
 			}
@@ -150,20 +219,38 @@ func (d *Dumper) startTimer() {
 }
 
 type metricsDump struct {
-	Gauges   map[string]model.Gauge   `json:"gauges"`
-	Counters map[string]model.Counter `json:"counters"`
+	Gauges     map[string]model.Gauge     `json:"gauges"`
+	Counters   map[string]model.Counter   `json:"counters"`
+	Histograms map[string]model.Histogram `json:"histograms,omitempty"`
+	Summaries  map[string]model.Summary   `json:"summaries,omitempty"`
+
+	// WALPosition is the LSN of the last WAL record included in the dump
+	WALPosition uint64 `json:"wal_lsn,omitempty"`
 }
 
-type dumpFunc func() error
+type dumpFunc func(ctx context.Context) error
 
 // noOpDump does nothing.
 // It is used when saving is disabled by config.
-func (d *Dumper) noOpDump() error {
+func (d *Dumper) noOpDump(_ context.Context) error {
 	return nil
 }
 
-// dump saves current metrics data into file.
-func (d *Dumper) dump() error {
+// dump saves current metrics data into file. When WAL is used, the log is
+// compacted into the file.
+func (d *Dumper) dump(ctx context.Context) error {
+	if d.wal != nil {
+		return d.wal.Compact(func(lsn uint64) error {
+			return d.writeSnapshot(ctx, lsn)
+		})
+	}
+
+	return d.writeSnapshot(ctx, 0)
+}
+
+// writeSnapshot saves current metrics data into file. lsn is the last WAL
+// record included in the data.
+func (d *Dumper) writeSnapshot(ctx context.Context, lsn uint64) error {
 	ts := time.Now()
 	defer func() {
 		logger.Log.Info("dumper dump took - " + time.Since(ts).String())
@@ -172,20 +259,30 @@ func (d *Dumper) dump() error {
 	logger.Log.Info("dump triggered")
 
 	var (
-		metrics metricsDump
+		metrics = metricsDump{WALPosition: lsn}
 		err     error
 	)
 
-	metrics.Gauges, err = d.storage.Gauges().GetAll()
+	metrics.Gauges, err = d.storage.Gauges().GetAll(ctx)
 	if err != nil {
 		return fmt.Errorf("gauges retrieval error: %w", err)
 	}
 
-	metrics.Counters, err = d.storage.Counters().GetAll()
+	metrics.Counters, err = d.storage.Counters().GetAll(ctx)
 	if err != nil {
 		return fmt.Errorf("counters retrieval error: %w", err)
 	}
 
+	metrics.Histograms, err = d.storage.Histograms().GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("histograms retrieval error: %w", err)
+	}
+
+	metrics.Summaries, err = d.storage.Summaries().GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("summaries retrieval error: %w", err)
+	}
+
 	data, err := json.Marshal(metrics)
 	if err != nil {
 		return fmt.Errorf("failed json Marshal: %w", err)
@@ -193,14 +290,41 @@ func (d *Dumper) dump() error {
 
 	// dump() may be called from different goroutines (from many http requests concurrently)
 	d.mu.Lock()
-	err = os.WriteFile(d.cfg.FileStoragePath, data, 0666)
-	d.mu.Unlock()
+	defer d.mu.Unlock()
 
-	return err
+	// write to temporary file first, so the dump is replaced atomically and
+	// never gets half-written
+	tmp := d.cfg.FileStoragePath + ".tmp"
+	if err = writeFileSync(tmp, data); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, d.cfg.FileStoragePath)
+}
+
+// writeFileSync writes data to file and syncs it to disk.
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
 }
 
-// restore restores previously dumped metrics data.
-func (d *Dumper) restore() error {
+// restore restores previously dumped metrics data. When WAL is used, the log
+// is replayed on top of the dump. The last restored LSN is returned.
+func (d *Dumper) restore(ctx context.Context) (lsn uint64, err error) {
 	ts := time.Now()
 	defer func() {
 		logger.Log.Info("dumper restore took - " + time.Since(ts).String())
@@ -210,45 +334,86 @@ func (d *Dumper) restore() error {
 
 	if !d.cfg.Restore {
 		logger.Log.Info("dump restore is disabled by flag or env")
-		return nil
+		return 0, nil
 	}
 
 	if d.cfg.FileStoragePath == "" {
 		logger.Log.Info("dump restore is disabled - empty file path")
-		return nil
+		return 0, nil
 	}
 
 	// read metrics from file
-	data, err := os.ReadFile(d.cfg.FileStoragePath)
+	metrics, err := d.readSnapshot()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			logger.Log.Warn("dumper didn't find a file to restore from (skipping restore)", zap.Error(err))
-			return nil // what to do if file doesn't exist?
+		if !errors.Is(err, os.ErrNotExist) {
+			return 0, fmt.Errorf("failed reading dump: %w", err)
 		}
-		return fmt.Errorf("failed os.ReadFile: %w", err)
-	}
-
-	var metrics metricsDump
-	if err = json.Unmarshal(data, &metrics); err != nil {
-		return fmt.Errorf("failed json Unmarshal: %w", err)
+		logger.Log.Warn("dumper didn't find a file to restore from (skipping restore)", zap.Error(err))
+		// WAL still has to be replayed
 	}
 
 	// restore all metrics in storage
 	counter := 0
 	for name, value := range metrics.Counters {
-		if err = d.storage.Counters().Set(name, value); err != nil {
-			return fmt.Errorf("counters update error: %w", err)
+		if err = d.storage.Counters().Set(ctx, name, value); err != nil {
+			return 0, fmt.Errorf("counters update error: %w", err)
 		}
 		counter++
 	}
 	for name, value := range metrics.Gauges {
-		if err = d.storage.Gauges().Set(name, value); err != nil {
-			return fmt.Errorf("gauges update error: %w", err)
+		if err = d.storage.Gauges().Set(ctx, name, value); err != nil {
+			return 0, fmt.Errorf("gauges update error: %w", err)
+		}
+		counter++
+	}
+	for name, value := range metrics.Histograms {
+		if err = d.storage.Histograms().Set(ctx, name, value); err != nil {
+			return 0, fmt.Errorf("histograms update error: %w", err)
+		}
+		counter++
+	}
+	for name, value := range metrics.Summaries {
+		if err = d.storage.Summaries().Set(ctx, name, value); err != nil {
+			return 0, fmt.Errorf("summaries update error: %w", err)
 		}
 		counter++
 	}
 
 	logger.Log.Info("restored metrics count: " + strconv.Itoa(counter))
 
-	return nil
+	lsn = metrics.WALPosition
+	if d.walEnabled() {
+		lsn, err = memstorage.ReplayWAL(ctx, d.cfg.WALPath, d.storage.(*memstorage.Storage), lsn)
+		if err != nil {
+			return 0, fmt.Errorf("WAL replay failed: %w", err)
+		}
+		logger.Log.Info("WAL replayed", zap.Uint64("lsn", lsn))
+	}
+
+	return lsn, nil
+}
+
+// readSnapshot reads previously dumped metrics data from file.
+func (d *Dumper) readSnapshot() (metrics metricsDump, err error) {
+	data, err := os.ReadFile(d.cfg.FileStoragePath)
+	if err != nil {
+		return metrics, err
+	}
+
+	if err = json.Unmarshal(data, &metrics); err != nil {
+		return metrics, fmt.Errorf("failed json Unmarshal: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// snapshotLSN returns LSN of the last WAL record included in the dump file.
+// Zero is returned when the file can't be read.
+func (d *Dumper) snapshotLSN() uint64 {
+	metrics, err := d.readSnapshot()
+	if err != nil {
+		return 0
+	}
+
+	return metrics.WALPosition
 }
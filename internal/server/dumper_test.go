@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/server/config"
+	"github.com/Dmitrevicz/gometrics/internal/storage/memstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumper_WAL(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := config.NewTesting()
+	cfg.FileStoragePath = filepath.Join(dir, "metrics-db.json")
+	cfg.WALPath = filepath.Join(dir, "metrics.wal")
+	cfg.Restore = true
+
+	// first run: part of updates is compacted into snapshot, the rest stays
+	// in the log (as if the server has crashed)
+	s1 := memstorage.New()
+	d1 := NewDumper(s1, cfg)
+	require.NoError(t, d1.Start(context.Background()))
+
+	require.NoError(t, s1.Counters().Set(context.Background(), "PollCount", 2))
+	require.NoError(t, d1.dump(context.Background()))
+	require.NoError(t, s1.Counters().Set(context.Background(), "PollCount", 3))
+	require.NoError(t, s1.Gauges().Set(context.Background(), "Alloc", 4.2))
+
+	// second run restores snapshot and replays the log on top of it
+	s2 := memstorage.New()
+	d2 := NewDumper(s2, cfg)
+	require.NoError(t, d2.Start(context.Background()))
+	defer func() {
+		assert.NoError(t, d2.Quit(context.Background()))
+	}()
+
+	counter, err := s2.Counters().Get(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, model.Counter(5), counter, "snapshot records must not be replayed twice")
+
+	gauge, err := s2.Gauges().Get(context.Background(), "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, model.Gauge(4.2), gauge)
+}
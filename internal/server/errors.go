@@ -22,4 +22,7 @@ var (
 	ErrEmptyMetricName  = errors.New("empty metric name")
 	ErrWrongMetricValue = errors.New("wrong metric value")
 	ErrNegativeCounter  = errors.New("counter value must not be negative")
+	ErrWrongTimeRange   = errors.New("wrong time range")
+	ErrWrongHistoryStep = errors.New("wrong history step")
+	ErrWrongLabel       = errors.New("wrong label")
 )
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -66,7 +67,7 @@ func ExampleHandlers_GetMetricByJSON() {
 	server := New(config.NewTesting())
 
 	// populate expected data
-	if err := server.Storage.Counters().Set(want.ID, model.Counter(*want.Delta)); err != nil {
+	if err := server.Storage.Counters().Set(context.Background(), want.ID, model.Counter(*want.Delta)); err != nil {
 		fmt.Printf("Error populating storage before test: %v\n", err)
 		return
 	}
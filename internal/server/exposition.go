@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Dmitrevicz/gometrics/internal/logger"
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// Content types of the Prometheus exposition formats.
+const (
+	ContentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// AcceptsOpenMetrics reports whether OpenMetrics format is requested by
+// the Accept header value.
+func AcceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(mediaType) == "application/openmetrics-text" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SanitizeMetricName makes name valid for Prometheus: every character not
+// matching [a-zA-Z0-9_:] is replaced with underscore, and name starting with
+// a digit is prefixed with underscore.
+func SanitizeMetricName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(name) + 1)
+
+	for i, r := range name {
+		switch {
+		case r == '_', r == ':', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+
+	return sb.String()
+}
+
+// Exposition collects metrics and renders them in Prometheus text exposition
+// format (or in OpenMetrics format).
+//
+// Metrics are grouped into families by sanitized name, "_total" suffix of
+// counters is trimmed, since OpenMetrics counter family is named without it.
+// Different metrics may end up in the same family (e.g. "a.b" and "a_b" or
+// counters "x" and "x_total"), collisions are resolved when rendered, so
+// output doesn't depend on the order series are added in: series of a family
+// must be of the same type as the series with the least series key, series
+// of other types are skipped. Only the series with the least series key is
+// kept for the same labels.
+type Exposition struct {
+	families map[string][]series // family candidates by family name
+}
+
+type family struct {
+	name   string // sanitized name of the series with the least key
+	mType  string
+	series []series
+}
+
+type series struct {
+	key    string
+	name   string // sanitized metric name
+	mType  string
+	labels model.Labels
+	value  any
+}
+
+func NewExposition() *Exposition {
+	return &Exposition{
+		families: make(map[string][]series),
+	}
+}
+
+func (e *Exposition) AddGauges(gauges map[string]model.Gauge) {
+	for key, value := range gauges {
+		e.add(key, model.MetricTypeGauge, value)
+	}
+}
+
+func (e *Exposition) AddCounters(counters map[string]model.Counter) {
+	for key, value := range counters {
+		e.add(key, model.MetricTypeCounter, value)
+	}
+}
+
+func (e *Exposition) AddHistograms(histograms map[string]model.Histogram) {
+	for key, value := range histograms {
+		e.add(key, model.MetricTypeHistogram, value)
+	}
+}
+
+func (e *Exposition) AddSummaries(summaries map[string]model.Summary) {
+	for key, value := range summaries {
+		e.add(key, model.MetricTypeSummary, value)
+	}
+}
+
+// add adds series identified by series key to the family of the metric.
+func (e *Exposition) add(key, mType string, value any) {
+	name, labels, err := model.ParseSeriesKey(key)
+	if err != nil {
+		logger.Log.Warn("exposition: series skipped", zap.String("key", key), zap.Error(err))
+		return
+	}
+
+	name = SanitizeMetricName(name)
+
+	familyName := name
+	if mType == model.MetricTypeCounter {
+		familyName = strings.TrimSuffix(name, "_total")
+	}
+
+	e.families[familyName] = append(e.families[familyName], series{
+		key:    key,
+		name:   name,
+		mType:  mType,
+		labels: labels,
+		value:  value,
+	})
+}
+
+// resolve builds family of candidates resolving collisions (see Exposition).
+// Candidates with the same series key are taken in order they were added.
+func resolve(candidates []series) *family {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].key < candidates[j].key
+	})
+
+	first := candidates[0]
+	f := &family{name: first.name, mType: first.mType}
+
+	kept := make(map[string]series, len(candidates))
+	for _, s := range candidates {
+		if s.mType != f.mType {
+			logger.Log.Warn("exposition: series skipped, metric name is already used by another type",
+				zap.String("key", s.key),
+				zap.String("type", s.mType),
+				zap.String("family_type", f.mType),
+			)
+			continue
+		}
+
+		if k, ok := kept[s.labels.String()]; ok {
+			logger.Log.Warn("exposition: series skipped, sanitized metric name collides with another metric",
+				zap.String("key", s.key),
+				zap.String("kept_key", k.key),
+				zap.String("name", f.name),
+			)
+			continue
+		}
+
+		kept[s.labels.String()] = s
+		f.series = append(f.series, s)
+	}
+
+	return f
+}
+
+// Render renders collected metrics. Families are sorted by name and series
+// are sorted by labels, so output is stable.
+func (e *Exposition) Render(openMetrics bool) []byte {
+	names := make([]string, 0, len(e.families))
+	for name := range e.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		resolve(e.families[name]).render(&buf, openMetrics)
+	}
+
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+
+	return buf.Bytes()
+}
+
+func (f *family) render(buf *bytes.Buffer, openMetrics bool) {
+	sort.Slice(f.series, func(i, j int) bool {
+		return f.series[i].labels.String() < f.series[j].labels.String()
+	})
+
+	name := f.name
+	if openMetrics && f.mType == model.MetricTypeCounter {
+		// OpenMetrics counter family name must not have _total suffix,
+		// while its sample name must have one
+		name = strings.TrimSuffix(name, "_total")
+	}
+
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, f.mType)
+
+	for _, s := range f.series {
+		switch v := s.value.(type) {
+		case model.Gauge:
+			writeSample(buf, name, s.labels, "", "", formatFloat(float64(v)))
+		case model.Counter:
+			sample := name
+			if openMetrics {
+				sample += "_total"
+			}
+			writeSample(buf, sample, s.labels, "", "", strconv.FormatInt(int64(v), 10))
+		case model.Histogram:
+			for _, b := range v.Buckets {
+				writeSample(buf, name+"_bucket", s.labels, "le", formatFloat(b.UpperBound), strconv.FormatUint(b.Count, 10))
+			}
+			writeSample(buf, name+"_bucket", s.labels, "le", "+Inf", strconv.FormatUint(v.Count, 10))
+			writeSample(buf, name+"_sum", s.labels, "", "", formatFloat(v.Sum))
+			writeSample(buf, name+"_count", s.labels, "", "", strconv.FormatUint(v.Count, 10))
+		case model.Summary:
+			for _, q := range v.Quantiles {
+				writeSample(buf, name, s.labels, "quantile", formatFloat(q.Quantile), formatFloat(q.Value))
+			}
+			writeSample(buf, name+"_sum", s.labels, "", "", formatFloat(v.Sum))
+			writeSample(buf, name+"_count", s.labels, "", "", strconv.FormatUint(v.Count, 10))
+		}
+	}
+}
+
+// writeSample writes a single sample line. Extra label (e.g. "le" of the
+// histogram bucket) is added when extraName is not empty, it overrides
+// series label with the same name.
+func writeSample(buf *bytes.Buffer, name string, labels model.Labels, extraName, extraValue, value string) {
+	buf.WriteString(name)
+
+	names := make([]string, 0, len(labels))
+	for l := range labels {
+		if l != extraName {
+			names = append(names, l)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) > 0 || extraName != "" {
+		buf.WriteByte('{')
+		for i, l := range names {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeLabel(buf, l, labels[l])
+		}
+		if extraName != "" {
+			if len(names) > 0 {
+				buf.WriteByte(',')
+			}
+			writeLabel(buf, extraName, extraValue)
+		}
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	buf.WriteString(`="`)
+	labelValueReplacer.WriteString(buf, value)
+	buf.WriteByte('"')
+}
+
+// formatFloat formats float the way Prometheus expects it, e.g. "+Inf", "NaN".
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
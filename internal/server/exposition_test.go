@@ -0,0 +1,139 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := map[string]string{
+		"Alloc":          "Alloc",
+		"http.requests":  "http_requests",
+		"cpu-usage%":     "cpu_usage_",
+		"1minute":        "_1minute",
+		"ns:metric_name": "ns:metric_name",
+		"":               "_",
+	}
+
+	for name, want := range tests {
+		assert.Equal(t, want, SanitizeMetricName(name), name)
+	}
+}
+
+func TestAcceptsOpenMetrics(t *testing.T) {
+	assert.True(t, AcceptsOpenMetrics("application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5"))
+	assert.False(t, AcceptsOpenMetrics("text/plain;version=0.0.4"))
+	assert.False(t, AcceptsOpenMetrics(""))
+}
+
+func TestExposition_Render(t *testing.T) {
+	e := NewExposition()
+	e.AddGauges(map[string]model.Gauge{
+		"Alloc":                1.5,
+		`Alloc{host="web\"1"}`: 2,
+		"cpu.usage":            0.25,
+	})
+	e.AddCounters(map[string]model.Counter{
+		"PollCount": 7,
+	})
+	e.AddHistograms(map[string]model.Histogram{
+		"Latency": {
+			Buckets: []model.Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}},
+			Sum:     1.2,
+			Count:   3,
+		},
+	})
+	e.AddSummaries(map[string]model.Summary{
+		"Size": {
+			Quantiles: []model.Quantile{{Quantile: 0.5, Value: 10}},
+			Sum:       30,
+			Count:     3,
+		},
+	})
+
+	// name is already used by the counter, so gauge must be skipped
+	e.AddGauges(map[string]model.Gauge{"PollCount": 1})
+
+	t.Run("prometheus", func(t *testing.T) {
+		want := `# TYPE Alloc gauge
+Alloc 1.5
+Alloc{host="web\"1"} 2
+# TYPE Latency histogram
+Latency_bucket{le="0.1"} 1
+Latency_bucket{le="1"} 2
+Latency_bucket{le="+Inf"} 3
+Latency_sum 1.2
+Latency_count 3
+# TYPE PollCount counter
+PollCount 7
+# TYPE Size summary
+Size{quantile="0.5"} 10
+Size_sum 30
+Size_count 3
+# TYPE cpu_usage gauge
+cpu_usage 0.25
+`
+		assert.Equal(t, want, string(e.Render(false)))
+	})
+
+	t.Run("openmetrics", func(t *testing.T) {
+		out := string(e.Render(true))
+		assert.Contains(t, out, "# TYPE PollCount counter\nPollCount_total 7\n")
+		assert.Regexp(t, "# EOF\n$", out)
+	})
+}
+
+func TestExposition_nameCollision(t *testing.T) {
+	want := `# TYPE a_b gauge
+a_b 1
+a_b{host="x"} 3
+`
+
+	// map order is random, the same series must be kept every time
+	for i := 0; i < 10; i++ {
+		e := NewExposition()
+		e.AddGauges(map[string]model.Gauge{
+			"a.b":           1,
+			"a_b":           2,
+			`a_b{host="x"}`: 3,
+		})
+
+		assert.Equal(t, want, string(e.Render(false)))
+	}
+}
+
+func TestExposition_counterTotalCollision(t *testing.T) {
+	// map order is random, the same series must be kept every time
+	for i := 0; i < 10; i++ {
+		e := NewExposition()
+		e.AddGauges(map[string]model.Gauge{"y": 1})
+		e.AddCounters(map[string]model.Counter{
+			"x":                 5,
+			`x_total{host="a"}`: 3,
+			"y_total":           2,
+			"z":                 1,
+			"z_total":           4,
+		})
+
+		assert.Equal(t, `# TYPE x counter
+x_total 5
+x_total{host="a"} 3
+# TYPE y gauge
+y 1
+# TYPE z counter
+z_total 1
+# EOF
+`, string(e.Render(true)))
+
+		assert.Equal(t, `# TYPE x counter
+x 5
+x{host="a"} 3
+# TYPE y gauge
+y 1
+# TYPE z counter
+z 1
+`, string(e.Render(false)))
+	}
+}
@@ -2,17 +2,30 @@ package grpc
 
 import (
 	"context"
+	"crypto/hmac"
+	"errors"
 	"fmt"
+	"net"
 
+	"github.com/Dmitrevicz/gometrics/internal/logger"
+	"github.com/Dmitrevicz/gometrics/internal/server/config"
+	pb "github.com/Dmitrevicz/gometrics/internal/server/grpc/proto"
+	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // gzip compressed requests support
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
-func Interceptors(l *zap.Logger, opts ...logging.Option) []grpc.ServerOption {
+// Interceptors returns server options with interceptors chains: logging,
+// panic recovery and request security features configured by cfg (same as
+// HTTP server middlewares): trusted subnet check, decryption and hash check.
+func Interceptors(l *zap.Logger, cfg *config.Config, opts ...logging.Option) []grpc.ServerOption {
 	if len(opts) == 0 {
 		opts = []logging.Option{
 			// logging.WithLogOnEvents(logging.StartCall, logging.FinishCall),
@@ -27,20 +40,225 @@ func Interceptors(l *zap.Logger, opts ...logging.Option) []grpc.ServerOption {
 		}),
 	}
 
-	// TODO: add more interceptors, same as for http server:
-	// compression (gzip), encryption(?), hash-check(?), trusted subnet check.
+	unary := []grpc.UnaryServerInterceptor{
+		logging.UnaryServerInterceptor(InterceptorLogger(l), opts...),
+		recovery.UnaryServerInterceptor(recoveryOpts...),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		logging.StreamServerInterceptor(InterceptorLogger(l), opts...),
+		recovery.StreamServerInterceptor(recoveryOpts...),
+	}
+
+	if cfg.TrustedSubnet != "" {
+		subnet := cfg.TrustedSubnet.MustParse()
+		unary = append(unary, UnaryTrustedSubnetCheck(subnet))
+		stream = append(stream, StreamTrustedSubnetCheck(subnet))
+	}
+
+	// Request is hashed before encryption, so it must be decrypted first.
+	if cfg.CryptoKey != "" {
+		decryptor, err := encryptor.NewDecryptor(cfg.CryptoKey)
+		if err != nil {
+			logger.Log.Fatal("failed to initialize decryptor", zap.Error(err))
+		}
+		unary = append(unary, UnaryDecrypt(decryptor))
+		stream = append(stream, StreamDecrypt(decryptor))
+	}
+
+	if cfg.Key != "" {
+		unary = append(unary, UnaryHashCheck(cfg.Key))
+		stream = append(stream, StreamHashCheck(cfg.Key))
+	}
+
 	return []grpc.ServerOption{
-		grpc.ChainUnaryInterceptor(
-			logging.UnaryServerInterceptor(InterceptorLogger(l), opts...),
-			recovery.UnaryServerInterceptor(recoveryOpts...),
-			// Add any other interceptor you want.
-		),
-		grpc.ChainStreamInterceptor(
-			logging.StreamServerInterceptor(InterceptorLogger(l), opts...),
-			recovery.StreamServerInterceptor(recoveryOpts...),
-			// Add any other interceptor you want.
-		),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+}
+
+// UnaryTrustedSubnetCheck rejects requests from hosts out of subnet, host IP
+// is taken from pb.XRealIPMetadataKey metadata.
+func UnaryTrustedSubnetCheck(subnet *net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkSubnet(ctx, subnet); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamTrustedSubnetCheck is the same as UnaryTrustedSubnetCheck for
+// streams.
+func StreamTrustedSubnetCheck(subnet *net.IPNet) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkSubnet(ss.Context(), subnet); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+func checkSubnet(ctx context.Context, subnet *net.IPNet) error {
+	xRealIP := metadataValue(ctx, pb.XRealIPMetadataKey)
+
+	ip := net.ParseIP(xRealIP)
+	if ip == nil || !subnet.Contains(ip) {
+		logger.Log.Info("TrustedSubnet check didn't pass", zap.String("ip", xRealIP))
+		return status.Error(codes.PermissionDenied, "subnet is not allowed")
+	}
+
+	return nil
+}
+
+// UnaryDecrypt decrypts requests marked with pb.EncryptionMetadataKey
+// metadata, see pb.Unseal.
+func UnaryDecrypt(decryptor *encryptor.Decryptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := decrypt(ctx, req, decryptor); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamDecrypt is the same as UnaryDecrypt for streams, every received
+// message is decrypted.
+func StreamDecrypt(decryptor *encryptor.Decryptor) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			received: func(m any) error {
+				return decrypt(ss.Context(), m, decryptor)
+			},
+		})
+	}
+}
+
+func decrypt(ctx context.Context, req any, decryptor *encryptor.Decryptor) error {
+	if metadataValue(ctx, pb.EncryptionMetadataKey) != "1" {
+		return nil
+	}
+
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unexpected request type %T", req)
 	}
+
+	if err := pb.Unseal(msg, decryptor.Decrypt); err != nil {
+		logger.Log.Info("Content decryption failed", zap.Error(err))
+		return status.Error(codes.InvalidArgument, "content decryption failed")
+	}
+
+	return nil
+}
+
+// hashRequired lists methods updating metrics, their requests are rejected
+// when not hashed. Requests of the other methods are verified only when
+// hashed, same as HTTP API does.
+var hashRequired = map[string]bool{
+	pb.Metrics_Update_FullMethodName:       true,
+	pb.Metrics_UpdateBatch_FullMethodName:  true,
+	pb.Metrics_UpdateStream_FullMethodName: true,
+}
+
+// UnaryHashCheck verifies HMAC of the request passed in pb.HashMetadataKey
+// metadata. Update requests without hash are rejected.
+func UnaryHashCheck(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkHash(ctx, req, key, hashRequired[info.FullMethod]); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamHashCheck is the same as UnaryHashCheck for streams. Stream metadata
+// is sent once, so every received message must carry its own hash instead,
+// see pb.HashField.
+func StreamHashCheck(key string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		required := hashRequired[info.FullMethod]
+
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			received: func(m any) error {
+				msg, ok := m.(proto.Message)
+				if !ok {
+					return status.Errorf(codes.InvalidArgument, "unexpected message type %T", m)
+				}
+
+				err := pb.Verify(msg, key)
+				if err != nil && !required &&
+					(errors.Is(err, pb.ErrHashRequired) || errors.Is(err, pb.ErrHashNotSupported)) {
+					return nil
+				}
+				if err != nil {
+					logger.Log.Info("hash check failed", zap.Error(err))
+					return status.Error(codes.Unauthenticated, err.Error())
+				}
+
+				return nil
+			},
+		})
+	}
+}
+
+func checkHash(ctx context.Context, req any, key string, required bool) error {
+	provided := metadataValue(ctx, pb.HashMetadataKey)
+	if provided == "" {
+		if !required {
+			return nil
+		}
+		return status.Error(codes.Unauthenticated, "metadata required: "+pb.HashMetadataKey)
+	}
+
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unexpected request type %T", req)
+	}
+
+	data, err := pb.Marshal(msg)
+	if err != nil {
+		return status.Error(codes.Internal, "failed to serialize request")
+	}
+
+	hash := pb.Hash(data, key)
+	if !hmac.Equal([]byte(hash), []byte(provided)) {
+		logger.Log.Info("hash check failed",
+			zap.String("calculated", hash),
+			zap.String("provided", provided),
+		)
+		return status.Error(codes.Unauthenticated, "wrong hash")
+	}
+
+	return nil
+}
+
+// serverStream calls received on every message received.
+type serverStream struct {
+	grpc.ServerStream
+	received func(m any) error
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	return s.received(m)
+}
+
+// metadataValue returns the first value of incoming metadata key.
+func metadataValue(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
 }
 
 // InterceptorLogger is a logger which uses uber's zap.
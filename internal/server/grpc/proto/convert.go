@@ -0,0 +1,100 @@
+package proto
+
+import (
+	"strings"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// NewHistogram converts model.Histogram to protobuf histogram message.
+func NewHistogram(h model.Histogram) *Histogram {
+	res := &Histogram{
+		Buckets: make([]*Bucket, 0, len(h.Buckets)),
+		Sum:     h.Sum,
+		Count:   h.Count,
+	}
+
+	for _, b := range h.Buckets {
+		res.Buckets = append(res.Buckets, &Bucket{
+			UpperBound: b.UpperBound,
+			Count:      b.Count,
+		})
+	}
+
+	return res
+}
+
+// Model converts protobuf histogram message to model.Histogram.
+func (x *Histogram) Model() model.Histogram {
+	res := model.Histogram{
+		Buckets: make([]model.Bucket, 0, len(x.GetBuckets())),
+		Sum:     x.GetSum(),
+		Count:   x.GetCount(),
+	}
+
+	for _, b := range x.GetBuckets() {
+		res.Buckets = append(res.Buckets, model.Bucket{
+			UpperBound: b.GetUpperBound(),
+			Count:      b.GetCount(),
+		})
+	}
+
+	return res
+}
+
+// NewSummary converts model.Summary to protobuf summary message.
+func NewSummary(s model.Summary) *Summary {
+	res := &Summary{
+		Quantiles: make([]*Quantile, 0, len(s.Quantiles)),
+		Sum:       s.Sum,
+		Count:     s.Count,
+	}
+
+	for _, q := range s.Quantiles {
+		res.Quantiles = append(res.Quantiles, &Quantile{
+			Quantile: q.Quantile,
+			Value:    q.Value,
+		})
+	}
+
+	return res
+}
+
+// Model converts protobuf summary message to model.Summary.
+func (x *Summary) Model() model.Summary {
+	res := model.Summary{
+		Quantiles: make([]model.Quantile, 0, len(x.GetQuantiles())),
+		Sum:       x.GetSum(),
+		Count:     x.GetCount(),
+	}
+
+	for _, q := range x.GetQuantiles() {
+		res.Quantiles = append(res.Quantiles, model.Quantile{
+			Quantile: q.GetQuantile(),
+			Value:    q.GetValue(),
+		})
+	}
+
+	return res
+}
+
+// NewMetric converts model.Metrics to protobuf metric message.
+func NewMetric(m model.Metrics) *Metric {
+	res := &Metric{
+		Id:     m.ID,
+		Type:   MetricType(MetricType_value[strings.ToUpper(m.MType)]),
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Labels: m.Labels,
+	}
+
+	if m.Histogram != nil {
+		res.Histogram = NewHistogram(*m.Histogram)
+	}
+
+	if m.Summary != nil {
+		res.Summary = NewSummary(*m.Summary)
+	}
+
+	return res
+}
@@ -20,10 +20,13 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Metrics_Ping_FullMethodName        = "/grpc.Metrics/Ping"
-	Metrics_GetValue_FullMethodName    = "/grpc.Metrics/GetValue"
-	Metrics_Update_FullMethodName      = "/grpc.Metrics/Update"
-	Metrics_UpdateBatch_FullMethodName = "/grpc.Metrics/UpdateBatch"
+	Metrics_Ping_FullMethodName         = "/grpc.Metrics/Ping"
+	Metrics_GetValue_FullMethodName     = "/grpc.Metrics/GetValue"
+	Metrics_Update_FullMethodName       = "/grpc.Metrics/Update"
+	Metrics_UpdateBatch_FullMethodName  = "/grpc.Metrics/UpdateBatch"
+	Metrics_UpdateStream_FullMethodName = "/grpc.Metrics/UpdateStream"
+	Metrics_GetHistory_FullMethodName   = "/grpc.Metrics/GetHistory"
+	Metrics_Watch_FullMethodName        = "/grpc.Metrics/Watch"
 )
 
 // MetricsClient is the client API for Metrics service.
@@ -35,6 +38,13 @@ type MetricsClient interface {
 	GetValue(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*Metric, error)
 	Update(ctx context.Context, in *Metric, opts ...grpc.CallOption) (*Metric, error)
 	UpdateBatch(ctx context.Context, in *UpdateBatchRequest, opts ...grpc.CallOption) (*UpdateBatchResponse, error)
+	// поток метрик, применяемых пачками по мере получения; ключ
+	// идемпотентности передаётся в метаданных (см. proto.IdempotencyKeyMetadataKey)
+	UpdateStream(ctx context.Context, opts ...grpc.CallOption) (Metrics_UpdateStreamClient, error)
+	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error)
+	// поток обновлений метрик, применяемых сервером (через HTTP или gRPC);
+	// медленный подписчик не тормозит запись - события для него пропускаются
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Metrics_WatchClient, error)
 }
 
 type metricsClient struct {
@@ -81,6 +91,81 @@ func (c *metricsClient) UpdateBatch(ctx context.Context, in *UpdateBatchRequest,
 	return out, nil
 }
 
+func (c *metricsClient) UpdateStream(ctx context.Context, opts ...grpc.CallOption) (Metrics_UpdateStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Metrics_ServiceDesc.Streams[0], Metrics_UpdateStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricsUpdateStreamClient{stream}
+	return x, nil
+}
+
+type Metrics_UpdateStreamClient interface {
+	Send(*Metric) error
+	CloseAndRecv() (*UpdateBatchResponse, error)
+	grpc.ClientStream
+}
+
+type metricsUpdateStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricsUpdateStreamClient) Send(m *Metric) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *metricsUpdateStreamClient) CloseAndRecv() (*UpdateBatchResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UpdateBatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metricsClient) GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error) {
+	out := new(GetHistoryResponse)
+	err := c.cc.Invoke(ctx, Metrics_GetHistory_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Metrics_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Metrics_ServiceDesc.Streams[1], Metrics_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricsWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Metrics_WatchClient interface {
+	Recv() (*MetricEvent, error)
+	grpc.ClientStream
+}
+
+type metricsWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricsWatchClient) Recv() (*MetricEvent, error) {
+	m := new(MetricEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility
@@ -90,6 +175,13 @@ type MetricsServer interface {
 	GetValue(context.Context, *GetMetricRequest) (*Metric, error)
 	Update(context.Context, *Metric) (*Metric, error)
 	UpdateBatch(context.Context, *UpdateBatchRequest) (*UpdateBatchResponse, error)
+	// поток метрик, применяемых пачками по мере получения; ключ
+	// идемпотентности передаётся в метаданных (см. proto.IdempotencyKeyMetadataKey)
+	UpdateStream(Metrics_UpdateStreamServer) error
+	GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error)
+	// поток обновлений метрик, применяемых сервером (через HTTP или gRPC);
+	// медленный подписчик не тормозит запись - события для него пропускаются
+	Watch(*WatchRequest, Metrics_WatchServer) error
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -109,6 +201,15 @@ func (UnimplementedMetricsServer) Update(context.Context, *Metric) (*Metric, err
 func (UnimplementedMetricsServer) UpdateBatch(context.Context, *UpdateBatchRequest) (*UpdateBatchResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateBatch not implemented")
 }
+func (UnimplementedMetricsServer) UpdateStream(Metrics_UpdateStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method UpdateStream not implemented")
+}
+func (UnimplementedMetricsServer) GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedMetricsServer) Watch(*WatchRequest, Metrics_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 
 // UnsafeMetricsServer may be embedded to opt out of forward compatibility for this service.
@@ -194,6 +295,71 @@ func _Metrics_UpdateBatch_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Metrics_UpdateStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricsServer).UpdateStream(&metricsUpdateStreamServer{stream})
+}
+
+type Metrics_UpdateStreamServer interface {
+	SendAndClose(*UpdateBatchResponse) error
+	Recv() (*Metric, error)
+	grpc.ServerStream
+}
+
+type metricsUpdateStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricsUpdateStreamServer) SendAndClose(m *UpdateBatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *metricsUpdateStreamServer) Recv() (*Metric, error) {
+	m := new(Metric)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Metrics_GetHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_GetHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetHistory(ctx, req.(*GetHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetricsServer).Watch(m, &metricsWatchServer{stream})
+}
+
+type Metrics_WatchServer interface {
+	Send(*MetricEvent) error
+	grpc.ServerStream
+}
+
+type metricsWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricsWatchServer) Send(m *MetricEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -217,7 +383,22 @@ var Metrics_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateBatch",
 			Handler:    _Metrics_UpdateBatch_Handler,
 		},
+		{
+			MethodName: "GetHistory",
+			Handler:    _Metrics_GetHistory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UpdateStream",
+			Handler:       _Metrics_UpdateStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _Metrics_Watch_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/server/grpc/proto/metrics.proto",
 }
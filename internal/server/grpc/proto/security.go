@@ -0,0 +1,279 @@
+package proto
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Metadata keys, same as HTTP API headers.
+const (
+	// HashMetadataKey holds hex encoded HMAC-SHA256 of the serialized
+	// request (see Marshal), request is hashed before encryption.
+	HashMetadataKey = "hashsha256"
+	// EncryptionMetadataKey is set to "1" when request is encrypted.
+	EncryptionMetadataKey = "content-encryption"
+	// XRealIPMetadataKey holds IP of the client host.
+	XRealIPMetadataKey = "x-real-ip"
+	// IdempotencyKeyMetadataKey holds idempotency key of UpdateStream
+	// stream.
+	IdempotencyKeyMetadataKey = "idempotency-key"
+)
+
+// EncryptedField is a bytes field of request messages which can be sent
+// encrypted. Encrypted request carries the whole serialized request
+// encrypted in this field, other fields are empty.
+const EncryptedField = "encrypted"
+
+// HashField is a string field of messages sent in streams. Stream metadata
+// is sent once, so every streamed message carries its own hash: hex encoded
+// HMAC-SHA256 of the serialized message (see Marshal) with the field empty.
+// Message is hashed before encryption.
+const HashField = "hash"
+
+// Errors of messages security features.
+var (
+	ErrEncryptionNotSupported = errors.New("message doesn't support encryption")
+	ErrHashNotSupported       = errors.New("message doesn't support hash")
+	ErrHashRequired           = errors.New("message hash required")
+	ErrWrongHash              = errors.New("wrong message hash")
+)
+
+// Marshal serializes msg deterministically, so the same message is always
+// hashed the same way by client and server.
+func Marshal(msg proto.Message) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// Hash returns hex encoded HMAC-SHA256 of data.
+func Hash(data []byte, key string) string {
+	hasher := hmac.New(sha256.New, []byte(key))
+	hasher.Write(data)
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// Seal returns a new message of the same type as msg with encrypted data
+// (serialized msg) put into EncryptedField.
+func Seal(msg proto.Message, encrypted []byte) (proto.Message, error) {
+	fd, err := encryptedField(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := msg.ProtoReflect().New()
+	sealed.Set(fd, protoreflect.ValueOfBytes(encrypted))
+
+	return sealed.Interface(), nil
+}
+
+// Unseal replaces msg with the message decrypted from its EncryptedField.
+func Unseal(msg proto.Message, decrypt func([]byte) ([]byte, error)) error {
+	fd, err := encryptedField(msg)
+	if err != nil {
+		return err
+	}
+
+	data, err := decrypt(msg.ProtoReflect().Get(fd).Bytes())
+	if err != nil {
+		return err
+	}
+
+	proto.Reset(msg)
+
+	return proto.Unmarshal(data, msg)
+}
+
+// Sign returns a copy of msg with its hash put into HashField.
+func Sign(msg proto.Message, key string) (proto.Message, error) {
+	fd, err := hashField(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := proto.Clone(msg)
+	signed.ProtoReflect().Set(fd, protoreflect.ValueOfString(Hash(data, key)))
+
+	return signed, nil
+}
+
+// Verify checks hash put into HashField of msg by Sign, the field is
+// cleared.
+func Verify(msg proto.Message, key string) error {
+	fd, err := hashField(msg)
+	if err != nil {
+		return err
+	}
+
+	m := msg.ProtoReflect()
+	provided := m.Get(fd).String()
+	if provided == "" {
+		return ErrHashRequired
+	}
+	m.Clear(fd)
+
+	data, err := Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(Hash(data, key)), []byte(provided)) {
+		return ErrWrongHash
+	}
+
+	return nil
+}
+
+func encryptedField(msg proto.Message) (protoreflect.FieldDescriptor, error) {
+	d := msg.ProtoReflect().Descriptor()
+
+	fd := d.Fields().ByName(EncryptedField)
+	if fd == nil || fd.Kind() != protoreflect.BytesKind {
+		return nil, fmt.Errorf("%w: %s", ErrEncryptionNotSupported, d.FullName())
+	}
+
+	return fd, nil
+}
+
+// encryptable tells whether msg can be sent encrypted.
+func encryptable(msg proto.Message) bool {
+	_, err := encryptedField(msg)
+	return err == nil
+}
+
+func hashField(msg proto.Message) (protoreflect.FieldDescriptor, error) {
+	d := msg.ProtoReflect().Descriptor()
+
+	fd := d.Fields().ByName(HashField)
+	if fd == nil || fd.Kind() != protoreflect.StringKind {
+		return nil, fmt.Errorf("%w: %s", ErrHashNotSupported, d.FullName())
+	}
+
+	return fd, nil
+}
+
+// encrypt returns msg encrypted with enc, see Seal.
+func encrypt(msg proto.Message, enc *encryptor.Encryptor) (proto.Message, error) {
+	data, err := Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	ciphertext, err := enc.Encrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("message encryption failed: %w", err)
+	}
+
+	return Seal(msg, ciphertext)
+}
+
+// UnaryClientInterceptor applies request security features same as the
+// HTTP API ones: request is compressed with gzip, hashed with key, encrypted
+// with enc and host IP is passed in metadata. Empty key, nil enc and empty
+// hostIP disable the corresponding feature. Requests without EncryptedField
+// (e.g. Ping) are sent unencrypted.
+func UnaryClientInterceptor(key string, enc *encryptor.Encryptor, hostIP string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+
+		if hostIP != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, XRealIPMetadataKey, hostIP)
+		}
+
+		if key == "" && enc == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return fmt.Errorf("unexpected request type %T", req)
+		}
+
+		data, err := Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to serialize request: %w", err)
+		}
+
+		if key != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, HashMetadataKey, Hash(data, key))
+		}
+
+		if enc != nil && encryptable(msg) {
+			if req, err = encrypt(msg, enc); err != nil {
+				return err
+			}
+
+			ctx = metadata.AppendToOutgoingContext(ctx, EncryptionMetadataKey, "1")
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is the same as UnaryClientInterceptor for streams:
+// every sent message is signed (see Sign) and encrypted.
+func StreamClientInterceptor(key string, enc *encryptor.Encryptor, hostIP string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+
+		if hostIP != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, XRealIPMetadataKey, hostIP)
+		}
+
+		if enc != nil {
+			ctx = metadata.AppendToOutgoingContext(ctx, EncryptionMetadataKey, "1")
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil || (key == "" && enc == nil) {
+			return cs, err
+		}
+
+		return &clientStream{ClientStream: cs, key: key, enc: enc}, nil
+	}
+}
+
+// clientStream signs and encrypts sent messages.
+type clientStream struct {
+	grpc.ClientStream
+	key string
+	enc *encryptor.Encryptor
+}
+
+func (s *clientStream) SendMsg(m any) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", m)
+	}
+
+	var err error
+
+	if s.key != "" {
+		if msg, err = Sign(msg, s.key); err != nil {
+			return err
+		}
+	}
+
+	if s.enc != nil {
+		if msg, err = encrypt(msg, s.enc); err != nil {
+			return err
+		}
+	}
+
+	return s.ClientStream.SendMsg(msg)
+}
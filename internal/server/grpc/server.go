@@ -6,6 +6,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,9 +21,12 @@ import (
 	"github.com/Dmitrevicz/gometrics/internal/storage/memstorage"
 	"github.com/Dmitrevicz/gometrics/internal/storage/postgres"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // MetricsServer implements service methods.
@@ -30,19 +35,49 @@ type MetricsServer struct {
 
 	cfg     *config.Config
 	Storage storage.Storage
+	// Dumper stores metrics to file after updates when configured so.
+	Dumper *server.Dumper
+	// Hub publishes applied updates to Watch subscribers.
+	Hub *server.Hub
 }
 
 func NewMetricsServer(cfg *config.Config) *MetricsServer {
 	s := &MetricsServer{
 		cfg: cfg,
+		Hub: server.NewHub(),
 	}
 
 	// FIXME: pass storage as a dependency
 	s.configureStorage(cfg)
+	s.Dumper = server.NewDumper(s.Storage, cfg)
 
 	return s
 }
 
+// NewMetricsServerWithStorage creates MetricsServer sharing storage, dumper
+// and hub with the HTTP server.
+func NewMetricsServerWithStorage(cfg *config.Config, storage storage.Storage, dumper *server.Dumper, hub *server.Hub) *MetricsServer {
+	return &MetricsServer{
+		cfg:     cfg,
+		Storage: storage,
+		Dumper:  dumper,
+		Hub:     hub,
+	}
+}
+
+// KeepaliveEnforcementPolicy permits client keepalive pings every
+// KeepaliveMinTime, even without active RPCs: agents keep connection open
+// between reports and ping it to detect broken ones.
+func KeepaliveEnforcementPolicy() grpc.ServerOption {
+	return grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             KeepaliveMinTime,
+		PermitWithoutStream: true,
+	})
+}
+
+// KeepaliveMinTime is the minimum interval of client keepalive pings.
+const KeepaliveMinTime = 10 * time.Second
+
 // TODO: move storage setup away from server code
 func (s *MetricsServer) configureStorage(cfg *config.Config) {
 	if cfg.DatabaseDSN != "" {
@@ -51,15 +86,15 @@ func (s *MetricsServer) configureStorage(cfg *config.Config) {
 			logger.Log.Fatal("Can't configure storage", zap.Error(err))
 		}
 
-		// if err = createTables(db); err != nil {
-		// 	logger.Log.Fatal("Can't configure storage", zap.Error(err))
-		// }
+		if err = postgres.Migrate(context.Background(), db, cfg.DatabaseVerifyOnly); err != nil {
+			logger.Log.Fatal("Can't configure storage", zap.Error(err))
+		}
 
-		s.Storage = postgres.New(db)
+		s.Storage = postgres.NewWithLimits(db, time.Millisecond*time.Duration(cfg.DatabaseQueryTimeout), cfg.HistorySize)
 		return
 	}
 
-	s.Storage = memstorage.New()
+	s.Storage = memstorage.NewWithHistorySize(cfg.HistorySize)
 }
 
 // TODO: move storage setup away from server code
@@ -114,15 +149,26 @@ func (s *MetricsServer) GetValue(ctx context.Context, req *pb.GetMetricRequest)
 		return nil, status.Error(codes.InvalidArgument, server.ErrMsgEmptyMetricName)
 	}
 
+	key, err := model.SeriesKey(req.Id, req.Labels)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	switch strings.ToLower(req.Type.String()) {
 	case model.MetricTypeGauge:
-		value, err = s.Storage.Gauges().Get(req.Id)
+		value, err = s.Storage.Gauges().Get(ctx, key)
 		f := float64(value.(model.Gauge))
 		metric.Value = &f
 	case model.MetricTypeCounter:
-		value, err = s.Storage.Counters().Get(req.Id)
+		value, err = s.Storage.Counters().Get(ctx, key)
 		d := int64(value.(model.Counter))
 		metric.Delta = &d
+	case model.MetricTypeHistogram:
+		value, err = s.Storage.Histograms().Get(ctx, key)
+		metric.Histogram = pb.NewHistogram(value.(model.Histogram))
+	case model.MetricTypeSummary:
+		value, err = s.Storage.Summaries().Get(ctx, key)
+		metric.Summary = pb.NewSummary(value.(model.Summary))
 	default:
 		return nil, status.Error(codes.InvalidArgument, server.ErrMsgWrongMetricType)
 	}
@@ -138,6 +184,7 @@ func (s *MetricsServer) GetValue(ctx context.Context, req *pb.GetMetricRequest)
 
 	metric.Id = req.Id
 	metric.Type = req.Type
+	metric.Labels = req.Labels
 
 	return &metric, nil
 }
@@ -155,12 +202,21 @@ func (s *MetricsServer) Update(ctx context.Context, req *pb.Metric) (*pb.Metric,
 		return nil, status.Error(codes.NotFound, server.ErrMsgEmptyMetricName)
 	}
 
+	key, err := model.SeriesKey(req.Id, req.Labels)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	var statusErr error
 	switch strings.ToLower(req.Type.String()) {
 	case model.MetricTypeGauge:
-		statusErr = s.updateGauge(ctx, req)
+		statusErr = s.updateGauge(ctx, key, req)
 	case model.MetricTypeCounter:
-		statusErr = s.updateCounter(ctx, req)
+		statusErr = s.updateCounter(ctx, key, req)
+	case model.MetricTypeHistogram:
+		statusErr = s.updateHistogram(ctx, key, req)
+	case model.MetricTypeSummary:
+		statusErr = s.updateSummary(ctx, key, req)
 	default:
 		return nil, status.Error(codes.InvalidArgument, server.ErrMsgWrongMetricType)
 	}
@@ -172,25 +228,24 @@ func (s *MetricsServer) Update(ctx context.Context, req *pb.Metric) (*pb.Metric,
 	return req, nil
 }
 
-func (s *MetricsServer) updateGauge(_ context.Context, m *pb.Metric) error {
+func (s *MetricsServer) updateGauge(ctx context.Context, key string, m *pb.Metric) error {
 	if m.Value == nil {
 		// http.StatusNotFound was required in previous increments
 		return status.Error(codes.NotFound, server.ErrMsgWrongMetricValue)
 	}
 
-	err := s.Storage.Gauges().Set(m.Id, model.Gauge(*m.Value))
+	err := s.Storage.Gauges().Set(ctx, key, model.Gauge(*m.Value))
 	if err != nil {
 		logger.Log.Error(server.ErrMsgStorageFail, zap.Error(err))
 		return status.Error(codes.Internal, server.ErrMsgStorageFail)
 	}
+	s.Hub.PublishBatch(model.Batch{Gauges: []model.MetricGauge{{Name: key, Value: model.Gauge(*m.Value)}}})
 	m.Delta = nil
 
-	// TODO: do smth with Dumper later
-
-	return nil
+	return s.dump(ctx)
 }
 
-func (s *MetricsServer) updateCounter(_ context.Context, m *pb.Metric) error {
+func (s *MetricsServer) updateCounter(ctx context.Context, key string, m *pb.Metric) error {
 	if m.Delta == nil {
 		// http.StatusNotFound was required in previous increments
 		return status.Error(codes.NotFound, server.ErrMsgWrongMetricValue)
@@ -200,15 +255,18 @@ func (s *MetricsServer) updateCounter(_ context.Context, m *pb.Metric) error {
 		return status.Error(codes.InvalidArgument, server.ErrMsgNegativeCounter)
 	}
 
-	err := s.Storage.Counters().Set(m.Id, model.Counter(*m.Delta))
+	err := s.Storage.Counters().Set(ctx, key, model.Counter(*m.Delta))
 	if err != nil {
 		logger.Log.Error(server.ErrMsgStorageFail, zap.Error(err))
 		return status.Error(codes.Internal, server.ErrMsgStorageFail)
 	}
+	s.Hub.PublishBatch(model.Batch{Counters: []model.MetricCounter{{Name: key, Value: model.Counter(*m.Delta)}}})
 
-	// TODO: do smth with Dumper later
+	if err = s.dump(ctx); err != nil {
+		return err
+	}
 
-	counter, err := s.Storage.Counters().Get(m.Id)
+	counter, err := s.Storage.Counters().Get(ctx, key)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			logger.Log.Error(server.ErrMsgNothingFound + " after update attempt")
@@ -225,31 +283,187 @@ func (s *MetricsServer) updateCounter(_ context.Context, m *pb.Metric) error {
 	return nil
 }
 
-func (s *MetricsServer) UpdateBatch(ctx context.Context, req *pb.UpdateBatchRequest) (*pb.UpdateBatchResponse, error) {
-	gauges, counters, err := prepareBatchedMetrics(req.GetMetrics())
-	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+// updateHistogram merges observations into the stored histogram. Merged
+// histogram is set back to m.
+func (s *MetricsServer) updateHistogram(ctx context.Context, key string, m *pb.Metric) error {
+	if m.Histogram == nil {
+		// http.StatusNotFound was required in previous increments
+		return status.Error(codes.NotFound, server.ErrMsgWrongMetricValue)
+	}
+
+	h := m.Histogram.Model()
+	if err := h.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
 	}
-	logger.Log.Info("batch parsed", zap.Any("gauges", gauges), zap.Any("counters", counters))
 
-	if err = s.Storage.Gauges().BatchUpdate(gauges); err != nil {
+	err := s.Storage.Histograms().Set(ctx, key, h)
+	if err != nil {
 		logger.Log.Error(server.ErrMsgStorageFail, zap.Error(err))
-		return nil, status.Error(codes.Internal, server.ErrMsgStorageFail)
+		return status.Error(codes.Internal, server.ErrMsgStorageFail)
+	}
+	s.Hub.PublishBatch(model.Batch{Histograms: []model.MetricHistogram{{Name: key, Value: h}}})
+
+	if err = s.dump(ctx); err != nil {
+		return err
+	}
+
+	h, err = s.Storage.Histograms().Get(ctx, key)
+	if err != nil {
+		logger.Log.Error(server.ErrMsgStorageFail+" after update attempt", zap.Error(err))
+		return status.Error(codes.Internal, server.ErrMsgStorageFail)
 	}
 
-	if err = s.Storage.Counters().BatchUpdate(counters); err != nil {
+	m.Histogram = pb.NewHistogram(h)
+	m.Delta, m.Value, m.Summary = nil, nil, nil
+
+	return nil
+}
+
+func (s *MetricsServer) updateSummary(ctx context.Context, key string, m *pb.Metric) error {
+	if m.Summary == nil {
+		// http.StatusNotFound was required in previous increments
+		return status.Error(codes.NotFound, server.ErrMsgWrongMetricValue)
+	}
+
+	summary := m.Summary.Model()
+	if err := summary.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err := s.Storage.Summaries().Set(ctx, key, summary)
+	if err != nil {
 		logger.Log.Error(server.ErrMsgStorageFail, zap.Error(err))
-		return nil, status.Error(codes.Internal, server.ErrMsgStorageFail)
+		return status.Error(codes.Internal, server.ErrMsgStorageFail)
 	}
+	s.Hub.PublishBatch(model.Batch{Summaries: []model.MetricSummary{{Name: key, Value: summary}}})
+	m.Delta, m.Value, m.Histogram = nil, nil, nil
+
+	return s.dump(ctx)
+}
 
-	// TODO: do smth with Dumper later
+func (s *MetricsServer) UpdateBatch(ctx context.Context, req *pb.UpdateBatchRequest) (*pb.UpdateBatchResponse, error) {
+	if err := s.applyBatch(ctx, req.GetMetrics(), req.GetIdempotencyKey()); err != nil {
+		return nil, err
+	}
 
 	return &pb.UpdateBatchResponse{}, nil
 }
 
-// prepareBatchedMetrics prepares a batch of metrics for gauges and counters
-// separately.
-func prepareBatchedMetrics(metrics []*pb.Metric) (gs []model.MetricGauge, cs []model.MetricCounter, err error) {
+// StreamBatchSize bounds number of metrics UpdateStream keeps in memory:
+// received metrics are applied in batches of this size.
+const StreamBatchSize = 1000
+
+// MaxStreamIdempotencyKeyLength is a max length of UpdateStream idempotency
+// key: room is reserved for batch number suffix ("/" and up to 11 digits).
+const MaxStreamIdempotencyKeyLength = model.MaxIdempotencyKeyLength - 12
+
+// UpdateStream applies metrics received in stream. Metrics are applied in
+// batches of at most StreamBatchSize as they're received, so batches applied
+// before the stream failed stay applied. Idempotency key passed in
+// pb.IdempotencyKeyMetadataKey metadata is suffixed with batch number, so
+// stream retried with the same key and metrics applies only batches which
+// weren't applied yet.
+func (s *MetricsServer) UpdateStream(stream pb.Metrics_UpdateStreamServer) error {
+	ctx := stream.Context()
+
+	key := metadataValue(ctx, pb.IdempotencyKeyMetadataKey)
+	if len(key) > MaxStreamIdempotencyKeyLength {
+		return status.Errorf(codes.InvalidArgument, "%s: max length is %d",
+			model.ErrWrongIdempotencyKey, MaxStreamIdempotencyKeyLength)
+	}
+
+	var (
+		metrics = make([]*pb.Metric, 0, StreamBatchSize)
+		batches int
+	)
+
+	flush := func() error {
+		if len(metrics) == 0 {
+			return nil
+		}
+		batches++
+
+		var batchKey string
+		if key != "" {
+			batchKey = key + "/" + strconv.Itoa(batches)
+		}
+
+		err := s.applyBatch(ctx, metrics, batchKey)
+		metrics = metrics[:0]
+
+		return err
+	}
+
+	for {
+		metric, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		metrics = append(metrics, metric)
+		if len(metrics) == StreamBatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&pb.UpdateBatchResponse{})
+}
+
+// applyBatch validates metrics and applies them to storage as a single batch,
+// status error is returned.
+func (s *MetricsServer) applyBatch(ctx context.Context, metrics []*pb.Metric, idempotencyKey string) error {
+	batch, err := prepareBatchedMetrics(metrics)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err = model.ValidateIdempotencyKey(idempotencyKey); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	batch.IdempotencyKey = idempotencyKey
+	logger.Log.Info("batch parsed",
+		zap.Any("gauges", batch.Gauges),
+		zap.Any("counters", batch.Counters),
+		zap.Any("histograms", batch.Histograms),
+		zap.Any("summaries", batch.Summaries),
+	)
+
+	if err = s.Storage.BatchUpdate(ctx, batch); err != nil {
+		if errors.Is(err, storage.ErrDuplicateBatch) {
+			logger.Log.Info("duplicate batch skipped", zap.String("idempotency_key", batch.IdempotencyKey))
+			return nil
+		}
+
+		logger.Log.Error(server.ErrMsgStorageFail, zap.Error(err))
+		return status.Error(codes.Internal, server.ErrMsgStorageFail)
+	}
+	s.Hub.PublishBatch(batch)
+
+	return s.dump(ctx)
+}
+
+// dump stores metrics to file when it's configured to be done after every
+// update (see server.Dumper), status error is returned.
+func (s *MetricsServer) dump(ctx context.Context) error {
+	if err := s.Dumper.Dump(ctx); err != nil {
+		logger.Log.Error(server.ErrMsgDumperFail, zap.Error(err))
+		return status.Error(codes.Internal, server.ErrMsgDumperFail)
+	}
+
+	return nil
+}
+
+// prepareBatchedMetrics prepares a batch of metrics split by metric type.
+func prepareBatchedMetrics(metrics []*pb.Metric) (batch model.Batch, err error) {
 	if len(metrics) == 0 {
 		return
 	}
@@ -260,43 +474,191 @@ func prepareBatchedMetrics(metrics []*pb.Metric) (gs []model.MetricGauge, cs []m
 		mtype = strings.ToLower(metric.Type.String())
 
 		if metric.Type == 0 {
-			return nil, nil, fmt.Errorf("%w: \"%s\"", server.ErrWrongMetricType, metric.Type)
+			return batch, fmt.Errorf("%w: \"%s\"", server.ErrWrongMetricType, metric.Type)
 		}
 		if metric.Id == "" {
-			return nil, nil, server.ErrEmptyMetricName
+			return batch, server.ErrEmptyMetricName
+		}
+
+		key, err := model.SeriesKey(metric.Id, metric.Labels)
+		if err != nil {
+			return batch, err
 		}
 
 		switch mtype {
 		case model.MetricTypeGauge:
 			if metric.Value == nil {
-				return nil, nil, server.ErrWrongMetricValue
+				return batch, server.ErrWrongMetricValue
 			}
 
-			gs = append(gs, model.MetricGauge{
-				Name:  metric.Id,
+			batch.Gauges = append(batch.Gauges, model.MetricGauge{
+				Name:  key,
 				Value: model.Gauge(*metric.Value),
 			})
 		case model.MetricTypeCounter:
 			if metric.Delta == nil {
-				return nil, nil, server.ErrWrongMetricValue
+				return batch, server.ErrWrongMetricValue
 			}
 
 			if *metric.Delta < 0 {
-				return nil, nil, server.ErrNegativeCounter
+				return batch, server.ErrNegativeCounter
 			}
 
-			cs = append(cs, model.MetricCounter{
-				Name:  metric.Id,
+			batch.Counters = append(batch.Counters, model.MetricCounter{
+				Name:  key,
 				Value: model.Counter(*metric.Delta),
 			})
+		case model.MetricTypeHistogram:
+			if metric.Histogram == nil {
+				return batch, server.ErrWrongMetricValue
+			}
+
+			h := metric.Histogram.Model()
+			if err = h.Validate(); err != nil {
+				return batch, err
+			}
+
+			batch.Histograms = append(batch.Histograms, model.MetricHistogram{
+				Name:  key,
+				Value: h,
+			})
+		case model.MetricTypeSummary:
+			if metric.Summary == nil {
+				return batch, server.ErrWrongMetricValue
+			}
+
+			summary := metric.Summary.Model()
+			if err = summary.Validate(); err != nil {
+				return batch, err
+			}
+
+			batch.Summaries = append(batch.Summaries, model.MetricSummary{
+				Name:  key,
+				Value: summary,
+			})
 		default:
-			return nil, nil, fmt.Errorf("%w: \"%s\"", server.ErrWrongMetricType, metric.Type)
+			return batch, fmt.Errorf("%w: \"%s\"", server.ErrWrongMetricType, metric.Type)
 		}
 	}
 
 	return
 }
 
+// Watch streams updates applied by the server matching requested filters
+// until the client cancels the stream or the hub is closed. Subscriber that
+// doesn't keep up misses events, number of missed events is reported in
+// the next sent event.
+func (s *MetricsServer) Watch(req *pb.WatchRequest, stream pb.Metrics_WatchServer) error {
+	filter := server.Filter{Prefix: req.GetPrefix()}
+	for _, t := range req.GetTypes() {
+		if _, ok := pb.MetricType_name[int32(t)]; !ok || t == pb.MetricType_UNSPECIFIED {
+			return status.Error(codes.InvalidArgument, server.ErrMsgWrongMetricType)
+		}
+
+		filter.Types = append(filter.Types, strings.ToLower(t.String()))
+	}
+
+	sub := s.Hub.Subscribe(filter, 0)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+
+			err := stream.Send(&pb.MetricEvent{
+				Metric:    pb.NewMetric(e.Metric),
+				Timestamp: timestamppb.New(e.Time),
+				Dropped:   sub.Dropped(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetHistory returns values of the metric recorded within requested time range.
+func (s *MetricsServer) GetHistory(ctx context.Context, req *pb.GetHistoryRequest) (*pb.GetHistoryResponse, error) {
+	req.Id = strings.TrimSpace(req.Id)
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, server.ErrMsgEmptyMetricName)
+	}
+
+	to := time.Now()
+	if req.To != nil {
+		to = req.To.AsTime()
+	}
+
+	from := to.Add(-server.DefaultHistoryRange)
+	if req.From != nil {
+		from = req.From.AsTime()
+	}
+
+	step := req.GetStep().AsDuration()
+
+	if err := server.CheckHistoryRange(from, to, step); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	key, err := model.SeriesKey(req.Id, req.Labels)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	resp := &pb.GetHistoryResponse{
+		Id:     req.Id,
+		Type:   req.Type,
+		Labels: req.Labels,
+	}
+
+	switch strings.ToLower(req.Type.String()) {
+	case model.MetricTypeGauge:
+		var records []model.GaugeRecord
+		records, err = s.Storage.Gauges().History(ctx, key, from, to)
+		records = server.Downsample(records, step, func(r model.GaugeRecord) time.Time {
+			return r.Timestamp
+		})
+
+		resp.Points = make([]*pb.HistoryPoint, 0, len(records))
+		for _, r := range records {
+			f := float64(r.Value)
+			resp.Points = append(resp.Points, &pb.HistoryPoint{
+				Timestamp: timestamppb.New(r.Timestamp),
+				Value:     &f,
+			})
+		}
+	case model.MetricTypeCounter:
+		var records []model.CounterRecord
+		records, err = s.Storage.Counters().History(ctx, key, from, to)
+		records = server.Downsample(records, step, func(r model.CounterRecord) time.Time {
+			return r.Timestamp
+		})
+
+		resp.Points = make([]*pb.HistoryPoint, 0, len(records))
+		for _, r := range records {
+			total := int64(r.Value)
+			resp.Points = append(resp.Points, &pb.HistoryPoint{
+				Timestamp: timestamppb.New(r.Timestamp),
+				Total:     &total,
+			})
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, server.ErrMsgWrongMetricType)
+	}
+
+	if err != nil {
+		logger.Log.Error(server.ErrMsgStorageFail, zap.Error(err))
+		return nil, status.Error(codes.Internal, server.ErrMsgStorageFail)
+	}
+
+	return resp, nil
+}
+
 func (s *MetricsServer) Ping(ctx context.Context, req *emptypb.Empty) (*pb.PingResponse, error) {
 	if err := s.Storage.Ping(ctx); err != nil {
 		logger.Log.Error("database ping failed", zap.Error(err))
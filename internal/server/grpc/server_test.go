@@ -0,0 +1,209 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/server"
+	"github.com/Dmitrevicz/gometrics/internal/server/config"
+	pb "github.com/Dmitrevicz/gometrics/internal/server/grpc/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsServer_UpdateStream(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	metricsServer := NewMetricsServer(config.NewTesting())
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(listen)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(listen.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	// sendStream sends n counter increments, the last one is bad when bad
+	// is set
+	sendStream := func(key string, n int, bad bool) error {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), pb.IdempotencyKeyMetadataKey, key)
+		stream, err := client.UpdateStream(ctx)
+		require.NoError(t, err)
+
+		delta := int64(1)
+		for i := 0; i < n; i++ {
+			metric := &pb.Metric{Id: "hits", Type: pb.MetricType_COUNTER, Delta: &delta}
+			if bad && i == n-1 {
+				metric.Delta = nil
+			}
+
+			if err = stream.Send(metric); err != nil {
+				break
+			}
+		}
+
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	hits := func() int64 {
+		v, err := metricsServer.Storage.Counters().Get(context.Background(), "hits")
+		require.NoError(t, err)
+		return int64(v)
+	}
+
+	n := 2*StreamBatchSize + 10
+	require.NoError(t, sendStream("a-1", n, false))
+	assert.EqualValues(t, n, hits())
+
+	// retried stream isn't applied twice
+	require.NoError(t, sendStream("a-1", n, false))
+	assert.EqualValues(t, n, hits())
+
+	// batches received before the failure stay applied
+	err = sendStream("a-2", StreamBatchSize+1, true)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.EqualValues(t, n+StreamBatchSize, hits())
+
+	// key leaves room for batch number suffix
+	require.NoError(t, sendStream(strings.Repeat("k", MaxStreamIdempotencyKeyLength), StreamBatchSize+1, false))
+	assert.EqualValues(t, n+2*StreamBatchSize+1, hits())
+
+	err = sendStream(strings.Repeat("k", model.MaxIdempotencyKeyLength), 1, false)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.EqualValues(t, n+2*StreamBatchSize+1, hits())
+}
+
+func TestMetricsServer_Watch(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// HTTP server shares storage and hub
+	cfg := config.NewTesting()
+	srv := server.New(cfg)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	metricsServer := NewMetricsServerWithStorage(cfg, srv.Storage, srv.Dumper, srv.Hub)
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(listen)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(listen.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{Prefix: "Poll", Types: []pb.MetricType{pb.MetricType_COUNTER}})
+	require.NoError(t, err)
+
+	// publish warmup events till the stream subscribes
+	subscribed := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-subscribed:
+				return
+			case <-ticker.C:
+				metricsServer.Hub.PublishBatch(model.Batch{Counters: []model.MetricCounter{{Name: "PollWarmup", Value: 0}}})
+			}
+		}
+	}()
+
+	_, err = stream.Recv()
+	close(subscribed)
+	require.NoError(t, err)
+
+	value, delta := 1.5, int64(3)
+	_, err = client.UpdateBatch(context.Background(), &pb.UpdateBatchRequest{Metrics: []*pb.Metric{
+		{Id: "PollGauge", Type: pb.MetricType_GAUGE, Value: &value},
+		{Id: "Hits", Type: pb.MetricType_COUNTER, Delta: &delta},
+		{Id: "PollCount", Type: pb.MetricType_COUNTER, Delta: &delta, Labels: map[string]string{"host": "web1"}},
+	}})
+	require.NoError(t, err)
+
+	var event *pb.MetricEvent
+	for event.GetMetric().GetId() == "" || event.GetMetric().GetId() == "PollWarmup" {
+		event, err = stream.Recv()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "PollCount", event.GetMetric().GetId())
+	assert.Equal(t, pb.MetricType_COUNTER, event.GetMetric().GetType())
+	assert.Equal(t, delta, event.GetMetric().GetDelta())
+	assert.Equal(t, map[string]string{"host": "web1"}, event.GetMetric().GetLabels())
+	assert.NotNil(t, event.GetTimestamp())
+
+	// updates received over HTTP are watched too
+	resp, err := http.Post(ts.URL+"/update/counter/PollHTTP/2", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "PollHTTP", event.GetMetric().GetId())
+	assert.Equal(t, int64(2), event.GetMetric().GetDelta())
+
+	// streams are closed along with the hub
+	metricsServer.Hub.Close()
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+
+	stream, err = client.Watch(ctx, &pb.WatchRequest{Types: []pb.MetricType{pb.MetricType_UNSPECIFIED}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMetricsServer_dump(t *testing.T) {
+	// metrics are stored to file after every update
+	cfg := config.NewTesting()
+	cfg.StoreInterval = 0
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "metrics.json")
+	cfg.WALPath = ""
+
+	metricsServer := NewMetricsServer(cfg)
+	ctx := context.Background()
+
+	value := 1.5
+	_, err := metricsServer.Update(ctx, &pb.Metric{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: &value})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Alloc")
+
+	delta := int64(2)
+	_, err = metricsServer.UpdateBatch(ctx, &pb.UpdateBatchRequest{Metrics: []*pb.Metric{
+		{Id: "Hits", Type: pb.MetricType_COUNTER, Delta: &delta},
+	}})
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Hits")
+}
@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/logger"
 	"github.com/Dmitrevicz/gometrics/internal/model"
@@ -19,13 +20,15 @@ import (
 type Handlers struct {
 	storage storage.Storage
 	dumper  *Dumper
+	hub     *Hub
 }
 
-// NewHandlers creates new Handlers.
-func NewHandlers(storage storage.Storage, dumper *Dumper) *Handlers {
+// NewHandlers creates new Handlers. Applied updates are published to hub.
+func NewHandlers(storage storage.Storage, dumper *Dumper, hub *Hub) *Handlers {
 	return &Handlers{
 		storage: storage,
 		dumper:  dumper,
+		hub:     hub,
 	}
 }
 
@@ -44,6 +47,8 @@ func (h *Handlers) PingStorage(c *gin.Context) {
 //
 // > Сервер должен принимать данные в формате:
 // http://<АДРЕС_СЕРВЕРА>/update/<ТИП_МЕТРИКИ>/<ИМЯ_МЕТРИКИ>/<ЗНАЧЕНИЕ_МЕТРИКИ>
+//
+// Metric labels can be provided in query, e.g. "?label=host:web1".
 func (h *Handlers) Update(c *gin.Context) {
 	mType, mName, mValue := c.Param("type"), c.Param("name"), c.Param("value")
 	mType = strings.TrimSpace(mType)
@@ -65,13 +70,19 @@ func (h *Handlers) Update(c *gin.Context) {
 		return
 	}
 
+	key, err := seriesKeyFromQuery(c, mName)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// split handlers for [/gauge, /counter] endpoints
 	switch mType {
 	case model.MetricTypeGauge:
-		h.updateGauge(c, mName, mValue)
+		h.updateGauge(c, key, mValue)
 		return
 	case model.MetricTypeCounter:
-		h.updateCounter(c, mName, mValue)
+		h.updateCounter(c, key, mValue)
 		return
 	default:
 		http.Error(c.Writer, ErrMsgWrongMetricType, http.StatusBadRequest)
@@ -79,6 +90,17 @@ func (h *Handlers) Update(c *gin.Context) {
 	}
 }
 
+// seriesKeyFromQuery builds series key from metric name and labels provided
+// in request query (see LabelQueryParam).
+func seriesKeyFromQuery(c *gin.Context, name string) (string, error) {
+	labels, err := ParseLabels(c.QueryArray(LabelQueryParam))
+	if err != nil {
+		return "", err
+	}
+
+	return model.SeriesKey(name, labels)
+}
+
 // updateGauge updates Gauge metric data.
 func (h *Handlers) updateGauge(c *gin.Context, name, value string) {
 	var gauge model.Gauge
@@ -88,14 +110,15 @@ func (h *Handlers) updateGauge(c *gin.Context, name, value string) {
 		return
 	}
 
-	err = h.storage.Gauges().Set(name, gauge)
+	err = h.storage.Gauges().Set(c.Request.Context(), name, gauge)
 	if err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
 		return
 	}
+	h.hub.PublishBatch(model.Batch{Gauges: []model.MetricGauge{{Name: name, Value: gauge}}})
 
-	if err = h.dumper.Dump(); err != nil {
+	if err = h.dumper.Dump(c.Request.Context()); err != nil {
 		logger.Log.Error(ErrMsgDumperFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgDumperFail, http.StatusInternalServerError)
 		return
@@ -118,14 +141,15 @@ func (h *Handlers) updateCounter(c *gin.Context, name, value string) {
 		return
 	}
 
-	err = h.storage.Counters().Set(name, counter)
+	err = h.storage.Counters().Set(c.Request.Context(), name, counter)
 	if err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
 		return
 	}
+	h.hub.PublishBatch(model.Batch{Counters: []model.MetricCounter{{Name: name, Value: counter}}})
 
-	if err = h.dumper.Dump(); err != nil {
+	if err = h.dumper.Dump(c.Request.Context()); err != nil {
 		logger.Log.Error(ErrMsgDumperFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgDumperFail, http.StatusInternalServerError)
 		return
@@ -161,12 +185,24 @@ func (h *Handlers) UpdateMetricByJSON(c *gin.Context) {
 		return
 	}
 
+	key, err := model.SeriesKey(req.ID, req.Labels)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	switch req.MType {
 	case model.MetricTypeGauge:
-		h.updateGaugeFromMetrics(c, req)
+		h.updateGaugeFromMetrics(c, key, req)
 		return
 	case model.MetricTypeCounter:
-		h.updateCounterFromMetrics(c, req)
+		h.updateCounterFromMetrics(c, key, req)
+		return
+	case model.MetricTypeHistogram:
+		h.updateHistogramFromMetrics(c, key, req)
+		return
+	case model.MetricTypeSummary:
+		h.updateSummaryFromMetrics(c, key, req)
 		return
 	default:
 		http.Error(c.Writer, ErrMsgWrongMetricType, http.StatusBadRequest)
@@ -174,21 +210,22 @@ func (h *Handlers) UpdateMetricByJSON(c *gin.Context) {
 	}
 }
 
-func (h *Handlers) updateGaugeFromMetrics(c *gin.Context, m model.Metrics) {
+func (h *Handlers) updateGaugeFromMetrics(c *gin.Context, key string, m model.Metrics) {
 	if m.Value == nil {
 		http.Error(c.Writer, ErrMsgWrongMetricValue, http.StatusNotFound)
 		return
 	}
 
-	err := h.storage.Gauges().Set(m.ID, model.Gauge(*m.Value))
+	err := h.storage.Gauges().Set(c.Request.Context(), key, model.Gauge(*m.Value))
 	if err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
 		return
 	}
+	h.hub.PublishBatch(model.Batch{Gauges: []model.MetricGauge{{Name: key, Value: model.Gauge(*m.Value)}}})
 	m.Delta = nil
 
-	if err = h.dumper.Dump(); err != nil {
+	if err = h.dumper.Dump(c.Request.Context()); err != nil {
 		logger.Log.Error(ErrMsgDumperFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgDumperFail, http.StatusInternalServerError)
 		return
@@ -197,7 +234,7 @@ func (h *Handlers) updateGaugeFromMetrics(c *gin.Context, m model.Metrics) {
 	c.JSON(http.StatusOK, m)
 }
 
-func (h *Handlers) updateCounterFromMetrics(c *gin.Context, m model.Metrics) {
+func (h *Handlers) updateCounterFromMetrics(c *gin.Context, key string, m model.Metrics) {
 	if m.Delta == nil {
 		http.Error(c.Writer, ErrMsgWrongMetricValue, http.StatusNotFound)
 		return
@@ -208,20 +245,21 @@ func (h *Handlers) updateCounterFromMetrics(c *gin.Context, m model.Metrics) {
 		return
 	}
 
-	err := h.storage.Counters().Set(m.ID, model.Counter(*m.Delta))
+	err := h.storage.Counters().Set(c.Request.Context(), key, model.Counter(*m.Delta))
 	if err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
 		return
 	}
+	h.hub.PublishBatch(model.Batch{Counters: []model.MetricCounter{{Name: key, Value: model.Counter(*m.Delta)}}})
 
-	if err = h.dumper.Dump(); err != nil {
+	if err = h.dumper.Dump(c.Request.Context()); err != nil {
 		logger.Log.Error(ErrMsgDumperFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgDumperFail, http.StatusInternalServerError)
 		return
 	}
 
-	counter, err := h.storage.Counters().Get(m.ID)
+	counter, err := h.storage.Counters().Get(c.Request.Context(), key)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			logger.Log.Error(ErrMsgNothingFound + " after update attempt")
@@ -240,8 +278,77 @@ func (h *Handlers) updateCounterFromMetrics(c *gin.Context, m model.Metrics) {
 	c.JSON(http.StatusOK, m)
 }
 
+// updateHistogramFromMetrics merges observations into the stored histogram.
+// Merged histogram is sent back in response.
+func (h *Handlers) updateHistogramFromMetrics(c *gin.Context, key string, m model.Metrics) {
+	if m.Histogram == nil {
+		http.Error(c.Writer, ErrMsgWrongMetricValue, http.StatusNotFound)
+		return
+	}
+
+	if err := m.Histogram.Validate(); err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.storage.Histograms().Set(c.Request.Context(), key, *m.Histogram)
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+	h.hub.PublishBatch(model.Batch{Histograms: []model.MetricHistogram{{Name: key, Value: *m.Histogram}}})
+
+	if err = h.dumper.Dump(c.Request.Context()); err != nil {
+		logger.Log.Error(ErrMsgDumperFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgDumperFail, http.StatusInternalServerError)
+		return
+	}
+
+	histogram, err := h.storage.Histograms().Get(c.Request.Context(), key)
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail+" after update attempt", zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+
+	m.Histogram = &histogram
+	m.Delta, m.Value, m.Summary = nil, nil, nil
+
+	c.JSON(http.StatusOK, m)
+}
+
+func (h *Handlers) updateSummaryFromMetrics(c *gin.Context, key string, m model.Metrics) {
+	if m.Summary == nil {
+		http.Error(c.Writer, ErrMsgWrongMetricValue, http.StatusNotFound)
+		return
+	}
+
+	if err := m.Summary.Validate(); err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.storage.Summaries().Set(c.Request.Context(), key, *m.Summary)
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+	h.hub.PublishBatch(model.Batch{Summaries: []model.MetricSummary{{Name: key, Value: *m.Summary}}})
+	m.Delta, m.Value, m.Histogram = nil, nil, nil
+
+	if err = h.dumper.Dump(c.Request.Context()); err != nil {
+		logger.Log.Error(ErrMsgDumperFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgDumperFail, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, m)
+}
+
 // prepareBatchedMetrics prepares a batch of metrics.
-func prepareBatchedMetrics(metrics []model.Metrics) (gs []model.MetricGauge, cs []model.MetricCounter, err error) {
+func prepareBatchedMetrics(metrics []model.Metrics) (batch model.Batch, err error) {
 	if len(metrics) == 0 {
 		return
 	}
@@ -251,46 +358,85 @@ func prepareBatchedMetrics(metrics []model.Metrics) (gs []model.MetricGauge, cs
 		metric.MType = strings.TrimSpace(metric.MType)
 
 		if metric.MType == "" {
-			return nil, nil, fmt.Errorf("%w: \"%s\"", ErrWrongMetricType, metric.MType)
+			return batch, fmt.Errorf("%w: \"%s\"", ErrWrongMetricType, metric.MType)
 		}
 		if metric.ID == "" {
-			return nil, nil, ErrEmptyMetricName
+			return batch, ErrEmptyMetricName
+		}
+
+		key, err := model.SeriesKey(metric.ID, metric.Labels)
+		if err != nil {
+			return batch, err
 		}
 
 		switch metric.MType {
 		case model.MetricTypeGauge:
 			if metric.Value == nil {
-				return nil, nil, ErrWrongMetricValue
+				return batch, ErrWrongMetricValue
 			}
 
-			gs = append(gs, model.MetricGauge{
-				Name:  metric.ID,
+			batch.Gauges = append(batch.Gauges, model.MetricGauge{
+				Name:  key,
 				Value: model.Gauge(*metric.Value),
 			})
 		case model.MetricTypeCounter:
 			if metric.Delta == nil {
-				return nil, nil, ErrWrongMetricValue
+				return batch, ErrWrongMetricValue
 			}
 
 			if *metric.Delta < 0 {
-				return nil, nil, ErrNegativeCounter
+				return batch, ErrNegativeCounter
 			}
 
-			cs = append(cs, model.MetricCounter{
-				Name:  metric.ID,
+			batch.Counters = append(batch.Counters, model.MetricCounter{
+				Name:  key,
 				Value: model.Counter(*metric.Delta),
 			})
+		case model.MetricTypeHistogram:
+			if metric.Histogram == nil {
+				return batch, ErrWrongMetricValue
+			}
+
+			if err = metric.Histogram.Validate(); err != nil {
+				return batch, err
+			}
+
+			batch.Histograms = append(batch.Histograms, model.MetricHistogram{
+				Name:  key,
+				Value: *metric.Histogram,
+			})
+		case model.MetricTypeSummary:
+			if metric.Summary == nil {
+				return batch, ErrWrongMetricValue
+			}
+
+			if err = metric.Summary.Validate(); err != nil {
+				return batch, err
+			}
+
+			batch.Summaries = append(batch.Summaries, model.MetricSummary{
+				Name:  key,
+				Value: *metric.Summary,
+			})
 		default:
-			return nil, nil, fmt.Errorf("%w: \"%s\"", ErrWrongMetricType, metric.MType)
+			return batch, fmt.Errorf("%w: \"%s\"", ErrWrongMetricType, metric.MType)
 		}
 	}
 
 	return
 }
 
+// IdempotencyKeyHeader holds idempotency key of the batch sent to
+// POST /updates/. Batch with already applied key is not applied again, so
+// the agent can safely retry requests which response has been lost.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 // UpdateBatch is a handler to update metrics in batch (several at a time).
 // A slice of metrics structs is expected in request body.
 //
+// When IdempotencyKeyHeader is set, retried batch is skipped and success is
+// reported.
+//
 // > Добавьте новый хендлер POST /updates/, принимающий в теле запроса
 // множество метрик в формате: []Metrics (списка метрик).
 func (h *Handlers) UpdateBatch(c *gin.Context) {
@@ -301,26 +447,39 @@ func (h *Handlers) UpdateBatch(c *gin.Context) {
 		return
 	}
 
-	gauges, counters, err := prepareBatchedMetrics(req)
+	batch, err := prepareBatchedMetrics(req)
 	if err != nil {
 		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
 		return
 	}
-	logger.Log.Info("batch parsed", zap.Any("gauges", gauges), zap.Any("counters", counters))
 
-	if err = h.storage.Gauges().BatchUpdate(gauges); err != nil {
-		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
-		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+	batch.IdempotencyKey = c.GetHeader(IdempotencyKeyHeader)
+	if err = model.ValidateIdempotencyKey(batch.IdempotencyKey); err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
 		return
 	}
+	logger.Log.Info("batch parsed",
+		zap.Any("gauges", batch.Gauges),
+		zap.Any("counters", batch.Counters),
+		zap.Any("histograms", batch.Histograms),
+		zap.Any("summaries", batch.Summaries),
+	)
+
+	if err = h.storage.BatchUpdate(c.Request.Context(), batch); err != nil {
+		if errors.Is(err, storage.ErrDuplicateBatch) {
+			// retry of already applied batch, nothing to do
+			logger.Log.Info("duplicate batch skipped", zap.String("idempotency_key", batch.IdempotencyKey))
+			c.Status(http.StatusOK)
+			return
+		}
 
-	if err = h.storage.Counters().BatchUpdate(counters); err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
 		return
 	}
+	h.hub.PublishBatch(batch)
 
-	if err = h.dumper.Dump(); err != nil {
+	if err = h.dumper.Dump(c.Request.Context()); err != nil {
 		logger.Log.Error(ErrMsgDumperFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgDumperFail, http.StatusInternalServerError)
 		return
@@ -335,6 +494,8 @@ func (h *Handlers) UpdateBatch(c *gin.Context) {
 // GET http://<АДРЕС_СЕРВЕРА>/value/<ТИП_МЕТРИКИ>/<ИМЯ_МЕТРИКИ>
 // он возвращал текущее значение метрики в текстовом виде со статусом http.StatusOK.
 // При попытке запроса неизвестной метрики сервер должен возвращать http.StatusNotFound.
+//
+// Metric labels can be provided in query, e.g. "?label=host:web1".
 func (h *Handlers) GetMetricByName(c *gin.Context) {
 	mType, mName := c.Param("type"), c.Param("name")
 	mType = strings.TrimSpace(mType)
@@ -345,16 +506,23 @@ func (h *Handlers) GetMetricByName(c *gin.Context) {
 		return
 	}
 
-	var (
-		value interface{}
-		err   error
-	)
+	key, err := seriesKeyFromQuery(c, mName)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var value interface{}
 
 	switch mType {
 	case model.MetricTypeGauge:
-		value, err = h.storage.Gauges().Get(mName)
+		value, err = h.storage.Gauges().Get(c.Request.Context(), key)
 	case model.MetricTypeCounter:
-		value, err = h.storage.Counters().Get(mName)
+		value, err = h.storage.Counters().Get(c.Request.Context(), key)
+	case model.MetricTypeHistogram:
+		value, err = h.storage.Histograms().Get(c.Request.Context(), key)
+	case model.MetricTypeSummary:
+		value, err = h.storage.Summaries().Get(c.Request.Context(), key)
 	default:
 		http.Error(c.Writer, ErrMsgWrongMetricType, http.StatusBadRequest)
 		return
@@ -371,6 +539,13 @@ func (h *Handlers) GetMetricByName(c *gin.Context) {
 		return
 	}
 
+	// distributions can't be represented by a single number
+	switch value.(type) {
+	case model.Histogram, model.Summary:
+		c.JSON(http.StatusOK, value)
+		return
+	}
+
 	c.String(http.StatusOK, "%v", value)
 }
 
@@ -394,26 +569,39 @@ func (h *Handlers) GetMetricByJSON(c *gin.Context) {
 	req.MType = strings.TrimSpace(req.MType)
 	req.Delta = nil
 	req.Value = nil
+	req.Histogram = nil
+	req.Summary = nil
 
 	if req.ID == "" {
 		http.Error(c.Writer, ErrMsgEmptyMetricName, http.StatusBadRequest)
 		return
 	}
 
-	var (
-		value interface{}
-		err   error
-	)
+	key, err := model.SeriesKey(req.ID, req.Labels)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var value interface{}
 
 	switch req.MType {
 	case model.MetricTypeGauge:
-		value, err = h.storage.Gauges().Get(req.ID)
+		value, err = h.storage.Gauges().Get(c.Request.Context(), key)
 		f := float64(value.(model.Gauge))
 		req.Value = &f
 	case model.MetricTypeCounter:
-		value, err = h.storage.Counters().Get(req.ID)
+		value, err = h.storage.Counters().Get(c.Request.Context(), key)
 		d := int64(value.(model.Counter))
 		req.Delta = &d // автотесты требуют, чтобы counter отдавался в .Delta
+	case model.MetricTypeHistogram:
+		var histogram model.Histogram
+		histogram, err = h.storage.Histograms().Get(c.Request.Context(), key)
+		req.Histogram = &histogram
+	case model.MetricTypeSummary:
+		var summary model.Summary
+		summary, err = h.storage.Summaries().Get(c.Request.Context(), key)
+		req.Summary = &summary
 	default:
 		http.Error(c.Writer, ErrMsgWrongMetricType, http.StatusBadRequest)
 		return
@@ -433,32 +621,125 @@ func (h *Handlers) GetMetricByJSON(c *gin.Context) {
 	c.JSON(http.StatusOK, req)
 }
 
+type historyResponse struct {
+	ID     string       `json:"id"`
+	MType  string       `json:"type"`
+	Labels model.Labels `json:"labels,omitempty"`
+	Points any          `json:"points"`
+}
+
+// GetHistory is a handler that returns values of the metric recorded within
+// requested time range.
+//
+// GET /history/:type/:name?from=&to=&step=&label=
+//
+// from and to are unix timestamps (in seconds) or RFC3339 strings, step is
+// a duration string (e.g. "30s") used to downsample the result.
+func (h *Handlers) GetHistory(c *gin.Context) {
+	mType, mName := c.Param("type"), c.Param("name")
+	mType = strings.TrimSpace(mType)
+	mName = strings.TrimSpace(mName)
+
+	if mName == "" {
+		http.Error(c.Writer, ErrMsgEmptyMetricName, http.StatusBadRequest)
+		return
+	}
+
+	key, err := seriesKeyFromQuery(c, mName)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, to, step, err := ParseHistoryRange(c.Query("from"), c.Query("to"), c.Query("step"))
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := historyResponse{
+		ID:    mName,
+		MType: mType,
+	}
+
+	switch mType {
+	case model.MetricTypeGauge:
+		var records []model.GaugeRecord
+		records, err = h.storage.Gauges().History(c.Request.Context(), key, from, to)
+		resp.Points = Downsample(records, step, func(r model.GaugeRecord) time.Time {
+			return r.Timestamp
+		})
+	case model.MetricTypeCounter:
+		var records []model.CounterRecord
+		records, err = h.storage.Counters().History(c.Request.Context(), key, from, to)
+		resp.Points = Downsample(records, step, func(r model.CounterRecord) time.Time {
+			return r.Timestamp
+		})
+	default:
+		http.Error(c.Writer, ErrMsgWrongMetricType, http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
 type metricsResponse struct {
-	Gauges   map[string]model.Gauge   `json:"gauges"`
-	Counters map[string]model.Counter `json:"counters"`
+	Gauges     map[string]model.Gauge     `json:"gauges"`
+	Counters   map[string]model.Counter   `json:"counters"`
+	Histograms map[string]model.Histogram `json:"histograms"`
+	Summaries  map[string]model.Summary   `json:"summaries"`
 }
 
 // GetAllMetrics - just for debugging, returns list of all metrics.
+// Metrics can be filtered by labels, e.g. "/all?label=host:web1".
 func (h *Handlers) GetAllMetrics(c *gin.Context) {
-	var (
-		metrics metricsResponse
-		err     error
-	)
+	filter, err := ParseLabels(c.QueryArray(LabelQueryParam))
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var metrics metricsResponse
+
+	metrics.Gauges, err = h.storage.Gauges().GetAll(c.Request.Context())
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+
+	metrics.Counters, err = h.storage.Counters().GetAll(c.Request.Context())
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
 
-	metrics.Gauges, err = h.storage.Gauges().GetAll()
+	metrics.Histograms, err = h.storage.Histograms().GetAll(c.Request.Context())
 	if err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
 		return
 	}
 
-	metrics.Counters, err = h.storage.Counters().GetAll()
+	metrics.Summaries, err = h.storage.Summaries().GetAll(c.Request.Context())
 	if err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
 		return
 	}
 
+	metrics.Gauges = filterByLabels(metrics.Gauges, filter)
+	metrics.Counters = filterByLabels(metrics.Counters, filter)
+	metrics.Histograms = filterByLabels(metrics.Histograms, filter)
+	metrics.Summaries = filterByLabels(metrics.Summaries, filter)
+
 	resp, err := json.Marshal(metrics)
 	if err != nil {
 		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
@@ -469,6 +750,55 @@ func (h *Handlers) GetAllMetrics(c *gin.Context) {
 	_, _ = c.Writer.Write(resp)
 }
 
+// GetPrometheusMetrics renders all metrics in Prometheus text exposition
+// format to be scraped by Prometheus. OpenMetrics format is used when it is
+// requested by the Accept header.
+//
+// GET /metrics
+func (h *Handlers) GetPrometheusMetrics(c *gin.Context) {
+	e := NewExposition()
+
+	gauges, err := h.storage.Gauges().GetAll(c.Request.Context())
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+	e.AddGauges(gauges)
+
+	counters, err := h.storage.Counters().GetAll(c.Request.Context())
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+	e.AddCounters(counters)
+
+	histograms, err := h.storage.Histograms().GetAll(c.Request.Context())
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+	e.AddHistograms(histograms)
+
+	summaries, err := h.storage.Summaries().GetAll(c.Request.Context())
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+	e.AddSummaries(summaries)
+
+	contentType := ContentTypePrometheus
+	openMetrics := AcceptsOpenMetrics(c.GetHeader("Accept"))
+	if openMetrics {
+		contentType = ContentTypeOpenMetrics
+	}
+
+	c.Data(http.StatusOK, contentType, e.Render(openMetrics))
+}
+
 // pageTmpl is an html template to be rendered on Index page.
 //
 // TODO: might move to file
@@ -495,13 +825,41 @@ var pageTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
 		<li>{{$key}}: {{$value}}</li>
 	{{end}}
 	</ul>
+
+	<h2>Histograms</h2>
+	<ul>
+	{{range $key, $value := .Histograms}}
+		<li>{{$key}}: count={{$value.Count}} sum={{$value.Sum}}
+			<ul>
+			{{range $value.Buckets}}
+				<li>le={{.UpperBound}}: {{.Count}}</li>
+			{{end}}
+			</ul>
+		</li>
+	{{end}}
+	</ul>
+
+	<h2>Summaries</h2>
+	<ul>
+	{{range $key, $value := .Summaries}}
+		<li>{{$key}}: count={{$value.Count}} sum={{$value.Sum}}
+			<ul>
+			{{range $value.Quantiles}}
+				<li>quantile={{.Quantile}}: {{.Value}}</li>
+			{{end}}
+			</ul>
+		</li>
+	{{end}}
+	</ul>
 </body>
 </html>
 `))
 
 type indexPageData struct {
-	Gauges   map[string]model.Gauge
-	Counters map[string]model.Counter
+	Gauges     map[string]model.Gauge
+	Counters   map[string]model.Counter
+	Histograms map[string]model.Histogram
+	Summaries  map[string]model.Summary
 }
 
 // PageIndex is a handler to show html page with a list of all gathered metrics.
@@ -511,14 +869,28 @@ func (h *Handlers) PageIndex(c *gin.Context) {
 		err   error
 	)
 
-	pData.Gauges, err = h.storage.Gauges().GetAll()
+	pData.Gauges, err = h.storage.Gauges().GetAll(c.Request.Context())
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+
+	pData.Counters, err = h.storage.Counters().GetAll(c.Request.Context())
+	if err != nil {
+		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
+		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
+		return
+	}
+
+	pData.Histograms, err = h.storage.Histograms().GetAll(c.Request.Context())
 	if err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
 		return
 	}
 
-	pData.Counters, err = h.storage.Counters().GetAll()
+	pData.Summaries, err = h.storage.Summaries().GetAll(c.Request.Context())
 	if err != nil {
 		logger.Log.Error(ErrMsgStorageFail, zap.Error(err))
 		http.Error(c.Writer, ErrMsgStorageFail, http.StatusInternalServerError)
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -37,11 +38,11 @@ func prepareTestMetrics(s storage.Storage) ([]model.MetricCounter, []model.Metri
 		})
 	}
 
-	if err := s.Counters().BatchUpdate(counters); err != nil {
+	if err := s.Counters().BatchUpdate(context.Background(), counters); err != nil {
 		return nil, nil, err
 	}
 
-	if err := s.Gauges().BatchUpdate(gauges); err != nil {
+	if err := s.Gauges().BatchUpdate(context.Background(), gauges); err != nil {
 		return nil, nil, err
 	}
 
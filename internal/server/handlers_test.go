@@ -1,14 +1,17 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/server/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandlers_PingStorage(t *testing.T) {
@@ -249,3 +252,235 @@ func TestHandlers_UpdateCounter(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlers_GetHistory(t *testing.T) {
+	server := New(config.NewTesting())
+
+	for _, v := range []string{"1.5", "2.5", "3.5"} {
+		r := httptest.NewRequest(http.MethodPost, "/update/gauge/HistoryGauge/"+v, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		wantCode   int
+		wantPoints int
+	}{
+		{
+			name:       "correct",
+			url:        "/history/gauge/HistoryGauge",
+			wantCode:   http.StatusOK,
+			wantPoints: 3,
+		},
+		{
+			name:       "downsampled",
+			url:        "/history/gauge/HistoryGauge?step=1h",
+			wantCode:   http.StatusOK,
+			wantPoints: 1,
+		},
+		{
+			name:       "unknown-metric",
+			url:        "/history/counter/HistoryGauge",
+			wantCode:   http.StatusOK,
+			wantPoints: 0,
+		},
+		{
+			name:     "incorrect-metric-type",
+			url:      "/history/broken-type/HistoryGauge",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "incorrect-range",
+			url:      "/history/gauge/HistoryGauge?from=1704067200&to=1704060000",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, r)
+
+			require.Equalf(t, tc.wantCode, w.Code, "Код ответа не совпадает с ожидаемым. URL: %s", tc.url)
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Points []model.GaugeRecord `json:"points"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Len(t, resp.Points, tc.wantPoints)
+		})
+	}
+}
+
+func TestHandlers_Labels(t *testing.T) {
+	server := New(config.NewTesting())
+
+	batch := `[
+		{"id": "LabeledGauge", "type": "gauge", "value": 1.5, "labels": {"host": "web1"}},
+		{"id": "LabeledGauge", "type": "gauge", "value": 2.5, "labels": {"host": "web2"}},
+		{"id": "LabeledGauge", "type": "gauge", "value": 3.5}
+	]`
+
+	r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(batch))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	t.Run("value by labels", func(t *testing.T) {
+		for url, want := range map[string]string{
+			"/value/gauge/LabeledGauge?label=host:web1": "1.5",
+			"/value/gauge/LabeledGauge?label=host:web2": "2.5",
+			"/value/gauge/LabeledGauge":                 "3.5",
+		} {
+			r := httptest.NewRequest(http.MethodGet, url, nil)
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, r)
+
+			require.Equal(t, http.StatusOK, w.Code, url)
+			assert.Equal(t, want, w.Body.String(), url)
+		}
+	})
+
+	t.Run("unknown labels", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/value/gauge/LabeledGauge?label=host:web3", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("bad labels", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/value/gauge/LabeledGauge?label=host", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("all filtered", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/all?label=host:web2", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp metricsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, map[string]model.Gauge{`LabeledGauge{host="web2"}`: 2.5}, resp.Gauges)
+		assert.Empty(t, resp.Counters)
+	})
+}
+
+func TestHandlers_Distributions(t *testing.T) {
+	server := New(config.NewTesting())
+
+	batch := `[
+		{"id": "Latency", "type": "histogram", "histogram": {"buckets": [{"le": 0.1, "count": 1}, {"le": 1, "count": 2}], "sum": 0.6, "count": 3}},
+		{"id": "Latency", "type": "histogram", "histogram": {"buckets": [{"le": 0.1, "count": 0}, {"le": 1, "count": 1}], "sum": 0.5, "count": 1}},
+		{"id": "Size", "type": "summary", "summary": {"quantiles": [{"quantile": 0.5, "value": 10}], "sum": 30, "count": 3}}
+	]`
+
+	r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(batch))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	t.Run("histogram merged", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/value/histogram/Latency", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var got model.Histogram
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, model.Histogram{
+			Buckets: []model.Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 3}},
+			Sum:     1.1,
+			Count:   4,
+		}, got)
+	})
+
+	t.Run("summary by json", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(`{"id": "Size", "type": "summary"}`))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var got model.Metrics
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		require.NotNil(t, got.Summary)
+		assert.Equal(t, []model.Quantile{{Quantile: 0.5, Value: 10}}, got.Summary.Quantiles)
+	})
+
+	t.Run("bad histogram", func(t *testing.T) {
+		body := `{"id": "Latency", "type": "histogram", "histogram": {"buckets": [{"le": 1, "count": 2}, {"le": 0.1, "count": 2}], "count": 2}}`
+		r := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestHandlers_UpdateBatchIdempotency(t *testing.T) {
+	server := New(config.NewTesting())
+
+	send := func(key string) int {
+		batch := `[{"id": "PollCount", "type": "counter", "delta": 5}]`
+		r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(batch))
+		if key != "" {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// retry of the first batch must not be counted again
+	require.Equal(t, http.StatusOK, send("agent-1"))
+	require.Equal(t, http.StatusOK, send("agent-1"))
+	require.Equal(t, http.StatusOK, send("agent-2"))
+	require.Equal(t, http.StatusOK, send(""))
+	require.Equal(t, http.StatusBadRequest, send(strings.Repeat("k", model.MaxIdempotencyKeyLength+1)))
+
+	r := httptest.NewRequest(http.MethodGet, "/value/counter/PollCount", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "15", w.Body.String())
+}
+
+func TestHandlers_GetPrometheusMetrics(t *testing.T) {
+	server := New(config.NewTesting())
+
+	r := httptest.NewRequest(http.MethodPost, "/update/counter/PollCount/5?label=host:web1", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	t.Run("prometheus", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, ContentTypePrometheus, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "# TYPE PollCount counter\nPollCount{host=\"web1\"} 5\n")
+	})
+
+	t.Run("openmetrics", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, ContentTypeOpenMetrics, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "PollCount_total{host=\"web1\"} 5\n")
+		assert.True(t, strings.HasSuffix(w.Body.String(), "# EOF\n"))
+	})
+}
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"fmt"
 	"path"
 	"strings"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
 )
 
 // ShiftPath splits off the first component of p, which will be cleaned of
@@ -17,3 +20,45 @@ func ShiftPath(p string) (head, tail string) {
 	}
 	return p[1:i], p[i:]
 }
+
+// LabelQueryParam is a query parameter used to specify metric labels,
+// e.g. "?label=host:web1&label=env:prod".
+const LabelQueryParam = "label"
+
+// ParseLabels parses labels from query values provided in "name:value" form.
+// Nil is returned when values are empty.
+func ParseLabels(values []string) (model.Labels, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	labels := make(model.Labels, len(values))
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: \"%s\"", ErrWrongLabel, v)
+		}
+		labels[strings.TrimSpace(name)] = value
+	}
+
+	return labels, labels.Validate()
+}
+
+// filterByLabels returns metrics whose series labels match filter.
+// Series keys that can't be parsed are skipped.
+func filterByLabels[V any](metrics map[string]V, filter model.Labels) map[string]V {
+	if len(filter) == 0 {
+		return metrics
+	}
+
+	res := make(map[string]V)
+	for key, value := range metrics {
+		_, labels, err := model.ParseSeriesKey(key)
+		if err != nil || !labels.Match(filter) {
+			continue
+		}
+		res[key] = value
+	}
+
+	return res
+}
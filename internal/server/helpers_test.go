@@ -1,6 +1,13 @@
 package server
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 func TestHelpers_ShiftPath(t *testing.T) {
 	tests := []struct {
@@ -53,3 +60,59 @@ func TestHelpers_ShiftPath(t *testing.T) {
 		})
 	}
 }
+
+func TestHistory_Downsample(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	records := []model.GaugeRecord{
+		{Timestamp: base, Value: 1},
+		{Timestamp: base.Add(10 * time.Second), Value: 2},
+		{Timestamp: base.Add(40 * time.Second), Value: 3},
+		{Timestamp: base.Add(70 * time.Second), Value: 4},
+		{Timestamp: base.Add(80 * time.Second), Value: 5},
+	}
+
+	timestamp := func(r model.GaugeRecord) time.Time {
+		return r.Timestamp
+	}
+
+	got := Downsample(records, 30*time.Second, timestamp)
+	want := []model.Gauge{2, 3, 5}
+
+	require.Len(t, got, len(want))
+	for i := range want {
+		assert.Equal(t, want[i], got[i].Value)
+	}
+
+	assert.Equal(t, records, Downsample(records, 0, timestamp), "records must be kept as is when step is 0")
+}
+
+func TestHistory_ParseHistoryRange(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		from, to, step, err := ParseHistoryRange("", "", "")
+		require.NoError(t, err)
+		assert.Equal(t, DefaultHistoryRange, to.Sub(from))
+		assert.Zero(t, step)
+	})
+
+	t.Run("unix and rfc3339", func(t *testing.T) {
+		from, to, step, err := ParseHistoryRange("1704067200", "2024-01-01T01:00:00Z", "1m")
+		require.NoError(t, err)
+		assert.True(t, from.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+		assert.True(t, to.Equal(time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)))
+		assert.Equal(t, time.Minute, step)
+	})
+
+	t.Run("from after to", func(t *testing.T) {
+		_, _, _, err := ParseHistoryRange("1704067200", "1704060000", "")
+		require.ErrorIs(t, err, ErrWrongTimeRange)
+	})
+
+	t.Run("bad step", func(t *testing.T) {
+		_, _, _, err := ParseHistoryRange("", "", "-1s")
+		require.ErrorIs(t, err, ErrWrongHistoryStep)
+
+		_, _, _, err = ParseHistoryRange("", "", "bad")
+		require.ErrorIs(t, err, ErrWrongHistoryStep)
+	})
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHistoryRange is a time range used by history requests when
+// the range start is not specified.
+const DefaultHistoryRange = time.Hour
+
+// ParseHistoryRange parses time range parameters of the history request.
+//
+// from and to can be provided either as unix timestamp (in seconds) or as
+// RFC3339 string. When to is empty, current time is used. When from is empty,
+// DefaultHistoryRange before to is used. step is a duration string
+// (e.g. "30s"), empty step means no downsampling.
+func ParseHistoryRange(from, to, step string) (tFrom, tTo time.Time, dStep time.Duration, err error) {
+	tTo = time.Now()
+	if to = strings.TrimSpace(to); to != "" {
+		if tTo, err = parseHistoryTime(to); err != nil {
+			return tFrom, tTo, 0, fmt.Errorf("%w: to: %v", ErrWrongTimeRange, err)
+		}
+	}
+
+	tFrom = tTo.Add(-DefaultHistoryRange)
+	if from = strings.TrimSpace(from); from != "" {
+		if tFrom, err = parseHistoryTime(from); err != nil {
+			return tFrom, tTo, 0, fmt.Errorf("%w: from: %v", ErrWrongTimeRange, err)
+		}
+	}
+
+	if step = strings.TrimSpace(step); step != "" {
+		if dStep, err = time.ParseDuration(step); err != nil {
+			return tFrom, tTo, 0, fmt.Errorf("%w: %v", ErrWrongHistoryStep, err)
+		}
+	}
+
+	return tFrom, tTo, dStep, CheckHistoryRange(tFrom, tTo, dStep)
+}
+
+// CheckHistoryRange validates time range parameters of the history request.
+func CheckHistoryRange(from, to time.Time, step time.Duration) error {
+	if from.After(to) {
+		return fmt.Errorf("%w: from must not be after to", ErrWrongTimeRange)
+	}
+
+	if step < 0 {
+		return fmt.Errorf("%w: must not be negative", ErrWrongHistoryStep)
+	}
+
+	return nil
+}
+
+// parseHistoryTime parses time from unix timestamp (in seconds) or from
+// RFC3339 string.
+func parseHistoryTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// Downsample reduces records, ordered by time, to at most one record per step
+// interval. The last record of every interval is kept. Records are returned as
+// is when step is not positive.
+func Downsample[T any](records []T, step time.Duration, timestamp func(T) time.Time) []T {
+	if step <= 0 || len(records) == 0 {
+		return records
+	}
+
+	res := make([]T, 0, len(records))
+
+	var bucket, prev time.Time
+	for i, r := range records {
+		bucket = timestamp(r).Truncate(step)
+		if i > 0 && bucket.Equal(prev) {
+			res[len(res)-1] = r
+			continue
+		}
+
+		res = append(res, r)
+		prev = bucket
+	}
+
+	return res
+}
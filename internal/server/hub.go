@@ -0,0 +1,228 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// DefaultSubscriptionBuffer is a number of events subscription buffers for a
+// subscriber to catch up.
+const DefaultSubscriptionBuffer = 256
+
+// Event is a metric update applied to storage: gauge value, counter
+// increment, histogram or summary as they were received.
+type Event struct {
+	Time   time.Time
+	Metric model.Metrics
+}
+
+// EventsFromBatch converts batch to events.
+func EventsFromBatch(batch model.Batch) []Event {
+	now := time.Now()
+	events := make([]Event, 0, batch.Len())
+
+	newEvent := func(key, mtype string) Event {
+		name, labels, err := model.ParseSeriesKey(key)
+		if err != nil {
+			name, labels = key, nil
+		}
+
+		return Event{
+			Time:   now,
+			Metric: model.Metrics{ID: name, MType: mtype, Labels: labels},
+		}
+	}
+
+	for _, g := range batch.Gauges {
+		e := newEvent(g.Name, model.MetricTypeGauge)
+		value := float64(g.Value)
+		e.Metric.Value = &value
+		events = append(events, e)
+	}
+
+	for _, c := range batch.Counters {
+		e := newEvent(c.Name, model.MetricTypeCounter)
+		delta := int64(c.Value)
+		e.Metric.Delta = &delta
+		events = append(events, e)
+	}
+
+	for _, h := range batch.Histograms {
+		e := newEvent(h.Name, model.MetricTypeHistogram)
+		histogram := h.Value
+		e.Metric.Histogram = &histogram
+		events = append(events, e)
+	}
+
+	for _, s := range batch.Summaries {
+		e := newEvent(s.Name, model.MetricTypeSummary)
+		summary := s.Value
+		e.Metric.Summary = &summary
+		events = append(events, e)
+	}
+
+	return events
+}
+
+// Filter selects events a subscriber is interested in.
+type Filter struct {
+	// Prefix of metric name, any name matches when empty.
+	Prefix string
+	// Types of metrics, any type matches when empty.
+	Types []string
+}
+
+func (f Filter) match(m model.Metrics) bool {
+	if !strings.HasPrefix(m.ID, f.Prefix) {
+		return false
+	}
+
+	if len(f.Types) == 0 {
+		return true
+	}
+
+	for _, t := range f.Types {
+		if t == m.MType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Hub is an in-process pub/sub of metric updates: handlers publish updates
+// after successful storage writes, subscribers (e.g. gRPC Watch streams)
+// receive them.
+//
+// Publishing never blocks: every subscription has a bounded buffer, events
+// published while the buffer is full are dropped for that subscription and
+// counted (see Subscription.Dropped), so a slow subscriber can't stall the
+// writers.
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub creates new Hub.
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives events matching its filter, see Hub.Subscribe.
+type Subscription struct {
+	hub     *Hub
+	filter  Filter
+	events  chan Event
+	dropped atomic.Uint64
+}
+
+// Subscribe subscribes to events matching filter, buffer is a number of
+// events buffered for the subscriber (DefaultSubscriptionBuffer is used when
+// zero). Subscription must be closed when not needed anymore.
+func (h *Hub) Subscribe(filter Filter, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultSubscriptionBuffer
+	}
+
+	s := &Subscription{
+		hub:    h,
+		filter: filter,
+		events: make(chan Event, buffer),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(s.events)
+		return s
+	}
+	h.subs[s] = struct{}{}
+
+	return s
+}
+
+// Publish delivers events to subscribers without blocking.
+func (h *Hub) Publish(events ...Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	h.publish(events)
+}
+
+// PublishBatch publishes metrics of the applied batch. It's called on every
+// update, so events aren't even built while there are no subscribers.
+func (h *Hub) PublishBatch(batch model.Batch) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.subs) == 0 {
+		return
+	}
+
+	h.publish(EventsFromBatch(batch))
+}
+
+// publish delivers events to subscribers, must be called with mu held.
+func (h *Hub) publish(events []Event) {
+	for s := range h.subs {
+		for _, e := range events {
+			if !s.filter.match(e.Metric) {
+				continue
+			}
+
+			select {
+			case s.events <- e:
+			default:
+				s.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// Close closes all subscriptions, subscribing to the closed hub returns
+// closed subscription.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+// Events returns channel of events, it's closed when subscription or hub is
+// closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns number of events dropped since the previous call because
+// subscription buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+// Close unsubscribes from the hub.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.events)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/server/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+
+	all := hub.Subscribe(Filter{}, 0)
+	counters := hub.Subscribe(Filter{Prefix: "Poll", Types: []string{model.MetricTypeCounter}}, 0)
+	defer counters.Close()
+
+	key, err := model.SeriesKey("PollCount", model.Labels{"host": "web1"})
+	require.NoError(t, err)
+
+	hub.PublishBatch(model.Batch{
+		Gauges:   []model.MetricGauge{{Name: "PollGauge", Value: 1.5}},
+		Counters: []model.MetricCounter{{Name: key, Value: 2}, {Name: "Hits", Value: 3}},
+	})
+
+	require.Len(t, all.Events(), 3)
+	require.Len(t, counters.Events(), 1)
+
+	e := <-counters.Events()
+	assert.Equal(t, "PollCount", e.Metric.ID)
+	assert.Equal(t, model.MetricTypeCounter, e.Metric.MType)
+	assert.Equal(t, model.Labels{"host": "web1"}, e.Metric.Labels)
+	require.NotNil(t, e.Metric.Delta)
+	assert.EqualValues(t, 2, *e.Metric.Delta)
+
+	// closed subscription doesn't receive events anymore
+	all.Close()
+	all.Close()
+	hub.PublishBatch(model.Batch{Counters: []model.MetricCounter{{Name: "PollCount", Value: 1}}})
+	assert.Len(t, counters.Events(), 1)
+
+	// hub closes remaining subscriptions
+	hub.Close()
+	<-counters.Events()
+	_, ok := <-counters.Events()
+	assert.False(t, ok)
+
+	_, ok = <-hub.Subscribe(Filter{}, 0).Events()
+	assert.False(t, ok)
+}
+
+func TestHub_slowSubscriber(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(Filter{}, 2)
+	defer sub.Close()
+
+	// nobody reads the subscription, publisher isn't blocked
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			hub.PublishBatch(model.Batch{Counters: []model.MetricCounter{{Name: "PollCount", Value: model.Counter(i)}}})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publisher is blocked by slow subscriber")
+	}
+
+	// the oldest events are kept, the rest are counted as dropped
+	assert.EqualValues(t, 0, *(<-sub.Events()).Metric.Delta)
+	assert.EqualValues(t, 1, *(<-sub.Events()).Metric.Delta)
+	assert.EqualValues(t, 3, sub.Dropped())
+	assert.EqualValues(t, 0, sub.Dropped())
+}
+
+func TestHub_noSubscribers(t *testing.T) {
+	hub := NewHub()
+	batch := model.Batch{Counters: []model.MetricCounter{{Name: `PollCount{host="web1"}`, Value: 1}}}
+
+	// events aren't built when nobody listens
+	allocs := testing.AllocsPerRun(100, func() {
+		hub.PublishBatch(batch)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestHandlers_publish(t *testing.T) {
+	srv := New(config.NewTesting())
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	sub := srv.Hub.Subscribe(Filter{}, 0)
+	defer sub.Close()
+
+	resp, err := http.Post(ts.URL+"/update/gauge/Alloc/4.2", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// rejected update isn't published
+	resp, err = http.Post(ts.URL+"/update/counter/PollCount/-1", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	require.Len(t, sub.Events(), 1)
+	e := <-sub.Events()
+	assert.Equal(t, "Alloc", e.Metric.ID)
+	require.NotNil(t, e.Metric.Value)
+	assert.Equal(t, 4.2, *e.Metric.Value)
+}
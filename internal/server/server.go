@@ -3,7 +3,9 @@
 package server
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"net/http"
 	"time"
 
@@ -27,6 +29,7 @@ type server struct {
 
 	Storage storage.Storage
 	Dumper  *Dumper
+	Hub     *Hub
 }
 
 func New(cfg *config.Config) *server {
@@ -35,7 +38,8 @@ func New(cfg *config.Config) *server {
 	s.configureStorage(cfg)
 
 	s.Dumper = NewDumper(s.Storage, cfg)
-	s.handlers = NewHandlers(s.Storage, s.Dumper)
+	s.Hub = NewHub()
+	s.handlers = NewHandlers(s.Storage, s.Dumper, s.Hub)
 
 	// configure router
 	gin.SetMode(gin.ReleaseMode)    // make it not spam logs on startup
@@ -69,7 +73,9 @@ func New(cfg *config.Config) *server {
 	r.GET("/", s.handlers.PageIndex)
 	r.GET("/all", s.handlers.GetAllMetrics)
 	r.GET("/ping", s.handlers.PingStorage)
+	r.GET("/metrics", s.handlers.GetPrometheusMetrics)
 	r.GET("/value/:type/:name", s.handlers.GetMetricByName)
+	r.GET("/history/:type/:name", s.handlers.GetHistory)
 	r.POST("/value/", s.handlers.GetMetricByJSON)
 	r.POST("/update/", s.handlers.UpdateMetricByJSON)
 	r.POST("/update/:type/:name/:value", s.handlers.Update)
@@ -100,15 +106,55 @@ func (s *server) configureStorage(cfg *config.Config) {
 			logger.Log.Fatal("Can't configure storage", zap.Error(err))
 		}
 
-		if err = createTables(db); err != nil {
+		if err = postgres.Migrate(context.Background(), db, cfg.DatabaseVerifyOnly); err != nil {
 			logger.Log.Fatal("Can't configure storage", zap.Error(err))
 		}
 
-		s.Storage = postgres.New(db)
+		s.Storage = postgres.NewWithLimits(db, time.Millisecond*time.Duration(cfg.DatabaseQueryTimeout), cfg.HistorySize)
 		return
 	}
 
-	s.Storage = memstorage.New()
+	s.Storage = memstorage.NewWithHistorySize(cfg.HistorySize)
+}
+
+// RollbackMigrations rolls back cfg.DatabaseRollback last applied schema
+// migrations of the database configured by cfg. Schema version is logged
+// before and after the rollback.
+func RollbackMigrations(ctx context.Context, cfg *config.Config) error {
+	if cfg.DatabaseDSN == "" {
+		return errors.New("database isn't configured")
+	}
+
+	db, err := newDB(cfg.DatabaseDSN, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := postgres.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("Rolling back database migrations",
+		zap.Int("version", version),
+		zap.Int("steps", cfg.DatabaseRollback),
+	)
+
+	if err = m.Down(ctx, cfg.DatabaseRollback); err != nil {
+		return err
+	}
+
+	if version, err = m.Version(ctx); err != nil {
+		return err
+	}
+	logger.Log.Info("Database migrations rolled back", zap.Int("version", version))
+
+	return nil
 }
 
 // XXX: куда можно положить эту функцию?
@@ -152,34 +198,6 @@ func newDB(dsn string, withRetry bool) (db *sql.DB, err error) {
 	return db, nil
 }
 
-func createTables(db *sql.DB) (err error) {
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = tx.Rollback()
-	}()
-
-	if _, err = tx.Exec(`CREATE TABLE IF NOT EXISTS counters (
-		name varchar(500) NOT NULL PRIMARY KEY,
-		value bigint NOT NULL DEFAULT 0,
-		updated timestamptz NOT NULL DEFAULT now()
-	)`); err != nil {
-		return err
-	}
-
-	if _, err = tx.Exec(`CREATE TABLE IF NOT EXISTS gauges (
-		name varchar(500) NOT NULL PRIMARY KEY,
-		value double precision NOT NULL DEFAULT 0,
-		updated timestamptz NOT NULL DEFAULT now()
-	)`); err != nil {
-		return err
-	}
-
-	return tx.Commit()
-}
-
 func (s *server) configureDecryptor(privateKeyPath string) *encryptor.Decryptor {
 	decryptor, err := encryptor.NewDecryptor(privateKeyPath)
 	if err != nil {
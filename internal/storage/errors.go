@@ -4,3 +4,8 @@ import "errors"
 
 // ErrNotFound is a storage level custom error.
 var ErrNotFound = errors.New("nothing found")
+
+// ErrDuplicateBatch is returned by Storage.BatchUpdate when batch with the
+// same idempotency key has already been applied. Nothing is changed then, so
+// it's safe to treat this error as success.
+var ErrDuplicateBatch = errors.New("batch has already been applied")
@@ -1,7 +1,9 @@
 package memstorage
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
@@ -10,17 +12,26 @@ import (
 type CountersRepo struct {
 	mu       sync.RWMutex
 	counters map[string]model.Counter
+
+	// wal logs updates when set (see Storage.SetWAL)
+	wal *WAL
+
+	// history keeps last historySize values of every metric
+	history     map[string]*ring[model.CounterRecord]
+	historySize int
 }
 
-func NewCountersRepo() *CountersRepo {
+func NewCountersRepo(historySize int) *CountersRepo {
 	return &CountersRepo{
-		counters: make(map[string]model.Counter),
+		counters:    make(map[string]model.Counter),
+		history:     make(map[string]*ring[model.CounterRecord]),
+		historySize: historySize,
 	}
 }
 
 // Get finds metric by name. When requested metric doesn't exist
 // storage.ErrNotFound error is returned.
-func (s *CountersRepo) Get(name string) (model.Counter, error) {
+func (s *CountersRepo) Get(_ context.Context, name string) (model.Counter, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -32,7 +43,7 @@ func (s *CountersRepo) Get(name string) (model.Counter, error) {
 	return v, nil
 }
 
-func (s *CountersRepo) GetAll() (map[string]model.Counter, error) {
+func (s *CountersRepo) GetAll(_ context.Context) (map[string]model.Counter, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -45,29 +56,85 @@ func (s *CountersRepo) GetAll() (map[string]model.Counter, error) {
 	return res, nil
 }
 
-func (s *CountersRepo) Set(name string, value model.Counter) error {
+func (s *CountersRepo) Set(_ context.Context, name string, value model.Counter) error {
+	defer s.wal.hold()()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.counters[name] += value
+	if err := s.wal.append(walRecord{Op: walOpUpdate, Counters: []model.MetricCounter{{Name: name, Value: value}}}); err != nil {
+		return err
+	}
+
+	s.add(name, value, time.Now())
 	return nil
 }
 
-func (s *CountersRepo) Delete(name string) error {
+func (s *CountersRepo) Delete(_ context.Context, name string) error {
+	defer s.wal.hold()()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.wal.append(walRecord{Op: walOpDelete, Type: model.MetricTypeCounter, Name: name}); err != nil {
+		return err
+	}
+
 	delete(s.counters, name)
+	delete(s.history, name)
 	return nil
 }
 
-func (s *CountersRepo) BatchUpdate(counters []model.MetricCounter) error {
+func (s *CountersRepo) BatchUpdate(_ context.Context, counters []model.MetricCounter) error {
+	if len(counters) == 0 {
+		return nil
+	}
+
+	defer s.wal.hold()()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, c := range counters {
-		s.counters[c.Name] += c.Value
+	if err := s.wal.append(walRecord{Op: walOpUpdate, Counters: counters}); err != nil {
+		return err
 	}
 
+	s.batchUpdate(counters, time.Now())
+
 	return nil
 }
+
+// batchUpdate increments counters of the batch. Must be called with mu locked.
+func (s *CountersRepo) batchUpdate(counters []model.MetricCounter, ts time.Time) {
+	for _, c := range counters {
+		s.add(c.Name, c.Value, ts)
+	}
+}
+
+// History returns values recorded for the metric within [from, to] range.
+func (s *CountersRepo) History(_ context.Context, name string, from, to time.Time) ([]model.CounterRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.history[name]
+	if !ok {
+		return []model.CounterRecord{}, nil
+	}
+
+	return h.Filter(func(r model.CounterRecord) bool {
+		return !r.Timestamp.Before(from) && !r.Timestamp.After(to)
+	}), nil
+}
+
+// add increments the counter and records its new total in history.
+// Must be called with mu locked.
+func (s *CountersRepo) add(name string, value model.Counter, ts time.Time) {
+	s.counters[name] += value
+
+	h, ok := s.history[name]
+	if !ok {
+		h = newRing[model.CounterRecord](s.historySize)
+		s.history[name] = h
+	}
+	h.Push(model.CounterRecord{Timestamp: ts, Value: s.counters[name]})
+}
@@ -1,7 +1,9 @@
 package memstorage
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
@@ -21,17 +23,17 @@ func TestCountersRepo_Get(t *testing.T) {
 	}
 
 	t.Run("found", func(t *testing.T) {
-		err := s.Counters().Set(counter.name, counter.value)
+		err := s.Counters().Set(context.Background(), counter.name, counter.value)
 		require.NoError(t, err)
 
-		got, err := s.Counters().Get(counter.name)
+		got, err := s.Counters().Get(context.Background(), counter.name)
 		require.NotErrorIs(t, err, storage.ErrNotFound, "unexpected ErrNotFound when error must be nil")
 		require.NoError(t, err)
 		assert.Equal(t, counter.value, got)
 	})
 
 	t.Run("not found", func(t *testing.T) {
-		got, err := s.Counters().Get("unknown-test-counter")
+		got, err := s.Counters().Get(context.Background(), "unknown-test-counter")
 		require.Errorf(t, err, "expected nothing (ErrNotFound), but found something - name: %s, counter: %d", counter.name, got)
 		require.ErrorIs(t, err, storage.ErrNotFound, "expected ErrNotFound")
 		assert.EqualValues(t, 0, got)
@@ -57,11 +59,11 @@ func TestCountersRepo_GetAll(t *testing.T) {
 		s := New()
 
 		for _, c := range counters {
-			err := s.Counters().Set(c.name, c.value)
+			err := s.Counters().Set(context.Background(), c.name, c.value)
 			require.NoError(t, err)
 		}
 
-		got, err := s.Counters().GetAll()
+		got, err := s.Counters().GetAll(context.Background())
 		require.NoError(t, err)
 		require.NotEmpty(t, got)
 		assert.Len(t, got, len(counters))
@@ -71,11 +73,11 @@ func TestCountersRepo_GetAll(t *testing.T) {
 		s := New()
 
 		for _, c := range counters {
-			err := s.Counters().Delete(c.name)
+			err := s.Counters().Delete(context.Background(), c.name)
 			require.NoError(t, err)
 		}
 
-		got, err := s.Counters().GetAll()
+		got, err := s.Counters().GetAll(context.Background())
 		require.NoError(t, err)
 		assert.Empty(t, got)
 		assert.Len(t, got, 0)
@@ -93,10 +95,10 @@ func TestCountersRepo_Set(t *testing.T) {
 		value: model.Counter(42),
 	}
 
-	err := s.Counters().Set(counter.name, counter.value)
+	err := s.Counters().Set(context.Background(), counter.name, counter.value)
 	require.NoError(t, err)
 
-	got, err := s.Counters().Get(counter.name)
+	got, err := s.Counters().Get(context.Background(), counter.name)
 	require.NotErrorIs(t, err, storage.ErrNotFound, "ErrNotFound: nothing found after metric update attempt")
 	require.NoError(t, err)
 	assert.Equal(t, counter.value, got)
@@ -113,18 +115,52 @@ func TestCountersRepo_Delete(t *testing.T) {
 		value: model.Counter(42),
 	}
 
-	err := s.Counters().Set(counter.name, counter.value)
+	err := s.Counters().Set(context.Background(), counter.name, counter.value)
 	require.NoError(t, err)
 
-	got, err := s.Counters().Get(counter.name)
+	got, err := s.Counters().Get(context.Background(), counter.name)
 	require.NotErrorIs(t, err, storage.ErrNotFound, "ErrNotFound: nothing found after metric update attempt")
 	require.NoError(t, err)
 	assert.Equal(t, counter.value, got)
 
-	err = s.Counters().Delete(counter.name)
+	err = s.Counters().Delete(context.Background(), counter.name)
 	require.NoError(t, err)
 
-	got, err = s.Counters().Get(counter.name)
+	got, err = s.Counters().Get(context.Background(), counter.name)
 	require.Errorf(t, err, "expected nothing (ErrNotFound), but found something - name: %s, counter: %d", counter.name, got)
 	require.ErrorIs(t, err, storage.ErrNotFound, "expected ErrNotFound")
 }
+
+func TestCountersRepo_History(t *testing.T) {
+	const historySize = 3
+
+	s := NewWithHistorySize(historySize)
+	name := "TestCounter"
+	from := time.Now()
+
+	for i := 0; i < historySize+2; i++ {
+		err := s.Counters().Set(context.Background(), name, model.Counter(1))
+		require.NoError(t, err)
+	}
+
+	got, err := s.Counters().History(context.Background(), name, from, time.Now())
+	require.NoError(t, err)
+	require.Len(t, got, historySize, "history must be bounded by its size")
+
+	// accumulated totals of the last historySize updates are expected
+	for i, want := range []model.Counter{3, 4, 5} {
+		assert.Equal(t, want, got[i].Value)
+	}
+
+	t.Run("out of range", func(t *testing.T) {
+		got, err := s.Counters().History(context.Background(), name, from.Add(-time.Hour), from.Add(-time.Minute))
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		got, err := s.Counters().History(context.Background(), "unknown-test-counter", from, time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+}
@@ -1,7 +1,9 @@
 package memstorage
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
@@ -10,17 +12,26 @@ import (
 type GaugesRepo struct {
 	mu     sync.RWMutex
 	gauges map[string]model.Gauge
+
+	// wal logs updates when set (see Storage.SetWAL)
+	wal *WAL
+
+	// history keeps last historySize values of every metric
+	history     map[string]*ring[model.GaugeRecord]
+	historySize int
 }
 
-func NewGaugesRepo() *GaugesRepo {
+func NewGaugesRepo(historySize int) *GaugesRepo {
 	return &GaugesRepo{
-		gauges: make(map[string]model.Gauge),
+		gauges:      make(map[string]model.Gauge),
+		history:     make(map[string]*ring[model.GaugeRecord]),
+		historySize: historySize,
 	}
 }
 
 // Get finds metric by name. When requested metric doesn't exist
 // storage.ErrNotFound error is returned.
-func (s *GaugesRepo) Get(name string) (model.Gauge, error) {
+func (s *GaugesRepo) Get(_ context.Context, name string) (model.Gauge, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -32,7 +43,7 @@ func (s *GaugesRepo) Get(name string) (model.Gauge, error) {
 	return v, nil
 }
 
-func (s *GaugesRepo) GetAll() (map[string]model.Gauge, error) {
+func (s *GaugesRepo) GetAll(_ context.Context) (map[string]model.Gauge, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -45,29 +56,85 @@ func (s *GaugesRepo) GetAll() (map[string]model.Gauge, error) {
 	return res, nil
 }
 
-func (s *GaugesRepo) Set(name string, value model.Gauge) error {
+func (s *GaugesRepo) Set(_ context.Context, name string, value model.Gauge) error {
+	defer s.wal.hold()()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.gauges[name] = value
+	if err := s.wal.append(walRecord{Op: walOpUpdate, Gauges: []model.MetricGauge{{Name: name, Value: value}}}); err != nil {
+		return err
+	}
+
+	s.set(name, value, time.Now())
 	return nil
 }
 
-func (s *GaugesRepo) Delete(name string) error {
+func (s *GaugesRepo) Delete(_ context.Context, name string) error {
+	defer s.wal.hold()()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.wal.append(walRecord{Op: walOpDelete, Type: model.MetricTypeGauge, Name: name}); err != nil {
+		return err
+	}
+
 	delete(s.gauges, name)
+	delete(s.history, name)
 	return nil
 }
 
-func (s *GaugesRepo) BatchUpdate(gauges []model.MetricGauge) error {
+func (s *GaugesRepo) BatchUpdate(_ context.Context, gauges []model.MetricGauge) error {
+	if len(gauges) == 0 {
+		return nil
+	}
+
+	defer s.wal.hold()()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, g := range gauges {
-		s.gauges[g.Name] = g.Value
+	if err := s.wal.append(walRecord{Op: walOpUpdate, Gauges: gauges}); err != nil {
+		return err
 	}
 
+	s.batchUpdate(gauges, time.Now())
+
 	return nil
 }
+
+// batchUpdate updates gauges of the batch. Must be called with mu locked.
+func (s *GaugesRepo) batchUpdate(gauges []model.MetricGauge, ts time.Time) {
+	for _, g := range gauges {
+		s.set(g.Name, g.Value, ts)
+	}
+}
+
+// History returns values recorded for the metric within [from, to] range.
+func (s *GaugesRepo) History(_ context.Context, name string, from, to time.Time) ([]model.GaugeRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.history[name]
+	if !ok {
+		return []model.GaugeRecord{}, nil
+	}
+
+	return h.Filter(func(r model.GaugeRecord) bool {
+		return !r.Timestamp.Before(from) && !r.Timestamp.After(to)
+	}), nil
+}
+
+// set updates the gauge and records its value in history.
+// Must be called with mu locked.
+func (s *GaugesRepo) set(name string, value model.Gauge, ts time.Time) {
+	s.gauges[name] = value
+
+	h, ok := s.history[name]
+	if !ok {
+		h = newRing[model.GaugeRecord](s.historySize)
+		s.history[name] = h
+	}
+	h.Push(model.GaugeRecord{Timestamp: ts, Value: value})
+}
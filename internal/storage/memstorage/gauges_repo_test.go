@@ -1,7 +1,9 @@
 package memstorage
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
@@ -21,17 +23,17 @@ func TestGaugesRepo_Get(t *testing.T) {
 	}
 
 	t.Run("found", func(t *testing.T) {
-		err := s.Gauges().Set(gauge.name, gauge.value)
+		err := s.Gauges().Set(context.Background(), gauge.name, gauge.value)
 		require.NoError(t, err)
 
-		got, err := s.Gauges().Get(gauge.name)
+		got, err := s.Gauges().Get(context.Background(), gauge.name)
 		require.NotErrorIs(t, err, storage.ErrNotFound, "unexpected ErrNotFound when error must be nil")
 		require.NoError(t, err)
 		assert.Equal(t, gauge.value, got)
 	})
 
 	t.Run("not found", func(t *testing.T) {
-		got, err := s.Gauges().Get("unknown-test-gauge")
+		got, err := s.Gauges().Get(context.Background(), "unknown-test-gauge")
 		require.Errorf(t, err, "expected nothing (ErrNotFound), but found something - name: %s, gauge: %d", gauge.name, got)
 		require.ErrorIs(t, err, storage.ErrNotFound, "expected ErrNotFound")
 		assert.EqualValues(t, 0, got)
@@ -57,11 +59,11 @@ func TestGaugesRepo_GetAll(t *testing.T) {
 		s := New()
 
 		for _, c := range counters {
-			err := s.Gauges().Set(c.name, c.value)
+			err := s.Gauges().Set(context.Background(), c.name, c.value)
 			require.NoError(t, err)
 		}
 
-		got, err := s.Gauges().GetAll()
+		got, err := s.Gauges().GetAll(context.Background())
 		require.NoError(t, err)
 		require.NotEmpty(t, got)
 		assert.Len(t, got, len(counters))
@@ -71,11 +73,11 @@ func TestGaugesRepo_GetAll(t *testing.T) {
 		s := New()
 
 		for _, c := range counters {
-			err := s.Gauges().Delete(c.name)
+			err := s.Gauges().Delete(context.Background(), c.name)
 			require.NoError(t, err)
 		}
 
-		got, err := s.Gauges().GetAll()
+		got, err := s.Gauges().GetAll(context.Background())
 		require.NoError(t, err)
 		assert.Empty(t, got)
 		assert.Len(t, got, 0)
@@ -93,10 +95,10 @@ func TestGaugesRepo_Set(t *testing.T) {
 		value: model.Gauge(42.420),
 	}
 
-	err := s.Gauges().Set(gauge.name, gauge.value)
+	err := s.Gauges().Set(context.Background(), gauge.name, gauge.value)
 	require.NoError(t, err)
 
-	got, err := s.Gauges().Get(gauge.name)
+	got, err := s.Gauges().Get(context.Background(), gauge.name)
 	require.NotErrorIs(t, err, storage.ErrNotFound, "ErrNotFound: nothing found after metric update attempt")
 	require.NoError(t, err)
 	assert.Equal(t, gauge.value, got)
@@ -113,18 +115,49 @@ func TestGaugesRepo_Delete(t *testing.T) {
 		value: model.Gauge(42.420),
 	}
 
-	err := s.Gauges().Set(gauge.name, gauge.value)
+	err := s.Gauges().Set(context.Background(), gauge.name, gauge.value)
 	require.NoError(t, err)
 
-	got, err := s.Gauges().Get(gauge.name)
+	got, err := s.Gauges().Get(context.Background(), gauge.name)
 	require.NotErrorIs(t, err, storage.ErrNotFound, "ErrNotFound: nothing found after metric update attempt")
 	require.NoError(t, err)
 	assert.Equal(t, gauge.value, got)
 
-	err = s.Gauges().Delete(gauge.name)
+	err = s.Gauges().Delete(context.Background(), gauge.name)
 	require.NoError(t, err)
 
-	got, err = s.Gauges().Get(gauge.name)
+	got, err = s.Gauges().Get(context.Background(), gauge.name)
 	require.Errorf(t, err, "expected nothing (ErrNotFound), but found something - name: %s, gauge: %d", gauge.name, got)
 	require.ErrorIs(t, err, storage.ErrNotFound, "expected ErrNotFound")
 }
+
+func TestGaugesRepo_History(t *testing.T) {
+	const historySize = 3
+
+	s := NewWithHistorySize(historySize)
+	name := "TestGauge"
+	from := time.Now()
+
+	values := []model.Gauge{1.1, 2.2, 3.3, 4.4, 5.5}
+	for _, v := range values {
+		err := s.Gauges().Set(context.Background(), name, v)
+		require.NoError(t, err)
+	}
+
+	got, err := s.Gauges().History(context.Background(), name, from, time.Now())
+	require.NoError(t, err)
+	require.Len(t, got, historySize, "history must be bounded by its size")
+
+	for i, want := range values[len(values)-historySize:] {
+		assert.Equal(t, want, got[i].Value)
+	}
+
+	t.Run("deleted", func(t *testing.T) {
+		err := s.Gauges().Delete(context.Background(), name)
+		require.NoError(t, err)
+
+		got, err := s.Gauges().History(context.Background(), name, from, time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+}
@@ -0,0 +1,105 @@
+package memstorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/storage"
+)
+
+type HistogramsRepo struct {
+	mu         sync.RWMutex
+	histograms map[string]model.Histogram
+
+	// wal logs updates when set (see Storage.SetWAL)
+	wal *WAL
+}
+
+func NewHistogramsRepo() *HistogramsRepo {
+	return &HistogramsRepo{
+		histograms: make(map[string]model.Histogram),
+	}
+}
+
+// Get finds metric by name. When requested metric doesn't exist
+// storage.ErrNotFound error is returned.
+func (s *HistogramsRepo) Get(_ context.Context, name string) (model.Histogram, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.histograms[name]
+	if !ok {
+		return model.Histogram{}, storage.ErrNotFound
+	}
+
+	return v.Copy(), nil
+}
+
+func (s *HistogramsRepo) GetAll(_ context.Context) (map[string]model.Histogram, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make(map[string]model.Histogram, len(s.histograms))
+
+	for k, v := range s.histograms {
+		res[k] = v.Copy()
+	}
+
+	return res, nil
+}
+
+// Set merges observations of value into the stored histogram.
+func (s *HistogramsRepo) Set(_ context.Context, name string, value model.Histogram) error {
+	defer s.wal.hold()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wal.append(walRecord{Op: walOpUpdate, Histograms: []model.MetricHistogram{{Name: name, Value: value}}}); err != nil {
+		return err
+	}
+
+	s.histograms[name] = s.histograms[name].Merge(value)
+	return nil
+}
+
+func (s *HistogramsRepo) Delete(_ context.Context, name string) error {
+	defer s.wal.hold()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wal.append(walRecord{Op: walOpDelete, Type: model.MetricTypeHistogram, Name: name}); err != nil {
+		return err
+	}
+
+	delete(s.histograms, name)
+	return nil
+}
+
+func (s *HistogramsRepo) BatchUpdate(_ context.Context, histograms []model.MetricHistogram) error {
+	if len(histograms) == 0 {
+		return nil
+	}
+
+	defer s.wal.hold()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wal.append(walRecord{Op: walOpUpdate, Histograms: histograms}); err != nil {
+		return err
+	}
+
+	s.batchUpdate(histograms)
+
+	return nil
+}
+
+// batchUpdate merges histograms of the batch. Must be called with mu locked.
+func (s *HistogramsRepo) batchUpdate(histograms []model.MetricHistogram) {
+	for _, h := range histograms {
+		s.histograms[h.Name] = s.histograms[h.Name].Merge(h.Value)
+	}
+}
@@ -0,0 +1,55 @@
+package memstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramsRepo_Set(t *testing.T) {
+	s := New()
+
+	h := model.Histogram{
+		Buckets: []model.Bucket{{UpperBound: 1, Count: 1}},
+		Sum:     0.5,
+		Count:   2,
+	}
+
+	_, err := s.Histograms().Get(context.Background(), "Latency")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, s.Histograms().Set(context.Background(), "Latency", h))
+	require.NoError(t, s.Histograms().BatchUpdate(context.Background(), []model.MetricHistogram{{Name: "Latency", Value: h}}))
+
+	got, err := s.Histograms().Get(context.Background(), "Latency")
+	require.NoError(t, err)
+	assert.Equal(t, model.Histogram{
+		Buckets: []model.Bucket{{UpperBound: 1, Count: 2}},
+		Sum:     1,
+		Count:   4,
+	}, got)
+
+	// stored value must not be affected by changes of the returned one
+	got.Buckets[0].Count = 42
+	got, err = s.Histograms().Get(context.Background(), "Latency")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, got.Buckets[0].Count)
+}
+
+func TestSummariesRepo_Set(t *testing.T) {
+	s := New()
+
+	s1 := model.Summary{Quantiles: []model.Quantile{{Quantile: 0.5, Value: 1}}, Sum: 1, Count: 1}
+	s2 := model.Summary{Quantiles: []model.Quantile{{Quantile: 0.5, Value: 3}}, Sum: 4, Count: 2}
+
+	require.NoError(t, s.Summaries().Set(context.Background(), "Size", s1))
+	require.NoError(t, s.Summaries().BatchUpdate(context.Background(), []model.MetricSummary{{Name: "Size", Value: s2}}))
+
+	got, err := s.Summaries().Get(context.Background(), "Size")
+	require.NoError(t, err)
+	assert.Equal(t, s2, got)
+}
@@ -0,0 +1,52 @@
+package memstorage
+
+import "time"
+
+// appliedKeys remembers idempotency keys of applied batches for ttl. Keys are
+// kept in the order they were added, so expired ones are evicted from the
+// front. Not safe for concurrent use.
+type appliedKeys struct {
+	ttl   time.Duration
+	keys  map[string]time.Time
+	order []appliedKey
+}
+
+type appliedKey struct {
+	key string
+	ts  time.Time
+}
+
+func newAppliedKeys(ttl time.Duration) *appliedKeys {
+	return &appliedKeys{
+		ttl:  ttl,
+		keys: make(map[string]time.Time),
+	}
+}
+
+// contains reports whether key has been applied within ttl before now.
+func (a *appliedKeys) contains(key string, now time.Time) bool {
+	ts, ok := a.keys[key]
+	return ok && now.Sub(ts) < a.ttl
+}
+
+// add remembers key applied at now and evicts expired keys.
+func (a *appliedKeys) add(key string, now time.Time) {
+	a.evict(now)
+
+	a.keys[key] = now
+	a.order = append(a.order, appliedKey{key: key, ts: now})
+}
+
+func (a *appliedKeys) evict(now time.Time) {
+	var i int
+	for ; i < len(a.order) && now.Sub(a.order[i].ts) >= a.ttl; i++ {
+		// key could have been added again later, don't forget it then
+		if ts := a.keys[a.order[i].key]; !ts.After(a.order[i].ts) {
+			delete(a.keys, a.order[i].key)
+		}
+	}
+
+	if i > 0 {
+		a.order = append(a.order[:0], a.order[i:]...)
+	}
+}
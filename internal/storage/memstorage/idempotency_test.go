@@ -0,0 +1,25 @@
+package memstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppliedKeys(t *testing.T) {
+	now := time.Now()
+	keys := newAppliedKeys(time.Minute)
+
+	keys.add("a", now)
+	keys.add("b", now.Add(30*time.Second))
+
+	assert.True(t, keys.contains("a", now.Add(59*time.Second)))
+	assert.False(t, keys.contains("a", now.Add(time.Minute)))
+	assert.False(t, keys.contains("c", now))
+
+	// expired keys are evicted on add
+	keys.add("c", now.Add(time.Minute))
+	assert.Len(t, keys.keys, 2)
+	assert.Len(t, keys.order, 2)
+}
@@ -0,0 +1,56 @@
+package memstorage
+
+// DefaultHistorySize is a default max number of values kept in history
+// for every metric.
+const DefaultHistorySize = 1000
+
+// ring is a bounded ring buffer. When it is full, the oldest element gets
+// overwritten by the newly pushed one.
+type ring[T any] struct {
+	buf   []T
+	start int // index of the oldest element
+	size  int // number of elements stored
+}
+
+func newRing[T any](capacity int) *ring[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &ring[T]{
+		buf: make([]T, capacity),
+	}
+}
+
+// Push adds v to the buffer overwriting the oldest element when full.
+func (r *ring[T]) Push(v T) {
+	end := (r.start + r.size) % len(r.buf)
+	r.buf[end] = v
+
+	if r.size < len(r.buf) {
+		r.size++
+		return
+	}
+
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// Len returns number of elements stored.
+func (r *ring[T]) Len() int {
+	return r.size
+}
+
+// Filter returns elements, from the oldest to the newest, for which keep
+// returned true.
+func (r *ring[T]) Filter(keep func(v T) bool) []T {
+	res := make([]T, 0)
+
+	for i := 0; i < r.size; i++ {
+		v := r.buf[(r.start+i)%len(r.buf)]
+		if keep(v) {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
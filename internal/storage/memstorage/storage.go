@@ -3,17 +3,49 @@ package memstorage
 
 import (
 	"context"
+	"time"
 
+	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
 )
 
 type Storage struct {
-	gauges   *GaugesRepo
-	counters *CountersRepo
+	gauges     *GaugesRepo
+	counters   *CountersRepo
+	histograms *HistogramsRepo
+	summaries  *SummariesRepo
+
+	// historySize is a max number of values kept in history for every metric
+	historySize int
+
+	wal *WAL
+
+	// applied remembers idempotency keys of applied batches, guarded by
+	// locks of all repositories (same as BatchUpdate)
+	applied *appliedKeys
 }
 
 func New() *Storage {
-	return &Storage{}
+	return NewWithHistorySize(DefaultHistorySize)
+}
+
+// NewWithHistorySize creates new Storage which keeps at most historySize
+// values in history for every metric.
+func NewWithHistorySize(historySize int) *Storage {
+	if historySize < 1 {
+		historySize = DefaultHistorySize
+	}
+
+	// repositories are created up front, so concurrent callers never race
+	// to create them
+	return &Storage{
+		gauges:      NewGaugesRepo(historySize),
+		counters:    NewCountersRepo(historySize),
+		histograms:  NewHistogramsRepo(),
+		summaries:   NewSummariesRepo(),
+		historySize: historySize,
+		applied:     newAppliedKeys(storage.IdempotencyKeyTTL),
+	}
 }
 
 func (s *Storage) Ping(ctx context.Context) error {
@@ -25,17 +57,81 @@ func (s *Storage) Close(ctx context.Context) (err error) {
 }
 
 func (s *Storage) Gauges() storage.GaugesRepository {
-	if s.gauges == nil {
-		s.gauges = NewGaugesRepo()
-	}
-
 	return s.gauges
 }
 
 func (s *Storage) Counters() storage.CountersRepository {
-	if s.counters == nil {
-		s.counters = NewCountersRepo()
+	return s.counters
+}
+
+func (s *Storage) Histograms() storage.HistogramsRepository {
+	return s.histograms
+}
+
+func (s *Storage) Summaries() storage.SummariesRepository {
+	return s.summaries
+}
+
+// SetWAL attaches write-ahead log to the storage: every update will be
+// appended to wal before it's applied. Must be called before the storage is
+// used concurrently (e.g. right after the restore and before the server
+// starts), nil detaches the log.
+func (s *Storage) SetWAL(wal *WAL) {
+	s.wal = wal
+	s.gauges.wal = wal
+	s.counters.wal = wal
+	s.histograms.wal = wal
+	s.summaries.wal = wal
+}
+
+// BatchUpdate applies metrics of all types atomically: locks of all
+// repositories are held for the whole update, so readers never see a
+// half-applied batch, and the batch is logged to WAL as a single record.
+//
+// Batch with idempotency key which has already been applied is skipped and
+// storage.ErrDuplicateBatch is returned.
+func (s *Storage) BatchUpdate(_ context.Context, batch model.Batch) error {
+	if batch.Len() == 0 {
+		return nil
 	}
 
-	return s.counters
+	defer s.wal.hold()()
+
+	// repositories are always locked in the same order
+	s.gauges.mu.Lock()
+	defer s.gauges.mu.Unlock()
+	s.counters.mu.Lock()
+	defer s.counters.mu.Unlock()
+	s.histograms.mu.Lock()
+	defer s.histograms.mu.Unlock()
+	s.summaries.mu.Lock()
+	defer s.summaries.mu.Unlock()
+
+	ts := time.Now()
+	if batch.IdempotencyKey != "" && s.applied.contains(batch.IdempotencyKey, ts) {
+		return storage.ErrDuplicateBatch
+	}
+
+	err := s.wal.append(walRecord{
+		Op:             walOpUpdate,
+		Gauges:         batch.Gauges,
+		Counters:       batch.Counters,
+		Histograms:     batch.Histograms,
+		Summaries:      batch.Summaries,
+		IdempotencyKey: batch.IdempotencyKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.gauges.batchUpdate(batch.Gauges, ts)
+	s.counters.batchUpdate(batch.Counters, ts)
+	s.histograms.batchUpdate(batch.Histograms)
+	s.summaries.batchUpdate(batch.Summaries)
+
+	if batch.IdempotencyKey != "" {
+		s.applied.add(batch.IdempotencyKey, ts)
+	}
+
+	return nil
 }
@@ -4,8 +4,14 @@ package memstorage
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/storage"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,3 +30,71 @@ func TestStorage_Close(t *testing.T) {
 	err := s.Close(context.Background())
 	require.NoError(t, err)
 }
+
+func TestStorage_BatchUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path, 0, false)
+	require.NoError(t, err)
+
+	s := New()
+	s.SetWAL(wal)
+
+	batch := model.Batch{
+		Gauges:     []model.MetricGauge{{Name: "Alloc", Value: 1.5}},
+		Counters:   []model.MetricCounter{{Name: "PollCount", Value: 2}, {Name: "PollCount", Value: 3}},
+		Histograms: []model.MetricHistogram{{Name: "Latency", Value: model.Histogram{Sum: 2, Count: 1}}},
+		Summaries:  []model.MetricSummary{{Name: "Size", Value: model.Summary{Sum: 1, Count: 1}}},
+	}
+	require.NoError(t, s.BatchUpdate(context.Background(), batch))
+	require.NoError(t, s.BatchUpdate(context.Background(), model.Batch{}))
+	require.NoError(t, wal.Close())
+
+	gauge, err := s.Gauges().Get(context.Background(), "Alloc")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1.5, gauge)
+
+	counter, err := s.Counters().Get(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, counter)
+
+	h, err := s.Histograms().Get(context.Background(), "Latency")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, h.Sum)
+
+	_, err = s.Summaries().Get(context.Background(), "Size")
+	require.NoError(t, err)
+
+	// whole batch must be logged as a single record, empty batch isn't logged
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+}
+
+func TestStorage_BatchUpdateIdempotency(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path, 0, false)
+	require.NoError(t, err)
+
+	s := New()
+	s.SetWAL(wal)
+
+	batch := model.Batch{
+		Counters:       []model.MetricCounter{{Name: "PollCount", Value: 2}},
+		IdempotencyKey: "agent-1",
+	}
+	require.NoError(t, s.BatchUpdate(context.Background(), batch))
+	require.ErrorIs(t, s.BatchUpdate(context.Background(), batch), storage.ErrDuplicateBatch)
+	require.NoError(t, wal.Close())
+
+	counter, err := s.Counters().Get(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, counter)
+
+	// key must be remembered after the restart
+	restored := New()
+	_, err = ReplayWAL(context.Background(), path, restored, 0)
+	require.NoError(t, err)
+	require.ErrorIs(t, restored.BatchUpdate(context.Background(), batch), storage.ErrDuplicateBatch)
+}
@@ -0,0 +1,104 @@
+package memstorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/storage"
+)
+
+type SummariesRepo struct {
+	mu        sync.RWMutex
+	summaries map[string]model.Summary
+
+	// wal logs updates when set (see Storage.SetWAL)
+	wal *WAL
+}
+
+func NewSummariesRepo() *SummariesRepo {
+	return &SummariesRepo{
+		summaries: make(map[string]model.Summary),
+	}
+}
+
+// Get finds metric by name. When requested metric doesn't exist
+// storage.ErrNotFound error is returned.
+func (s *SummariesRepo) Get(_ context.Context, name string) (model.Summary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.summaries[name]
+	if !ok {
+		return model.Summary{}, storage.ErrNotFound
+	}
+
+	return v.Copy(), nil
+}
+
+func (s *SummariesRepo) GetAll(_ context.Context) (map[string]model.Summary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make(map[string]model.Summary, len(s.summaries))
+
+	for k, v := range s.summaries {
+		res[k] = v.Copy()
+	}
+
+	return res, nil
+}
+
+func (s *SummariesRepo) Set(_ context.Context, name string, value model.Summary) error {
+	defer s.wal.hold()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wal.append(walRecord{Op: walOpUpdate, Summaries: []model.MetricSummary{{Name: name, Value: value}}}); err != nil {
+		return err
+	}
+
+	s.summaries[name] = value.Copy()
+	return nil
+}
+
+func (s *SummariesRepo) Delete(_ context.Context, name string) error {
+	defer s.wal.hold()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wal.append(walRecord{Op: walOpDelete, Type: model.MetricTypeSummary, Name: name}); err != nil {
+		return err
+	}
+
+	delete(s.summaries, name)
+	return nil
+}
+
+func (s *SummariesRepo) BatchUpdate(_ context.Context, summaries []model.MetricSummary) error {
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	defer s.wal.hold()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wal.append(walRecord{Op: walOpUpdate, Summaries: summaries}); err != nil {
+		return err
+	}
+
+	s.batchUpdate(summaries)
+
+	return nil
+}
+
+// batchUpdate replaces summaries of the batch. Must be called with mu locked.
+func (s *SummariesRepo) batchUpdate(summaries []model.MetricSummary) {
+	for _, sm := range summaries {
+		s.summaries[sm.Name] = sm.Value.Copy()
+	}
+}
@@ -0,0 +1,267 @@
+package memstorage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/storage"
+)
+
+// WAL is an append-only log of storage updates (write-ahead log).
+//
+// Every update is appended to the log before it is applied to the storage,
+// so the storage state can be recovered after a crash by replaying the log
+// on top of the latest snapshot. Records are JSON objects, one per line, and
+// every record has a log sequence number (LSN).
+//
+// The log grows with every update, so it has to be compacted periodically:
+// snapshot of the storage is saved and the log is truncated (see Compact).
+type WAL struct {
+	// compactMu is held for reading by updates and for writing by Compact,
+	// so snapshot is never taken in the middle of an update
+	compactMu sync.RWMutex
+
+	mu   sync.Mutex // guards file and lsn
+	file *os.File
+	lsn  uint64
+	sync bool
+}
+
+const (
+	walOpUpdate = "update"
+	walOpDelete = "delete"
+)
+
+// walRecord is a single WAL entry. Update record holds a batch of metrics of
+// any types. Delete record holds type and name of the deleted metric.
+type walRecord struct {
+	LSN uint64 `json:"lsn"`
+	Op  string `json:"op"`
+
+	Gauges     []model.MetricGauge     `json:"gauges,omitempty"`
+	Counters   []model.MetricCounter   `json:"counters,omitempty"`
+	Histograms []model.MetricHistogram `json:"histograms,omitempty"`
+	Summaries  []model.MetricSummary   `json:"summaries,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	Type string `json:"type,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// OpenWAL opens log file for appending, the file is created if it doesn't
+// exist. lsn is the last used LSN (returned by ReplayWAL), new records will
+// be numbered after it. When sync is set, file is synced to disk after every
+// append.
+func OpenWAL(path string, lsn uint64, sync bool) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WAL{
+		file: f,
+		lsn:  lsn,
+		sync: sync,
+	}, nil
+}
+
+// Close closes the log file.
+func (w *WAL) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// Compact takes snapshot of the storage and truncates the log. snapshot must
+// save storage state along with lsn passed to it, updates are blocked until
+// it returns. The log is truncated only when snapshot succeeds.
+func (w *WAL) Compact(snapshot func(lsn uint64) error) error {
+	w.compactMu.Lock()
+	defer w.compactMu.Unlock()
+
+	w.mu.Lock()
+	lsn := w.lsn
+	w.mu.Unlock()
+
+	if err := snapshot(lsn); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("wal truncation failed: %w", err)
+	}
+
+	return nil
+}
+
+// hold prevents compaction until returned release func is called. Storage
+// holds the WAL for the whole update: while record is appended and applied.
+// Safe to call on nil WAL.
+func (w *WAL) hold() (release func()) {
+	if w == nil {
+		return func() {}
+	}
+
+	w.compactMu.RLock()
+	return w.compactMu.RUnlock
+}
+
+// append writes record to the log. Safe to call on nil WAL, nothing is
+// written then.
+func (w *WAL) append(rec walRecord) error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec.LSN = w.lsn + 1
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("wal record marshal failed: %w", err)
+	}
+	data = append(data, '\n')
+
+	// record is written in a single call, so it either gets into the file
+	// as a whole or becomes a torn tail, which is dropped on replay
+	if _, err = w.file.Write(data); err != nil {
+		return fmt.Errorf("wal append failed: %w", err)
+	}
+
+	if w.sync {
+		if err = w.file.Sync(); err != nil {
+			return fmt.Errorf("wal sync failed: %w", err)
+		}
+	}
+
+	w.lsn = rec.LSN
+
+	return nil
+}
+
+// ReplayWAL applies records from log file to the storage. Records with LSN
+// not greater than afterLSN are already in the snapshot, so they are skipped.
+// Missing file is treated as empty log.
+//
+// Idempotency keys of replayed batches are remembered again, so retries of
+// batches applied before the restart are still recognized. Keys of batches
+// already compacted into the snapshot are lost.
+//
+// Record at the end of the log may be torn by a crash in the middle of
+// append, such record is dropped and the file is truncated to the last
+// complete record.
+//
+// The last LSN found in the log (or afterLSN when it is greater) is returned.
+// WAL must not be attached to s while replaying.
+func ReplayWAL(ctx context.Context, path string, s *Storage, afterLSN uint64) (lsn uint64, err error) {
+	lsn = afterLSN
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return lsn, nil
+		}
+		return lsn, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	var offset int64 // end of the last complete record
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return lsn, err
+		}
+
+		complete := err == nil
+		if len(bytes.TrimSpace(line)) == 0 && !complete {
+			break
+		}
+
+		var rec walRecord
+		if !complete || json.Unmarshal(line, &rec) != nil {
+			if complete {
+				if _, peekErr := r.Peek(1); peekErr == nil {
+					return lsn, fmt.Errorf("wal is corrupted at offset %d", offset)
+				}
+			}
+
+			// torn tail
+			if err = f.Truncate(offset); err != nil {
+				return lsn, fmt.Errorf("wal torn tail truncation failed: %w", err)
+			}
+			break
+		}
+
+		offset += int64(len(line))
+
+		if rec.LSN <= afterLSN {
+			continue
+		}
+
+		if err = s.applyWALRecord(ctx, rec); err != nil {
+			return lsn, fmt.Errorf("wal record %d replay failed: %w", rec.LSN, err)
+		}
+
+		lsn = max(lsn, rec.LSN)
+	}
+
+	return lsn, nil
+}
+
+// applyWALRecord applies record to the storage.
+func (s *Storage) applyWALRecord(ctx context.Context, rec walRecord) error {
+	switch rec.Op {
+	case walOpUpdate:
+		err := s.BatchUpdate(ctx, model.Batch{
+			Gauges:         rec.Gauges,
+			Counters:       rec.Counters,
+			Histograms:     rec.Histograms,
+			Summaries:      rec.Summaries,
+			IdempotencyKey: rec.IdempotencyKey,
+		})
+		if errors.Is(err, storage.ErrDuplicateBatch) {
+			// can't be logged twice, but anyway it's already applied
+			return nil
+		}
+		return err
+	case walOpDelete:
+		switch rec.Type {
+		case model.MetricTypeGauge:
+			return s.Gauges().Delete(ctx, rec.Name)
+		case model.MetricTypeCounter:
+			return s.Counters().Delete(ctx, rec.Name)
+		case model.MetricTypeHistogram:
+			return s.Histograms().Delete(ctx, rec.Name)
+		case model.MetricTypeSummary:
+			return s.Summaries().Delete(ctx, rec.Name)
+		}
+		return fmt.Errorf("unknown metric type: %s", rec.Type)
+	}
+
+	return fmt.Errorf("unknown op: %s", rec.Op)
+}
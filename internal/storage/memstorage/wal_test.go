@@ -0,0 +1,133 @@
+package memstorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path, 0, false)
+	require.NoError(t, err)
+
+	s := New()
+	s.SetWAL(wal)
+
+	require.NoError(t, s.Gauges().Set(context.Background(), "Alloc", 1.5))
+	require.NoError(t, s.Counters().Set(context.Background(), "PollCount", 2))
+	require.NoError(t, s.Counters().BatchUpdate(context.Background(), []model.MetricCounter{{Name: "PollCount", Value: 3}}))
+	require.NoError(t, s.Histograms().Set(context.Background(), "Latency", model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 1}}, Count: 1}))
+	require.NoError(t, s.Summaries().Set(context.Background(), "Size", model.Summary{Sum: 1, Count: 1}))
+	require.NoError(t, s.Gauges().Set(context.Background(), "Removed", 1))
+	require.NoError(t, s.Gauges().Delete(context.Background(), "Removed"))
+	require.NoError(t, wal.Close())
+
+	t.Run("full", func(t *testing.T) {
+		restored := New()
+		lsn, err := ReplayWAL(context.Background(), path, restored, 0)
+		require.NoError(t, err)
+		assert.EqualValues(t, 7, lsn)
+
+		gauges, _ := restored.Gauges().GetAll(context.Background())
+		assert.Equal(t, map[string]model.Gauge{"Alloc": 1.5}, gauges)
+
+		counter, err := restored.Counters().Get(context.Background(), "PollCount")
+		require.NoError(t, err)
+		assert.EqualValues(t, 5, counter)
+
+		h, err := restored.Histograms().Get(context.Background(), "Latency")
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, h.Count)
+
+		_, err = restored.Summaries().Get(context.Background(), "Size")
+		require.NoError(t, err)
+	})
+
+	t.Run("after lsn", func(t *testing.T) {
+		restored := New()
+		// first two records are considered to be in the snapshot already
+		_, err := ReplayWAL(context.Background(), path, restored, 2)
+		require.NoError(t, err)
+
+		counter, err := restored.Counters().Get(context.Background(), "PollCount")
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, counter)
+
+		_, err = restored.Gauges().Get(context.Background(), "Alloc")
+		require.Error(t, err)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		lsn, err := ReplayWAL(context.Background(), filepath.Join(t.TempDir(), "none.wal"), New(), 42)
+		require.NoError(t, err)
+		assert.EqualValues(t, 42, lsn)
+	})
+}
+
+func TestWAL_ReplayTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path, 0, false)
+	require.NoError(t, err)
+
+	s := New()
+	s.SetWAL(wal)
+	require.NoError(t, s.Counters().Set(context.Background(), "PollCount", 1))
+	require.NoError(t, wal.Close())
+
+	valid, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// simulate crash in the middle of append
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"lsn":2,"op":"upd`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restored := New()
+	lsn, err := ReplayWAL(context.Background(), path, restored, 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, lsn)
+
+	// torn record must be cut off
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, valid, data)
+}
+
+func TestWAL_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path, 10, false)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	s := New()
+	s.SetWAL(wal)
+	require.NoError(t, s.Counters().Set(context.Background(), "PollCount", 1))
+
+	var snapshotLSN uint64
+	require.NoError(t, wal.Compact(func(lsn uint64) error {
+		snapshotLSN = lsn
+		return nil
+	}))
+	assert.EqualValues(t, 11, snapshotLSN)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	// numbering continues after compaction
+	require.NoError(t, s.Counters().Set(context.Background(), "PollCount", 1))
+	lsn, err := ReplayWAL(context.Background(), path, New(), snapshotLSN)
+	require.NoError(t, err)
+	assert.EqualValues(t, 12, lsn)
+}
@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// Batch helpers convert metrics into column arrays to be passed to unnest()
+// in a single statement. INSERT ... ON CONFLICT DO UPDATE fails when the
+// same row is affected twice, so duplicate names are merged first: counter
+// deltas are summed up and the last value wins for gauges and summaries.
+// Order of the first occurrence is preserved.
+
+func counterColumns(counters []model.MetricCounter) (names []string, values []int64) {
+	index := make(map[string]int, len(counters))
+	names = make([]string, 0, len(counters))
+	values = make([]int64, 0, len(counters))
+
+	for _, c := range counters {
+		if i, ok := index[c.Name]; ok {
+			values[i] += int64(c.Value)
+			continue
+		}
+
+		index[c.Name] = len(names)
+		names = append(names, c.Name)
+		values = append(values, int64(c.Value))
+	}
+
+	return names, values
+}
+
+func gaugeColumns(gauges []model.MetricGauge) (names []string, values []float64) {
+	index := make(map[string]int, len(gauges))
+	names = make([]string, 0, len(gauges))
+	values = make([]float64, 0, len(gauges))
+
+	for _, g := range gauges {
+		if i, ok := index[g.Name]; ok {
+			values[i] = float64(g.Value)
+			continue
+		}
+
+		index[g.Name] = len(names)
+		names = append(names, g.Name)
+		values = append(values, float64(g.Value))
+	}
+
+	return names, values
+}
+
+// summaryColumns returns summaries encoded as json strings.
+func summaryColumns(summaries []model.MetricSummary) (names []string, values []string, err error) {
+	index := make(map[string]int, len(summaries))
+	names = make([]string, 0, len(summaries))
+	values = make([]string, 0, len(summaries))
+
+	for _, s := range summaries {
+		data, err := json.Marshal(s.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if i, ok := index[s.Name]; ok {
+			values[i] = string(data)
+			continue
+		}
+
+		index[s.Name] = len(names)
+		names = append(names, s.Name)
+		values = append(values, string(data))
+	}
+
+	return names, values, nil
+}
+
+// mergeHistograms merges histograms with the same name in order of appearance.
+// Returned names are sorted, so rows are always inserted and locked in the
+// same order.
+func mergeHistograms(histograms []model.MetricHistogram) (names []string, merged map[string]model.Histogram) {
+	merged = make(map[string]model.Histogram, len(histograms))
+	names = make([]string, 0, len(histograms))
+
+	for _, h := range histograms {
+		stored, ok := merged[h.Name]
+		if !ok {
+			names = append(names, h.Name)
+			merged[h.Name] = h.Value.Copy()
+			continue
+		}
+
+		merged[h.Name] = stored.Merge(h.Value)
+	}
+	sort.Strings(names)
+
+	return names, merged
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterColumns(t *testing.T) {
+	names, values := counterColumns([]model.MetricCounter{
+		{Name: "b", Value: 1},
+		{Name: "a", Value: 2},
+		{Name: "b", Value: 3},
+	})
+
+	assert.Equal(t, []string{"b", "a"}, names)
+	assert.Equal(t, []int64{4, 2}, values)
+}
+
+func TestGaugeColumns(t *testing.T) {
+	names, values := gaugeColumns([]model.MetricGauge{
+		{Name: "b", Value: 1},
+		{Name: "a", Value: 2},
+		{Name: "b", Value: 3},
+	})
+
+	assert.Equal(t, []string{"b", "a"}, names)
+	assert.Equal(t, []float64{3, 2}, values)
+}
+
+func TestSummaryColumns(t *testing.T) {
+	names, values, err := summaryColumns([]model.MetricSummary{
+		{Name: "a", Value: model.Summary{Sum: 1, Count: 1}},
+		{Name: "a", Value: model.Summary{Sum: 2, Count: 2}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a"}, names)
+	assert.JSONEq(t, `{"quantiles": null, "sum": 2, "count": 2}`, values[0])
+}
+
+func TestMergeHistograms(t *testing.T) {
+	h := model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 1}}, Sum: 1, Count: 1}
+
+	names, merged := mergeHistograms([]model.MetricHistogram{
+		{Name: "b", Value: h},
+		{Name: "a", Value: h},
+		{Name: "a", Value: h},
+	})
+
+	assert.Equal(t, []string{"a", "b"}, names)
+	assert.Equal(t, model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 2}}, Sum: 2, Count: 2}, merged["a"])
+	assert.EqualValues(t, 1, h.Buckets[0].Count, "source histogram must not be changed")
+}
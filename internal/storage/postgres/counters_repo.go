@@ -1,8 +1,10 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
@@ -22,15 +24,18 @@ const queryGetCounter = `SELECT value FROM counters WHERE name=$1;`
 
 // Get finds metric by name. When requested metric doesn't exist
 // storage.ErrNotFound error is returned.
-func (r *CountersRepo) Get(name string) (model.Counter, error) {
-	stmt, err := r.s.db.Prepare(queryGetCounter)
+func (r *CountersRepo) Get(ctx context.Context, name string) (model.Counter, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetCounter)
 	if err != nil {
 		return 0, err
 	}
 	defer stmt.Close()
 
 	var counter model.Counter
-	err = stmt.QueryRow(name).Scan(&counter)
+	err = stmt.QueryRowContext(ctx, name).Scan(&counter)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			err = storage.ErrNotFound
@@ -43,8 +48,11 @@ func (r *CountersRepo) Get(name string) (model.Counter, error) {
 
 const queryGetCountersAll = `SELECT name, value FROM counters;`
 
-func (r *CountersRepo) GetAll() (map[string]model.Counter, error) {
-	stmt, err := r.s.db.Prepare(queryGetCountersAll)
+func (r *CountersRepo) GetAll(ctx context.Context) (map[string]model.Counter, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetCountersAll)
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +60,7 @@ func (r *CountersRepo) GetAll() (map[string]model.Counter, error) {
 
 	counters := make(map[string]model.Counter)
 
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -72,68 +80,144 @@ func (r *CountersRepo) GetAll() (map[string]model.Counter, error) {
 	return counters, rows.Err()
 }
 
+// querySetCounter also records new accumulated value in counters_history.
 const querySetCounter = `
-	INSERT INTO counters (name, value) VALUES ($1, $2)
-	ON CONFLICT(name) 
-	DO UPDATE SET value = counters.value + $2;
+	WITH upd AS (
+		INSERT INTO counters (name, value) VALUES ($1, $2)
+		ON CONFLICT(name) 
+		DO UPDATE SET value = counters.value + $2
+		RETURNING name, value
+	)
+	INSERT INTO counters_history (name, value) SELECT name, value FROM upd;
 `
 
 // Set updates the counter by its name or creates if doesn't exist.
 //
 // XXX: Инкремент #13. Использую `INSERT...ON CONFLICT DO UPDATE`, поэтому нет смысла
 // проверять на pgerrcode.UniqueViolation.
-func (r *CountersRepo) Set(name string, value model.Counter) error {
-	stmt, err := r.s.db.Prepare(querySetCounter)
+func (r *CountersRepo) Set(ctx context.Context, name string, value model.Counter) error {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, querySetCounter)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(name, value)
+	if _, err = stmt.ExecContext(ctx, name, value); err != nil {
+		return err
+	}
 
-	return err
+	return r.s.pruneHistory(ctx, r.s.db, queryPruneCountersHistory, []string{name})
 }
 
-func (r *CountersRepo) BatchUpdate(counters []model.MetricCounter) (err error) {
-	tx, err := r.s.db.Begin()
-	if err != nil {
-		return err
+// queryBatchSetCounters updates all counters of the batch in a single
+// statement. Names must be unique (see counterColumns).
+const queryBatchSetCounters = `
+	WITH upd AS (
+		INSERT INTO counters (name, value)
+		SELECT * FROM unnest($1::varchar[], $2::bigint[])
+		ON CONFLICT(name)
+		DO UPDATE SET value = counters.value + EXCLUDED.value
+		RETURNING name, value
+	)
+	INSERT INTO counters_history (name, value) SELECT name, value FROM upd;
+`
+
+// BatchUpdate updates all counters in a single round trip. Deltas of the
+// same counter within the batch are summed up.
+func (r *CountersRepo) BatchUpdate(ctx context.Context, counters []model.MetricCounter) (err error) {
+	if len(counters) == 0 {
+		return nil
 	}
 
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
 
-	stmt, err := tx.Prepare(querySetCounter)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+	return r.batchUpdate(ctx, r.s.db, counters)
+}
 
-	for _, counter := range counters {
-		_, err = stmt.Exec(counter.Name, counter.Value)
-		if err != nil {
-			return err
-		}
+func (r *CountersRepo) batchUpdate(ctx context.Context, q querier, counters []model.MetricCounter) error {
+	if len(counters) == 0 {
+		return nil
 	}
 
-	if err = tx.Commit(); err != nil {
+	names, values := counterColumns(counters)
+	if _, err := q.ExecContext(ctx, queryBatchSetCounters, names, values); err != nil {
 		return err
 	}
 
-	return nil
+	return r.s.pruneHistory(ctx, q, queryPruneCountersHistory, names)
 }
 
-const queryDeleteCounter = `DELETE FROM counters WHERE name=$1;`
+// queryPruneCountersHistory deletes history values of the counters exceeding
+// history size, $2 is history size minus one.
+const queryPruneCountersHistory = `
+	DELETE FROM counters_history h
+	USING unnest($1::varchar[]) AS n(name)
+	WHERE h.name = n.name AND h.id < (
+		SELECT id FROM counters_history
+		WHERE name = n.name
+		ORDER BY id DESC
+		OFFSET $2 LIMIT 1
+	);
+`
+
+// queryDeleteCounter also deletes metric history.
+const queryDeleteCounter = `
+	WITH h AS (DELETE FROM counters_history WHERE name=$1)
+	DELETE FROM counters WHERE name=$1;
+`
+
+func (r *CountersRepo) Delete(ctx context.Context, name string) error {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
 
-func (r *CountersRepo) Delete(name string) error {
-	stmt, err := r.s.db.Prepare(queryDeleteCounter)
+	stmt, err := r.s.db.PrepareContext(ctx, queryDeleteCounter)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(name)
+	_, err = stmt.ExecContext(ctx, name)
 
 	return err
 }
+
+const queryGetCountersHistory = `
+	SELECT value, ts FROM counters_history
+	WHERE name=$1 AND ts >= $2 AND ts <= $3
+	ORDER BY ts;
+`
+
+// History returns values recorded for the metric within [from, to] range.
+func (r *CountersRepo) History(ctx context.Context, name string, from, to time.Time) ([]model.CounterRecord, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetCountersHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, name, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]model.CounterRecord, 0)
+
+	for rows.Next() {
+		var record model.CounterRecord
+		err = rows.Scan(&record.Value, &record.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
@@ -1,8 +1,10 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
@@ -22,15 +24,18 @@ const queryGetGauge = `SELECT value FROM gauges WHERE name=$1;`
 
 // Get finds metric by name. When requested metric doesn't exist
 // storage.ErrNotFound error is returned.
-func (r *GaugesRepo) Get(name string) (model.Gauge, error) {
-	stmt, err := r.s.db.Prepare(queryGetGauge)
+func (r *GaugesRepo) Get(ctx context.Context, name string) (model.Gauge, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetGauge)
 	if err != nil {
 		return 0, err
 	}
 	defer stmt.Close()
 
 	var gauge model.Gauge
-	err = stmt.QueryRow(name).Scan(&gauge)
+	err = stmt.QueryRowContext(ctx, name).Scan(&gauge)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			err = storage.ErrNotFound
@@ -43,8 +48,11 @@ func (r *GaugesRepo) Get(name string) (model.Gauge, error) {
 
 const queryGetGaugesAll = `SELECT name, value FROM gauges;`
 
-func (r *GaugesRepo) GetAll() (map[string]model.Gauge, error) {
-	stmt, err := r.s.db.Prepare(queryGetGaugesAll)
+func (r *GaugesRepo) GetAll(ctx context.Context) (map[string]model.Gauge, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetGaugesAll)
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +60,7 @@ func (r *GaugesRepo) GetAll() (map[string]model.Gauge, error) {
 
 	gauges := make(map[string]model.Gauge)
 
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -72,68 +80,144 @@ func (r *GaugesRepo) GetAll() (map[string]model.Gauge, error) {
 	return gauges, rows.Err()
 }
 
+// querySetGauge also records new value in gauges_history.
 const querySetGauge = `
-	INSERT INTO gauges (name, value) VALUES ($1, $2)
-	ON CONFLICT(name) 
-	DO UPDATE SET value=$2;
+	WITH upd AS (
+		INSERT INTO gauges (name, value) VALUES ($1, $2)
+		ON CONFLICT(name) 
+		DO UPDATE SET value=$2
+		RETURNING name, value
+	)
+	INSERT INTO gauges_history (name, value) SELECT name, value FROM upd;
 `
 
 // Set updates the gauge by its name or creates if doesn't exist.
 //
 // XXX: Инкремент #13. Использую `INSERT...ON CONFLICT DO UPDATE`, поэтому нет смысла
 // проверять на pgerrcode.UniqueViolation.
-func (r *GaugesRepo) Set(name string, value model.Gauge) error {
-	stmt, err := r.s.db.Prepare(querySetGauge)
+func (r *GaugesRepo) Set(ctx context.Context, name string, value model.Gauge) error {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, querySetGauge)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(name, value)
+	if _, err = stmt.ExecContext(ctx, name, value); err != nil {
+		return err
+	}
 
-	return err
+	return r.s.pruneHistory(ctx, r.s.db, queryPruneGaugesHistory, []string{name})
 }
 
-func (r *GaugesRepo) BatchUpdate(gauges []model.MetricGauge) (err error) {
-	tx, err := r.s.db.Begin()
-	if err != nil {
-		return err
+// queryBatchSetGauges updates all gauges of the batch in a single statement.
+// Names must be unique (see gaugeColumns).
+const queryBatchSetGauges = `
+	WITH upd AS (
+		INSERT INTO gauges (name, value)
+		SELECT * FROM unnest($1::varchar[], $2::double precision[])
+		ON CONFLICT(name)
+		DO UPDATE SET value=EXCLUDED.value
+		RETURNING name, value
+	)
+	INSERT INTO gauges_history (name, value) SELECT name, value FROM upd;
+`
+
+// BatchUpdate updates all gauges in a single round trip. When the batch
+// contains the same gauge several times, the last value is kept.
+func (r *GaugesRepo) BatchUpdate(ctx context.Context, gauges []model.MetricGauge) (err error) {
+	if len(gauges) == 0 {
+		return nil
 	}
 
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
 
-	stmt, err := tx.Prepare(querySetGauge)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+	return r.batchUpdate(ctx, r.s.db, gauges)
+}
 
-	for _, gauge := range gauges {
-		_, err = stmt.Exec(gauge.Name, gauge.Value)
-		if err != nil {
-			return err
-		}
+func (r *GaugesRepo) batchUpdate(ctx context.Context, q querier, gauges []model.MetricGauge) error {
+	if len(gauges) == 0 {
+		return nil
 	}
 
-	if err = tx.Commit(); err != nil {
+	names, values := gaugeColumns(gauges)
+	if _, err := q.ExecContext(ctx, queryBatchSetGauges, names, values); err != nil {
 		return err
 	}
 
-	return nil
+	return r.s.pruneHistory(ctx, q, queryPruneGaugesHistory, names)
 }
 
-const queryDeleteGauge = `DELETE FROM gauges WHERE name=$1;`
+// queryPruneGaugesHistory deletes history values of the gauges exceeding
+// history size, $2 is history size minus one.
+const queryPruneGaugesHistory = `
+	DELETE FROM gauges_history h
+	USING unnest($1::varchar[]) AS n(name)
+	WHERE h.name = n.name AND h.id < (
+		SELECT id FROM gauges_history
+		WHERE name = n.name
+		ORDER BY id DESC
+		OFFSET $2 LIMIT 1
+	);
+`
+
+// queryDeleteGauge also deletes metric history.
+const queryDeleteGauge = `
+	WITH h AS (DELETE FROM gauges_history WHERE name=$1)
+	DELETE FROM gauges WHERE name=$1;
+`
+
+func (r *GaugesRepo) Delete(ctx context.Context, name string) error {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
 
-func (r *GaugesRepo) Delete(name string) error {
-	stmt, err := r.s.db.Prepare(queryDeleteGauge)
+	stmt, err := r.s.db.PrepareContext(ctx, queryDeleteGauge)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(name)
+	_, err = stmt.ExecContext(ctx, name)
 
 	return err
 }
+
+const queryGetGaugesHistory = `
+	SELECT value, ts FROM gauges_history
+	WHERE name=$1 AND ts >= $2 AND ts <= $3
+	ORDER BY ts;
+`
+
+// History returns values recorded for the metric within [from, to] range.
+func (r *GaugesRepo) History(ctx context.Context, name string, from, to time.Time) ([]model.GaugeRecord, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetGaugesHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, name, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]model.GaugeRecord, 0)
+
+	for rows.Next() {
+		var record model.GaugeRecord
+		err = rows.Scan(&record.Value, &record.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,218 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/storage"
+)
+
+// HistogramsRepo stores histograms as jsonb.
+type HistogramsRepo struct {
+	s *Storage
+}
+
+func NewHistogramsRepo(storage *Storage) *HistogramsRepo {
+	return &HistogramsRepo{
+		s: storage,
+	}
+}
+
+const queryGetHistogram = `SELECT value FROM histograms WHERE name=$1;`
+
+// Get finds metric by name. When requested metric doesn't exist
+// storage.ErrNotFound error is returned.
+func (r *HistogramsRepo) Get(ctx context.Context, name string) (model.Histogram, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetHistogram)
+	if err != nil {
+		return model.Histogram{}, err
+	}
+	defer stmt.Close()
+
+	var (
+		data      []byte
+		histogram model.Histogram
+	)
+
+	err = stmt.QueryRowContext(ctx, name).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = storage.ErrNotFound
+		}
+		return model.Histogram{}, err
+	}
+
+	err = json.Unmarshal(data, &histogram)
+
+	return histogram, err
+}
+
+const queryGetHistogramsAll = `SELECT name, value FROM histograms;`
+
+func (r *HistogramsRepo) GetAll(ctx context.Context) (map[string]model.Histogram, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetHistogramsAll)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	histograms := make(map[string]model.Histogram)
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			data      []byte
+			histogram model.Histogram
+			name      string
+		)
+		err = rows.Scan(&name, &data)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &histogram); err != nil {
+			return nil, err
+		}
+		histograms[name] = histogram
+	}
+
+	return histograms, rows.Err()
+}
+
+// Set merges observations of value into the stored histogram.
+func (r *HistogramsRepo) Set(ctx context.Context, name string, value model.Histogram) error {
+	return r.BatchUpdate(ctx, []model.MetricHistogram{{Name: name, Value: value}})
+}
+
+const (
+	// queryInitHistograms makes sure the rows exist, so they can be locked
+	// by queryLockHistograms even for the very first update. Rows are
+	// inserted in the same order as they are locked, otherwise concurrent
+	// batches could deadlock on conflicting inserts.
+	queryInitHistograms = `
+		INSERT INTO histograms (name, value)
+		SELECT name, '{}' FROM unnest($1::varchar[]) AS u(name)
+		ORDER BY name
+		ON CONFLICT(name) DO NOTHING;
+	`
+	// rows are locked in the same order by every transaction to avoid
+	// deadlocks between concurrent batches
+	queryLockHistograms = `
+		SELECT name, value FROM histograms
+		WHERE name = ANY($1::varchar[])
+		ORDER BY name
+		FOR UPDATE;
+	`
+	queryUpdateHistograms = `
+		UPDATE histograms h SET value=u.value::jsonb, updated=now()
+		FROM unnest($1::varchar[], $2::text[]) AS u(name, value)
+		WHERE h.name = u.name;
+	`
+)
+
+// BatchUpdate merges observations into stored histograms. Histograms have to
+// be merged in Go, so rows are locked while being updated. Whole batch takes
+// three round trips regardless of its size.
+func (r *HistogramsRepo) BatchUpdate(ctx context.Context, histograms []model.MetricHistogram) (err error) {
+	if len(histograms) == 0 {
+		return nil
+	}
+
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err = r.batchUpdate(ctx, tx, histograms); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// batchUpdate merges histograms within transaction tx, rows stay locked until
+// tx is finished.
+func (r *HistogramsRepo) batchUpdate(ctx context.Context, tx *sql.Tx, histograms []model.MetricHistogram) error {
+	if len(histograms) == 0 {
+		return nil
+	}
+
+	names, merged := mergeHistograms(histograms)
+
+	if _, err := tx.ExecContext(ctx, queryInitHistograms, names); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, queryLockHistograms, names)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]string, 0, len(names))
+	updated := make([]string, 0, len(names))
+	for rows.Next() {
+		var (
+			name   string
+			data   []byte
+			stored model.Histogram
+		)
+		if err = rows.Scan(&name, &data); err != nil {
+			return err
+		}
+		if err = json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+
+		if data, err = json.Marshal(stored.Merge(merged[name])); err != nil {
+			return err
+		}
+
+		updated = append(updated, name)
+		values = append(values, string(data))
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, queryUpdateHistograms, updated, values)
+
+	return err
+}
+
+const queryDeleteHistogram = `DELETE FROM histograms WHERE name=$1;`
+
+func (r *HistogramsRepo) Delete(ctx context.Context, name string) error {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryDeleteHistogram)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, name)
+
+	return err
+}
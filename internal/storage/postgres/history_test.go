@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_historySize(t *testing.T) {
+	s := NewWithLimits(openTestDB(t), DefaultQueryTimeout, 3)
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, s.Counters().Set(ctx, "PollCount", 1))
+		require.NoError(t, s.BatchUpdate(ctx, model.Batch{
+			Gauges: []model.MetricGauge{{Name: "Alloc", Value: model.Gauge(i)}},
+		}))
+	}
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	counters, err := s.Counters().History(ctx, "PollCount", from, to)
+	require.NoError(t, err)
+	require.Len(t, counters, 3, "the oldest values are deleted")
+	assert.EqualValues(t, 5, counters[2].Value)
+
+	gauges, err := s.Gauges().History(ctx, "Alloc", from, to)
+	require.NoError(t, err)
+	require.Len(t, gauges, 3, "the oldest values are deleted")
+	assert.EqualValues(t, 5, gauges[2].Value)
+}
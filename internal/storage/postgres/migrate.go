@@ -0,0 +1,296 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationsFS holds SQL migrations. Every migration consists of two files:
+// "<version>_<name>.up.sql" and "<version>_<name>.down.sql", where version is
+// a positive number, e.g. "0001_init.up.sql".
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// ErrSchemaOutdated is returned by Verify when database schema version
+// doesn't match the latest known migration.
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// migrationsLockID is a key of the advisory lock taken while migrations are
+// applied, so several server instances starting together don't race.
+const migrationsLockID = 7243158102
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies embedded migrations to the database. Applied versions are
+// recorded in schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// Migrate applies pending migrations to the database. When verifyOnly is set,
+// nothing is applied, schema version is only checked instead.
+func Migrate(ctx context.Context, db *sql.DB, verifyOnly bool) error {
+	m, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	if verifyOnly {
+		return m.Verify(ctx)
+	}
+
+	return m.Up(ctx)
+}
+
+// Latest returns version of the latest known migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+const queryCreateSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint NOT NULL PRIMARY KEY,
+	name varchar(500) NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+);`
+
+const querySchemaVersion = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`
+
+const querySchemaMigrationsExists = `SELECT to_regclass('schema_migrations') IS NOT NULL;`
+
+// Version returns current schema version. Zero is returned when no migrations
+// have been applied yet.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var exists bool
+	if err := m.db.QueryRowContext(ctx, querySchemaMigrationsExists).Scan(&exists); err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	if err := m.db.QueryRowContext(ctx, querySchemaVersion).Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// Verify checks that all known migrations have been applied.
+func (m *Migrator) Verify(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version != m.Latest() {
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaOutdated, version, m.Latest())
+	}
+
+	return nil
+}
+
+const queryInsertSchemaMigration = `INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`
+
+// Up applies all pending migrations. Every migration is applied in its own
+// transaction.
+func (m *Migrator) Up(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, queryCreateSchemaMigrations); err != nil {
+		return fmt.Errorf("schema_migrations creation failed: %w", err)
+	}
+
+	for _, migration := range m.migrations {
+		err := m.inTx(ctx, func(tx *sql.Tx, version int) error {
+			if migration.Version <= version {
+				return nil
+			}
+
+			if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+				return err
+			}
+
+			_, err := tx.ExecContext(ctx, queryInsertSchemaMigration, migration.Version, migration.Name)
+			return err
+		})
+
+		if err != nil {
+			return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+const queryDeleteSchemaMigration = `DELETE FROM schema_migrations WHERE version=$1;`
+
+// Down rolls back steps last applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if _, err := m.db.ExecContext(ctx, queryCreateSchemaMigrations); err != nil {
+		return fmt.Errorf("schema_migrations creation failed: %w", err)
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := m.migrations[i]
+
+		var applied bool
+		err := m.inTx(ctx, func(tx *sql.Tx, version int) error {
+			if migration.Version != version {
+				return nil
+			}
+
+			if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+				return err
+			}
+
+			_, err := tx.ExecContext(ctx, queryDeleteSchemaMigration, migration.Version)
+			applied = err == nil
+			return err
+		})
+
+		if err != nil {
+			return fmt.Errorf("rollback of migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+
+		if applied {
+			steps--
+		}
+	}
+
+	return nil
+}
+
+// inTx runs fn in transaction holding migrations advisory lock. Current schema
+// version is passed to fn.
+func (m *Migrator) inTx(ctx context.Context, fn func(tx *sql.Tx, version int) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1);`, migrationsLockID); err != nil {
+		return err
+	}
+
+	var version int
+	if err = tx.QueryRowContext(ctx, querySchemaVersion).Scan(&version); err != nil {
+		return err
+	}
+
+	if err = fn(tx, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// loadMigrations reads migrations from dir of fsys. Migrations are sorted by
+// version.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		base, direction, ok := cutMigrationSuffix(e.Name())
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name: %s", e.Name())
+		}
+
+		v, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(v)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("bad migration version: %s", e.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if m.Name != name {
+			return nil, fmt.Errorf("migration version %d is used by different migrations", version)
+		}
+
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// cutMigrationSuffix splits migration file name into base name and direction
+// ("up" or "down").
+func cutMigrationSuffix(filename string) (base, direction string, ok bool) {
+	if base, ok = strings.CutSuffix(filename, ".up.sql"); ok {
+		return base, "up", true
+	}
+
+	if base, ok = strings.CutSuffix(filename, ".down.sql"); ok {
+		return base, "down", true
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions must be sequential")
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("sorted", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"m/0010_b.up.sql":   {Data: []byte("up b")},
+			"m/0010_b.down.sql": {Data: []byte("down b")},
+			"m/0002_a.up.sql":   {Data: []byte("up a")},
+			"m/0002_a.down.sql": {Data: []byte("down a")},
+		}
+
+		migrations, err := loadMigrations(fsys, "m")
+		require.NoError(t, err)
+		assert.Equal(t, []Migration{
+			{Version: 2, Name: "a", Up: "up a", Down: "down a"},
+			{Version: 10, Name: "b", Up: "up b", Down: "down b"},
+		}, migrations)
+	})
+
+	tests := map[string]fstest.MapFS{
+		"missing down": {
+			"m/0001_a.up.sql": {Data: []byte("up")},
+		},
+		"bad version": {
+			"m/x_a.up.sql":   {Data: []byte("up")},
+			"m/x_a.down.sql": {Data: []byte("down")},
+		},
+		"bad suffix": {
+			"m/0001_a.sql": {Data: []byte("up")},
+		},
+		"duplicate version": {
+			"m/0001_a.up.sql":   {Data: []byte("up")},
+			"m/0001_a.down.sql": {Data: []byte("down")},
+			"m/0001_b.up.sql":   {Data: []byte("up")},
+			"m/0001_b.down.sql": {Data: []byte("down")},
+		},
+	}
+
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadMigrations(fsys, "m")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMigrator_Down(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	m, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	// schema is restored for the other tests
+	defer func() {
+		require.NoError(t, m.Up(ctx))
+	}()
+
+	require.NoError(t, m.Down(ctx, 2))
+
+	version, err := m.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, m.migrations[len(m.migrations)-3].Version, version)
+	assert.ErrorIs(t, m.Verify(ctx), ErrSchemaOutdated)
+
+	require.NoError(t, m.Up(ctx))
+	require.NoError(t, m.Verify(ctx))
+}
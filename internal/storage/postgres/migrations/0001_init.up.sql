@@ -0,0 +1,13 @@
+-- IF NOT EXISTS keeps databases created before migrations were introduced
+-- compatible: existing tables are adopted as is.
+CREATE TABLE IF NOT EXISTS counters (
+	name varchar(500) NOT NULL PRIMARY KEY,
+	value bigint NOT NULL DEFAULT 0,
+	updated timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS gauges (
+	name varchar(500) NOT NULL PRIMARY KEY,
+	value double precision NOT NULL DEFAULT 0,
+	updated timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS counters_history (
+	id bigserial PRIMARY KEY,
+	name varchar(500) NOT NULL,
+	value bigint NOT NULL,
+	ts timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS counters_history_name_ts_idx ON counters_history (name, ts);
+
+CREATE TABLE IF NOT EXISTS gauges_history (
+	id bigserial PRIMARY KEY,
+	name varchar(500) NOT NULL,
+	value double precision NOT NULL,
+	ts timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS gauges_history_name_ts_idx ON gauges_history (name, ts);
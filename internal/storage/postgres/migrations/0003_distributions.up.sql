@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS histograms (
+	name varchar(500) NOT NULL PRIMARY KEY,
+	value jsonb NOT NULL,
+	updated timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS summaries (
+	name varchar(500) NOT NULL PRIMARY KEY,
+	value jsonb NOT NULL,
+	updated timestamptz NOT NULL DEFAULT now()
+);
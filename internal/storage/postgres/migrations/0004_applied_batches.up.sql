@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS applied_batches (
+	key varchar(200) NOT NULL PRIMARY KEY,
+	applied_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS applied_batches_applied_at_idx ON applied_batches (applied_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS gauges_history_name_id_idx;
+
+DROP INDEX IF EXISTS counters_history_name_id_idx;
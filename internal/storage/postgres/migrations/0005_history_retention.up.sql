@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS counters_history_name_id_idx ON counters_history (name, id);
+
+CREATE INDEX IF NOT EXISTS gauges_history_name_id_idx ON gauges_history (name, id);
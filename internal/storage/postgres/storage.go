@@ -4,23 +4,187 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"time"
 
+	"github.com/Dmitrevicz/gometrics/internal/model"
 	"github.com/Dmitrevicz/gometrics/internal/storage"
 )
 
+// DefaultQueryTimeout is a default timeout of a single storage operation.
+const DefaultQueryTimeout = 5 * time.Second
+
+// DefaultHistorySize is a default max number of values kept in history for
+// every metric.
+const DefaultHistorySize = 1000
+
 type Storage struct {
-	db       *sql.DB
-	gauges   *GaugesRepo
-	counters *CountersRepo
+	db *sql.DB
+
+	// queryTimeout limits duration of every storage operation (query or
+	// transaction), so hung database doesn't stall callers forever
+	queryTimeout time.Duration
+
+	// historySize is a max number of values kept in history for every
+	// metric, older values are deleted on insert
+	historySize int
+
+	gauges     *GaugesRepo
+	counters   *CountersRepo
+	histograms *HistogramsRepo
+	summaries  *SummariesRepo
 }
 
 func New(db *sql.DB) *Storage {
-	return &Storage{
-		db: db,
+	return NewWithQueryTimeout(db, DefaultQueryTimeout)
+}
+
+// NewWithQueryTimeout creates new Storage which limits every operation with
+// queryTimeout. Non-positive timeout disables the limit, so only deadline of
+// the caller's context is respected.
+func NewWithQueryTimeout(db *sql.DB, queryTimeout time.Duration) *Storage {
+	return NewWithLimits(db, queryTimeout, DefaultHistorySize)
+}
+
+// NewWithLimits is the same as NewWithQueryTimeout, but also keeps at most
+// historySize values in history for every metric.
+func NewWithLimits(db *sql.DB, queryTimeout time.Duration, historySize int) *Storage {
+	if historySize < 1 {
+		historySize = DefaultHistorySize
 	}
+
+	s := &Storage{
+		db:           db,
+		queryTimeout: queryTimeout,
+		historySize:  historySize,
+	}
+
+	// repositories are created up front, so concurrent callers never race
+	// to create them
+	s.gauges = NewGaugesRepo(s)
+	s.counters = NewCountersRepo(s)
+	s.histograms = NewHistogramsRepo(s)
+	s.summaries = NewSummariesRepo(s)
+
+	return s
+}
+
+// withTimeout derives context limited by the query timeout.
+func (s *Storage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx, so repository queries
+// can be run either on their own or as a part of a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// BatchUpdate applies metrics of all types in a single transaction.
+//
+// Idempotency key of the batch is recorded in the same transaction, so batch
+// is either applied along with its key or not applied at all. When the key
+// has already been recorded, storage.ErrDuplicateBatch is returned.
+func (s *Storage) BatchUpdate(ctx context.Context, batch model.Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if batch.IdempotencyKey != "" {
+		if err = s.recordIdempotencyKey(ctx, tx, batch.IdempotencyKey); err != nil {
+			return err
+		}
+	}
+
+	if err = s.gauges.batchUpdate(ctx, tx, batch.Gauges); err != nil {
+		return err
+	}
+
+	if err = s.counters.batchUpdate(ctx, tx, batch.Counters); err != nil {
+		return err
+	}
+
+	if err = s.histograms.batchUpdate(ctx, tx, batch.Histograms); err != nil {
+		return err
+	}
+
+	if err = s.summaries.batchUpdate(ctx, tx, batch.Summaries); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const (
+	// expired key is taken over by the new batch, as if it has been deleted
+	queryInsertAppliedBatch = `
+		INSERT INTO applied_batches (key) VALUES ($1)
+		ON CONFLICT (key) DO UPDATE SET applied_at = now()
+		WHERE applied_batches.applied_at < now() - make_interval(secs => $2);
+	`
+	// keys being deleted by concurrent batches are skipped, so cleanup never
+	// makes batches wait for each other
+	queryDeleteExpiredBatches = `
+		DELETE FROM applied_batches WHERE key IN (
+			SELECT key FROM applied_batches
+			WHERE applied_at < now() - make_interval(secs => $1)
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		);
+	`
+)
+
+// recordIdempotencyKey records key within tx. When the key is already
+// recorded by another transaction, insert waits for it to finish, so
+// concurrent retries of the same batch are never applied twice.
+func (s *Storage) recordIdempotencyKey(ctx context.Context, tx *sql.Tx, key string) error {
+	ttl := storage.IdempotencyKeyTTL.Seconds()
+
+	res, err := tx.ExecContext(ctx, queryInsertAppliedBatch, key, ttl)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return storage.ErrDuplicateBatch
+	}
+
+	_, err = tx.ExecContext(ctx, queryDeleteExpiredBatches, ttl)
+	return err
+}
+
+// pruneHistory deletes history values of the metrics exceeding history size
+// by query (see queryPruneCountersHistory).
+func (s *Storage) pruneHistory(ctx context.Context, q querier, query string, names []string) error {
+	_, err := q.ExecContext(ctx, query, names, s.historySize-1)
+	return err
 }
 
 func (s *Storage) Ping(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	return s.db.PingContext(ctx)
 }
 
@@ -41,17 +205,17 @@ func (s *Storage) Close(ctx context.Context) (err error) {
 }
 
 func (s *Storage) Gauges() storage.GaugesRepository {
-	if s.gauges == nil {
-		s.gauges = NewGaugesRepo(s)
-	}
-
 	return s.gauges
 }
 
 func (s *Storage) Counters() storage.CountersRepository {
-	if s.counters == nil {
-		s.counters = NewCountersRepo(s)
-	}
-
 	return s.counters
 }
+
+func (s *Storage) Histograms() storage.HistogramsRepository {
+	return s.histograms
+}
+
+func (s *Storage) Summaries() storage.SummariesRepository {
+	return s.summaries
+}
@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/internal/storage"
+)
+
+// SummariesRepo stores summaries as jsonb.
+type SummariesRepo struct {
+	s *Storage
+}
+
+func NewSummariesRepo(storage *Storage) *SummariesRepo {
+	return &SummariesRepo{
+		s: storage,
+	}
+}
+
+const queryGetSummary = `SELECT value FROM summaries WHERE name=$1;`
+
+// Get finds metric by name. When requested metric doesn't exist
+// storage.ErrNotFound error is returned.
+func (r *SummariesRepo) Get(ctx context.Context, name string) (model.Summary, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetSummary)
+	if err != nil {
+		return model.Summary{}, err
+	}
+	defer stmt.Close()
+
+	var (
+		data    []byte
+		summary model.Summary
+	)
+
+	err = stmt.QueryRowContext(ctx, name).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = storage.ErrNotFound
+		}
+		return model.Summary{}, err
+	}
+
+	err = json.Unmarshal(data, &summary)
+
+	return summary, err
+}
+
+const queryGetSummariesAll = `SELECT name, value FROM summaries;`
+
+func (r *SummariesRepo) GetAll(ctx context.Context) (map[string]model.Summary, error) {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryGetSummariesAll)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	summaries := make(map[string]model.Summary)
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			data    []byte
+			summary model.Summary
+			name    string
+		)
+		err = rows.Scan(&name, &data)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &summary); err != nil {
+			return nil, err
+		}
+		summaries[name] = summary
+	}
+
+	return summaries, rows.Err()
+}
+
+const querySetSummary = `
+	INSERT INTO summaries (name, value) VALUES ($1, $2)
+	ON CONFLICT(name) 
+	DO UPDATE SET value=$2, updated=now();
+`
+
+// Set replaces the stored summary or creates if doesn't exist.
+func (r *SummariesRepo) Set(ctx context.Context, name string, value model.Summary) error {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := r.s.db.PrepareContext(ctx, querySetSummary)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, name, data)
+
+	return err
+}
+
+// queryBatchSetSummaries replaces all summaries of the batch in a single
+// statement. Names must be unique (see summaryColumns).
+const queryBatchSetSummaries = `
+	INSERT INTO summaries (name, value)
+	SELECT name, value::jsonb FROM unnest($1::varchar[], $2::text[]) AS u(name, value)
+	ON CONFLICT(name)
+	DO UPDATE SET value=EXCLUDED.value, updated=now();
+`
+
+// BatchUpdate replaces all summaries in a single round trip. When the batch
+// contains the same summary several times, the last value is kept.
+func (r *SummariesRepo) BatchUpdate(ctx context.Context, summaries []model.MetricSummary) (err error) {
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	return r.batchUpdate(ctx, r.s.db, summaries)
+}
+
+func (r *SummariesRepo) batchUpdate(ctx context.Context, q querier, summaries []model.MetricSummary) error {
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	names, values, err := summaryColumns(summaries)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, queryBatchSetSummaries, names, values)
+
+	return err
+}
+
+const queryDeleteSummary = `DELETE FROM summaries WHERE name=$1;`
+
+func (r *SummariesRepo) Delete(ctx context.Context, name string) error {
+	ctx, cancel := r.s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := r.s.db.PrepareContext(ctx, queryDeleteSummary)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, name)
+
+	return err
+}
@@ -3,14 +3,30 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/Dmitrevicz/gometrics/internal/model"
 )
 
-// Storage - a set of repositories
+// IdempotencyKeyTTL is how long storage remembers idempotency keys of applied
+// batches. Agent retries batch for a few seconds at most, so a day is enough
+// with a large margin.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// Storage - a set of repositories.
+//
+// Metrics are identified in repositories by series key - metric name
+// followed by its labels (see model.SeriesKey).
 type Storage interface {
 	Gauges() GaugesRepository
 	Counters() CountersRepository
+	Histograms() HistogramsRepository
+	Summaries() SummariesRepository
+	// BatchUpdate applies metrics of all types atomically: either the whole
+	// batch is stored or nothing is. Idempotency keys of applied batches are
+	// remembered for IdempotencyKeyTTL, batch with already applied key is
+	// skipped and storage.ErrDuplicateBatch is returned.
+	BatchUpdate(ctx context.Context, batch model.Batch) error
 	Ping(ctx context.Context) error
 	Close(ctx context.Context) error
 }
@@ -18,19 +34,49 @@ type Storage interface {
 type GaugesRepository interface {
 	// Get finds metric by name. When requested metric doesn't exist
 	// storage.ErrNotFound error is returned.
-	Get(name string) (model.Gauge, error)
-	GetAll() (map[string]model.Gauge, error)
-	Set(name string, value model.Gauge) error
-	Delete(name string) error
-	BatchUpdate(gauges []model.MetricGauge) (err error)
+	Get(ctx context.Context, name string) (model.Gauge, error)
+	GetAll(ctx context.Context) (map[string]model.Gauge, error)
+	Set(ctx context.Context, name string, value model.Gauge) error
+	Delete(ctx context.Context, name string) error
+	BatchUpdate(ctx context.Context, gauges []model.MetricGauge) (err error)
+	// History returns values recorded for the metric within [from, to] range
+	// ordered by time. Empty slice is returned when nothing was recorded.
+	History(ctx context.Context, name string, from, to time.Time) ([]model.GaugeRecord, error)
 }
 
 type CountersRepository interface {
 	// Get finds metric by name. When requested metric doesn't exist
 	// storage.ErrNotFound error is returned.
-	Get(name string) (model.Counter, error)
-	GetAll() (map[string]model.Counter, error)
-	Set(name string, value model.Counter) error
-	Delete(name string) error
-	BatchUpdate(counters []model.MetricCounter) (err error)
+	Get(ctx context.Context, name string) (model.Counter, error)
+	GetAll(ctx context.Context) (map[string]model.Counter, error)
+	Set(ctx context.Context, name string, value model.Counter) error
+	Delete(ctx context.Context, name string) error
+	BatchUpdate(ctx context.Context, counters []model.MetricCounter) (err error)
+	// History returns values recorded for the metric within [from, to] range
+	// ordered by time. Empty slice is returned when nothing was recorded.
+	History(ctx context.Context, name string, from, to time.Time) ([]model.CounterRecord, error)
+}
+
+// HistogramsRepository stores histograms. Set and BatchUpdate merge provided
+// observations into the stored histogram (see model.Histogram.Merge).
+type HistogramsRepository interface {
+	// Get finds metric by name. When requested metric doesn't exist
+	// storage.ErrNotFound error is returned.
+	Get(ctx context.Context, name string) (model.Histogram, error)
+	GetAll(ctx context.Context) (map[string]model.Histogram, error)
+	Set(ctx context.Context, name string, value model.Histogram) error
+	Delete(ctx context.Context, name string) error
+	BatchUpdate(ctx context.Context, histograms []model.MetricHistogram) (err error)
+}
+
+// SummariesRepository stores summaries. Set and BatchUpdate replace the stored
+// summary.
+type SummariesRepository interface {
+	// Get finds metric by name. When requested metric doesn't exist
+	// storage.ErrNotFound error is returned.
+	Get(ctx context.Context, name string) (model.Summary, error)
+	GetAll(ctx context.Context) (map[string]model.Summary, error)
+	Set(ctx context.Context, name string, value model.Summary) error
+	Delete(ctx context.Context, name string) error
+	BatchUpdate(ctx context.Context, summaries []model.MetricSummary) (err error)
 }
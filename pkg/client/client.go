@@ -0,0 +1,419 @@
+// Package client implements embeddable client for pushing custom application
+// metrics to the gometrics server without running the Agent.
+//
+// Metrics are buffered locally and flushed to the server in batches, either
+// periodically or by calling Flush. Wire formats are the same as the Agent's
+// ones: JSON batch to /updates/ HTTP endpoint or UpdateBatch gRPC method,
+// both gzip compressed, optionally signed with HMAC-SHA256 and encrypted with
+// RSA public key (see encryptor package).
+//
+// Usage:
+//
+//	c, err := client.New(client.Config{Address: "http://localhost:8080"})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close(context.Background())
+//
+//	requests, err := c.Counter("http_requests", client.Labels{"handler": "/"})
+//	if err != nil {
+//		return err
+//	}
+//	requests.Add(1)
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+)
+
+// Defaults used for zero Config values.
+const (
+	DefaultFlushInterval = 10 * time.Second
+	DefaultTimeout       = 10 * time.Second
+)
+
+// ErrRejected is returned by Flush when the server refused to accept the batch
+// (e.g. metric name is malformed or hash doesn't match). Rejected batch is
+// dropped, since sending it again would be rejected as well.
+var ErrRejected = errors.New("batch rejected by the server")
+
+// Labels are metric labels, label names must match [a-zA-Z_][a-zA-Z0-9_]*.
+type Labels map[string]string
+
+// Config configures Client. Either Address or GRPCAddress must be set.
+type Config struct {
+	// Address is a base URL of the server HTTP API, e.g.
+	// "http://localhost:8080".
+	Address string
+
+	// GRPCAddress is an address of the server gRPC API, e.g.
+	// "localhost:3200". gRPC is used instead of HTTP when set.
+	GRPCAddress string
+
+	// Key signs request body with HMAC-SHA256.
+	Key string
+
+	// CryptoKey is a path to the file with server RSA public key, request
+	// body is encrypted with it when set.
+	CryptoKey string
+
+	// HostIP is sent in X-Real-IP header (x-real-ip metadata for gRPC),
+	// required when the server checks trusted subnet.
+	HostIP string
+
+	// Labels are attached to every metric, metric own labels win on
+	// conflict.
+	Labels Labels
+
+	// FlushInterval is an interval of periodic flushing, DefaultFlushInterval
+	// is used when zero. Periodic flushing is disabled when negative.
+	FlushInterval time.Duration
+
+	// Timeout limits every flush request, DefaultTimeout is used when zero.
+	Timeout time.Duration
+
+	// ClientID identifies the client in batch idempotency keys, so retried
+	// batch is never applied twice. Random ID is generated when empty. Keys
+	// also include random part generated by New, so ClientID may be reused
+	// after restart.
+	ClientID string
+
+	// OnError is called when periodic flush fails. Errors are ignored when
+	// nil.
+	OnError func(err error)
+}
+
+// transport sends batch of metrics to the server.
+type transport interface {
+	send(ctx context.Context, batch []model.Metrics, idempotencyKey string) error
+	close() error
+}
+
+// Client buffers metrics and flushes them to the server. Client is safe for
+// concurrent use.
+type Client struct {
+	transport transport
+	labels    model.Labels
+	clientID  string
+	timeout   time.Duration
+	onError   func(err error)
+
+	mu       sync.Mutex // guards metrics maps
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+
+	flushMu sync.Mutex // serializes flushes
+	// instance is random, so batch sequence numbers of a restarted client
+	// reusing its ClientID don't produce keys of already applied batches
+	instance string
+	seq      uint64
+	// unsent is a batch failed to be sent, it's sent again with the same
+	// idempotency key before any new data
+	unsent *pendingBatch
+
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type pendingBatch struct {
+	metrics []model.Metrics
+	key     string
+}
+
+// New creates Client and starts periodic flushing.
+func New(cfg Config) (*Client, error) {
+	labels := model.Labels(cfg.Labels)
+	if err := labels.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		t   transport
+		err error
+	)
+
+	switch {
+	case cfg.GRPCAddress != "":
+		t, err = newGRPCTransport(cfg)
+	case cfg.Address != "":
+		t, err = newHTTPTransport(cfg)
+	default:
+		err = errors.New("either Address or GRPCAddress must be set")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ClientID == "" {
+		if cfg.ClientID, err = generateClientID(); err != nil {
+			return nil, err
+		}
+	}
+
+	instance, err := generateClientID()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+
+	c := &Client{
+		transport: t,
+		labels:    labels,
+		clientID:  cfg.ClientID,
+		instance:  instance,
+		timeout:   cfg.Timeout,
+		onError:   cfg.OnError,
+		counters:  make(map[string]*Counter),
+		gauges:    make(map[string]*Gauge),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if cfg.FlushInterval > 0 {
+		go c.run(cfg.FlushInterval)
+	} else {
+		close(c.done)
+	}
+
+	return c, nil
+}
+
+// generateClientID returns random hex encoded client ID.
+func generateClientID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate client ID: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (c *Client) run(interval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+			if err := c.Flush(context.Background()); err != nil && c.onError != nil {
+				c.onError(err)
+			}
+		}
+	}
+}
+
+// Counter returns counter with name and labels, the same counter is returned
+// for the same name and labels.
+func (c *Client) Counter(name string, labels Labels) (*Counter, error) {
+	key, metricLabels, err := c.series(name, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter, ok := c.counters[key]
+	if !ok {
+		counter = &Counter{name: name, labels: metricLabels}
+		c.counters[key] = counter
+	}
+
+	return counter, nil
+}
+
+// Gauge returns gauge with name and labels, the same gauge is returned for
+// the same name and labels.
+func (c *Client) Gauge(name string, labels Labels) (*Gauge, error) {
+	key, metricLabels, err := c.series(name, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	gauge, ok := c.gauges[key]
+	if !ok {
+		gauge = &Gauge{name: name, labels: metricLabels}
+		c.gauges[key] = gauge
+	}
+
+	return gauge, nil
+}
+
+// series merges labels with the client ones and returns series key of the
+// metric.
+func (c *Client) series(name string, labels Labels) (string, model.Labels, error) {
+	if name == "" {
+		return "", nil, errors.New("metric name must not be empty")
+	}
+
+	merged := make(model.Labels, len(c.labels)+len(labels))
+	for k, v := range c.labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	key, err := model.SeriesKey(name, merged)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, merged, nil
+}
+
+// Flush sends buffered metrics to the server. When sending fails, the batch
+// is kept and sent again by the next Flush, unless it's been rejected by the
+// server (see ErrRejected).
+func (c *Client) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	if c.unsent != nil {
+		if err := c.send(ctx, c.unsent); err != nil {
+			return err
+		}
+	}
+
+	metrics := c.collect()
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	c.seq++
+	batch := &pendingBatch{
+		metrics: metrics,
+		key:     c.clientID + "-" + c.instance + "-" + strconv.FormatUint(c.seq, 10),
+	}
+
+	return c.send(ctx, batch)
+}
+
+// send sends batch, failed batch is kept to be sent again.
+func (c *Client) send(ctx context.Context, batch *pendingBatch) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	err := c.transport.send(ctx, batch.metrics, batch.key)
+	if err != nil && !errors.Is(err, ErrRejected) {
+		c.unsent = batch
+	} else {
+		c.unsent = nil
+	}
+
+	return err
+}
+
+// collect takes buffered values: counters increments and gauges set since the
+// previous collection.
+func (c *Client) collect() []model.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var metrics []model.Metrics
+
+	for _, counter := range c.counters {
+		delta := counter.delta.Swap(0)
+		if delta == 0 {
+			continue
+		}
+
+		metrics = append(metrics, model.Metrics{
+			ID:     counter.name,
+			MType:  model.MetricTypeCounter,
+			Delta:  &delta,
+			Labels: counter.labels,
+		})
+	}
+
+	for _, gauge := range c.gauges {
+		if !gauge.dirty.Swap(false) {
+			continue
+		}
+
+		value := math.Float64frombits(gauge.value.Load())
+		metrics = append(metrics, model.Metrics{
+			ID:     gauge.name,
+			MType:  model.MetricTypeGauge,
+			Value:  &value,
+			Labels: gauge.labels,
+		})
+	}
+
+	return metrics
+}
+
+// Close stops periodic flushing, flushes buffered metrics and closes
+// connection to the server.
+func (c *Client) Close(ctx context.Context) error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		close(c.quit)
+		<-c.done
+
+		err = c.Flush(ctx)
+		err = errors.Join(err, c.transport.close())
+	})
+
+	return err
+}
+
+// Counter is a metric which value is increased by the server with every
+// received increment. Only increments are buffered and sent.
+type Counter struct {
+	name   string
+	labels model.Labels
+	delta  atomic.Int64
+}
+
+// Add adds delta to the counter.
+func (c *Counter) Add(delta int64) {
+	c.delta.Add(delta)
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.delta.Add(1)
+}
+
+// Gauge is a metric which value is replaced by the server with the received
+// one. Only the last set value is sent.
+type Gauge struct {
+	name   string
+	labels model.Labels
+	value  atomic.Uint64 // float64 bits
+	dirty  atomic.Bool   // set since the last flush
+}
+
+// Set sets the gauge value.
+func (g *Gauge) Set(value float64) {
+	g.value.Store(math.Float64bits(value))
+	g.dirty.Store(true)
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Dmitrevicz/gometrics/internal/logger"
+	"github.com/Dmitrevicz/gometrics/internal/server"
+	"github.com/Dmitrevicz/gometrics/internal/server/config"
+	grpcServer "github.com/Dmitrevicz/gometrics/internal/server/grpc"
+	pb "github.com/Dmitrevicz/gometrics/internal/server/grpc/proto"
+	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestClient_Flush(t *testing.T) {
+	pub, priv := prepareRSAKeyFiles(t)
+	hashKey := "8bb5929d212764f7923ae9998fa18aa46ca4ee8b1cfd319b"
+
+	cfgServer := config.NewTesting()
+	cfgServer.CryptoKey = priv
+	cfgServer.Key = hashKey
+
+	ts := httptest.NewServer(server.New(cfgServer))
+	defer ts.Close()
+
+	c, err := New(Config{
+		Address:       ts.URL,
+		Key:           hashKey,
+		CryptoKey:     pub,
+		Labels:        Labels{"service": "billing"},
+		FlushInterval: -1,
+	})
+	require.NoError(t, err)
+
+	requests, err := c.Counter("requests", Labels{"handler": "pay"})
+	require.NoError(t, err)
+	queue, err := c.Gauge("queue", nil)
+	require.NoError(t, err)
+
+	same, err := c.Counter("requests", Labels{"handler": "pay"})
+	require.NoError(t, err)
+	assert.Same(t, requests, same)
+
+	requests.Add(2)
+	requests.Inc()
+	queue.Set(7.5)
+	require.NoError(t, c.Flush(context.Background()))
+
+	requests.Add(4)
+	require.NoError(t, c.Close(context.Background()))
+
+	assert.Equal(t, "7", getValue(t, ts.URL+"/value/counter/requests?label=handler:pay&label=service:billing"))
+	assert.Equal(t, "7.5", getValue(t, ts.URL+"/value/gauge/queue?label=service:billing"))
+}
+
+func TestClient_FlushRetry(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		keys []string
+		fail = true
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		keys = append(keys, r.Header.Get(idempotencyKeyHeader))
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := New(Config{Address: ts.URL, ClientID: "svc", FlushInterval: -1})
+	require.NoError(t, err)
+	defer c.Close(context.Background())
+
+	counter, err := c.Counter("requests", nil)
+	require.NoError(t, err)
+
+	counter.Add(1)
+	require.Error(t, c.Flush(context.Background()))
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+
+	// failed batch is resent with the same key, new data goes in the next one
+	counter.Add(1)
+	require.NoError(t, c.Flush(context.Background()))
+
+	// nothing to be sent
+	require.NoError(t, c.Flush(context.Background()))
+
+	prefix := "svc-" + c.instance + "-"
+	assert.Equal(t, []string{prefix + "1", prefix + "1", prefix + "2"}, keys)
+
+	// restarted client reusing its ClientID doesn't repeat keys
+	restarted, err := New(Config{Address: ts.URL, ClientID: "svc", FlushInterval: -1})
+	require.NoError(t, err)
+	defer restarted.Close(context.Background())
+	assert.NotEqual(t, c.instance, restarted.instance)
+}
+
+func TestClient_FlushRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	c, err := New(Config{Address: ts.URL, FlushInterval: -1})
+	require.NoError(t, err)
+	defer c.Close(context.Background())
+
+	gauge, err := c.Gauge("queue", nil)
+	require.NoError(t, err)
+
+	gauge.Set(1)
+	require.ErrorIs(t, c.Flush(context.Background()), ErrRejected)
+
+	// rejected batch is dropped
+	require.NoError(t, c.Flush(context.Background()))
+}
+
+func TestClient_GRPC(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	pub, priv := prepareRSAKeyFiles(t)
+	hashKey := "8bb5929d212764f7923ae9998fa18aa46ca4ee8b1cfd319b"
+
+	cfgServer := config.NewTesting()
+	cfgServer.CryptoKey = priv
+	cfgServer.Key = hashKey
+	cfgServer.TrustedSubnet = "127.0.0.0/8"
+
+	metricsServer := grpcServer.NewMetricsServer(cfgServer)
+	s := grpc.NewServer(grpcServer.Interceptors(logger.Log, cfgServer)...)
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(listen)
+	defer s.Stop()
+
+	c, err := New(Config{
+		GRPCAddress:   listen.Addr().String(),
+		Key:           hashKey,
+		CryptoKey:     pub,
+		HostIP:        "127.0.0.1",
+		FlushInterval: -1,
+	})
+	require.NoError(t, err)
+
+	counter, err := c.Counter("requests", Labels{"handler": "pay"})
+	require.NoError(t, err)
+
+	counter.Add(3)
+	require.NoError(t, c.Flush(context.Background()))
+	counter.Add(2)
+	require.NoError(t, c.Close(context.Background()))
+
+	v, err := metricsServer.Storage.Counters().Get(context.Background(), `requests{handler="pay"}`)
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, v)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{})
+	require.Error(t, err, "address is required")
+
+	_, err = New(Config{Address: "http://localhost", Labels: Labels{"bad-name": "v"}})
+	require.Error(t, err)
+
+	c, err := New(Config{Address: "http://localhost", FlushInterval: -1})
+	require.NoError(t, err)
+
+	_, err = c.Counter("", nil)
+	require.Error(t, err)
+	_, err = c.Gauge("bad{name}", nil)
+	require.Error(t, err)
+}
+
+func getValue(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	return string(body)
+}
+
+func prepareRSAKeyFiles(t *testing.T) (pub, priv string) {
+	t.Helper()
+
+	privateKey, err := encryptor.GenerateKeys(2048)
+	require.NoError(t, err)
+
+	privatePEM, err := encryptor.FormatPrivateKey(privateKey)
+	require.NoError(t, err)
+	publicPEM, err := encryptor.FormatPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	pub, priv = filepath.Join(dir, "public.pem"), filepath.Join(dir, "private.pem")
+
+	require.NoError(t, os.WriteFile(pub, publicPEM, 0600))
+	require.NoError(t, os.WriteFile(priv, privatePEM, 0600))
+
+	return pub, priv
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	pb "github.com/Dmitrevicz/gometrics/internal/server/grpc/proto"
+	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// grpcTransport sends batches with UpdateBatch method. Connection is
+// established once and reused by every send. Requests are compressed,
+// signed and encrypted by pb.UnaryClientInterceptor.
+type grpcTransport struct {
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+}
+
+func newGRPCTransport(cfg Config) (*grpcTransport, error) {
+	var (
+		enc *encryptor.Encryptor
+		err error
+	)
+
+	if cfg.CryptoKey != "" {
+		if enc, err = encryptor.NewEncryptor(cfg.CryptoKey); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := grpc.Dial(cfg.GRPCAddress,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(pb.UnaryClientInterceptor(cfg.Key, enc, cfg.HostIP)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", cfg.GRPCAddress, err)
+	}
+
+	return &grpcTransport{
+		conn:   conn,
+		client: pb.NewMetricsClient(conn),
+	}, nil
+}
+
+func (t *grpcTransport) send(ctx context.Context, batch []model.Metrics, idempotencyKey string) error {
+	req := &pb.UpdateBatchRequest{
+		Metrics:        make([]*pb.Metric, 0, len(batch)),
+		IdempotencyKey: idempotencyKey,
+	}
+
+	for _, m := range batch {
+		metric := &pb.Metric{
+			Id:     m.ID,
+			Value:  m.Value,
+			Delta:  m.Delta,
+			Labels: m.Labels,
+		}
+
+		switch m.MType {
+		case model.MetricTypeGauge:
+			metric.Type = pb.MetricType_GAUGE
+		case model.MetricTypeCounter:
+			metric.Type = pb.MetricType_COUNTER
+		default:
+			return fmt.Errorf("unexpected metric type %q", m.MType)
+		}
+
+		req.Metrics = append(req.Metrics, metric)
+	}
+
+	if _, err := t.client.UpdateBatch(ctx, req); err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied:
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (t *grpcTransport) close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Dmitrevicz/gometrics/internal/model"
+	"github.com/Dmitrevicz/gometrics/pkg/encryptor"
+)
+
+// Request headers understood by the server.
+const (
+	hashHeader           = "HashSHA256"
+	encryptionHeader     = "Content-Encryption"
+	xRealIPHeader        = "X-Real-IP"
+	idempotencyKeyHeader = "Idempotency-Key"
+)
+
+// httpTransport sends batches to /updates/ endpoint the same way the Agent
+// does: JSON body is gzip compressed and then encrypted, hash is calculated
+// from the uncompressed JSON.
+type httpTransport struct {
+	url       string
+	key       string
+	hostIP    string
+	encryptor *encryptor.Encryptor
+	client    *http.Client
+}
+
+func newHTTPTransport(cfg Config) (*httpTransport, error) {
+	t := &httpTransport{
+		url:    strings.TrimRight(cfg.Address, "/") + "/updates/",
+		key:    cfg.Key,
+		hostIP: cfg.HostIP,
+		client: &http.Client{},
+	}
+
+	if cfg.CryptoKey != "" {
+		var err error
+		if t.encryptor, err = encryptor.NewEncryptor(cfg.CryptoKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func (t *httpTransport) send(ctx context.Context, batch []model.Metrics, idempotencyKey string) error {
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("error while preparing body for request: %w", err)
+	}
+
+	body, err := compress(b)
+	if err != nil {
+		return fmt.Errorf("data compression failed: %w", err)
+	}
+
+	if t.encryptor != nil {
+		if body, err = t.encryptor.Encrypt(body); err != nil {
+			return fmt.Errorf("data encryption failed: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error preparing the request: %w", err)
+	}
+
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+
+	if t.hostIP != "" {
+		req.Header.Set(xRealIPHeader, t.hostIP)
+	}
+
+	if t.encryptor != nil {
+		req.Header.Set(encryptionHeader, "1")
+	}
+
+	if t.key != "" {
+		hasher := hmac.New(sha256.New, []byte(t.key))
+		hasher.Write(b)
+		req.Header.Set(hashHeader, hex.EncodeToString(hasher.Sum(nil)))
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error while doing the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error while reading the response bytes: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected response status code: '%s', body: '%s'", resp.Status, respBody)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (t *httpTransport) close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// compress uses gzip compression.
+func compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zb := gzip.NewWriter(&buf)
+	if _, err := zb.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := zb.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}